@@ -25,6 +25,7 @@ type GinConfig struct {
 	MaxAge              int64                       `yaml:"maxAge"`              // 预检请求的缓存时间（单位: 秒）
 	LoginCheckPassPairs []middleware.PathMethodPair `yaml:"loginCheckPassPairs"` // 绕过登录校验路径
 	AdminCheckPairs     []middleware.PathMethodPair `yaml:"adminCheckPairs"`     // 管理员校验路径
+	TrustedProxies      []string                    `yaml:"trustedProxies"`      // 可信代理网段，仅信任来自这些地址的 X-Forwarded-For，为空表示使用连接地址，部署在负载均衡之后时必须配置
 	Addr                string                      `yaml:"addr"`                // 服务地址
 }
 
@@ -32,6 +33,37 @@ func (GinConfig) Key() string {
 	return "gin"
 }
 
+type RateLimitConfig struct {
+	Rules []middleware.RateLimitRule `yaml:"rules"` // 限流规则，请求需要通过全部命中的规则
+}
+
+func (RateLimitConfig) Key() string {
+	return "rateLimit"
+}
+
+type IdempotencyConfig struct {
+	Enabled      bool `yaml:"enabled"`      // 是否开启，开启后携带 Idempotency-Key 的 /api 非 GET 请求只会转发一次
+	TTL          int  `yaml:"ttl"`          // 响应保留时间（单位: 秒），默认 86400
+	PendingTTL   int  `yaml:"pendingTTL"`   // 处理中标记的保留时间（单位: 秒），应大于请求的最长处理时间，默认 60
+	WaitTimeout  int  `yaml:"waitTimeout"`  // 重复请求等待原始请求完成的最长时间（单位: 毫秒），默认 10000
+	MaxBodyBytes int  `yaml:"maxBodyBytes"` // 请求体和保存的响应体上限（单位: 字节），请求体超过时不做幂等处理，默认 65536
+}
+
+func (IdempotencyConfig) Key() string {
+	return "idempotency"
+}
+
+type CompetitionConfig struct {
+	Cmds       []string `yaml:"cmds"`       // 需要比赛令牌的 cmd，未携带与比赛匹配的比赛令牌时拒绝
+	IDParam    string   `yaml:"idParam"`    // 请求中比赛 ID 的查询参数名，携带时必须与比赛令牌一致，默认 competition_id
+	EarlyLogin int      `yaml:"earlyLogin"` // 比赛开始前允许换取比赛令牌的时间（单位: 分钟）
+	Grace      int      `yaml:"grace"`      // 比赛令牌在比赛结束后继续有效的时间（单位: 秒）
+}
+
+func (CompetitionConfig) Key() string {
+	return "competition"
+}
+
 type DBConfig struct {
 	Host        string `yaml:"host"`
 	Port        int    `yaml:"port"`
@@ -62,8 +94,16 @@ func (RedisConfig) Key() string {
 }
 
 type JWTConfig struct {
-	JWTExpiration int    `yaml:"jwtExpiration"` // jwt token 有效期（单位: 分钟）
-	JWTKey        string `yaml:"jwtKey"`        // jwt 密钥
+	JWTExpiration     int    `yaml:"jwtExpiration"`     // 访问令牌有效期（单位: 分钟）
+	RefreshExpiration int    `yaml:"refreshExpiration"` // 刷新令牌有效期（单位: 分钟），每次刷新后重新计算，默认 4320
+	JWTKey            string `yaml:"jwtKey"`            // jwt 密钥
+
+	SessionPolicies []SessionPolicyConfig `yaml:"sessionPolicies"` // 按角色限制同时有效的会话数，未配置的角色只保留最近登录的一个会话
+}
+
+type SessionPolicyConfig struct {
+	Role        int8 `yaml:"role"`        // 用户角色，0-普通用户, 1-管理员
+	MaxSessions int  `yaml:"maxSessions"` // 同时有效的会话数上限，超过时移除最久未活跃的会话，0 表示不限制
 }
 
 func (JWTConfig) Key() string {
@@ -71,7 +111,193 @@ func (JWTConfig) Key() string {
 }
 
 type ProxyConfig struct {
-	Services []string `yaml:"services"` // 服务配置
+	Services   []ServiceConfig     `yaml:"services"`   // 服务配置
+	Routes     []RouteConfig       `yaml:"routes"`     // 路由表，按顺序匹配，为空时使用 /api/:service?cmd=<Name>
+	Transport  TransportConfig     `yaml:"transport"`  // 转发连接池配置，所有服务共享
+	Stream     StreamConfig        `yaml:"stream"`     // WebSocket/SSE 转发配置
+	Admin      AdminConfig         `yaml:"admin"`      // 服务管理接口配置
+	Priorities []PriorityConfig    `yaml:"priorities"` // 请求优先级规则，按顺序匹配，为空时管理员的请求为 high，其它请求为 normal
+	Cache      ResponseCacheConfig `yaml:"cache"`      // 响应缓存配置，各 cmd 在 commands 中单独开启
+}
+
+type ResponseCacheConfig struct {
+	MaxEntries   int   `yaml:"maxEntries"`   // 内存层最大条目数，默认 10000
+	MaxBytes     int64 `yaml:"maxBytes"`     // 内存层最大字节数（单位: 字节），默认 67108864
+	RedisTimeout int   `yaml:"redisTimeout"` // Redis 层读写超时（单位: 毫秒），默认 50
+	PollInterval int   `yaml:"pollInterval"` // 同步缓存版本号的间隔（单位: 秒），默认 1
+}
+
+type PriorityConfig struct {
+	Priority string   `yaml:"priority"` // 优先级: critical, high, normal, low
+	Roles    []int8   `yaml:"roles"`    // 用户角色，为空表示不限制
+	Cmds     []string `yaml:"cmds"`     // cmd，为空表示不限制
+	Routes   []string `yaml:"routes"`   // 路由名称，为空表示不限制
+}
+
+type RouteConfig struct {
+	Name    string        `yaml:"name"`    // 路由名称
+	Kind    string        `yaml:"kind"`    // 路由类型: path (默认) 或 cmd
+	Path    string        `yaml:"path"`    // 公开路径模式，支持 :param 和末尾的 *param，如 /api/v1/problems/:id
+	Methods []string      `yaml:"methods"` // 允许的请求方法，为空表示所有方法
+	Host    string        `yaml:"host"`    // 匹配的 Host，支持 *.example.com，为空表示所有 Host
+	Service string        `yaml:"service"` // 目标服务，cmd 路由为空时取路径参数 :service
+	Cmd     string        `yaml:"cmd"`     // path 路由对应的 cmd，用于权限校验、cmd 级策略和监控
+	Rewrite string        `yaml:"rewrite"` // 上游路径模板，可引用路径参数，为空时使用 /<cmd>；未引用的路径参数以查询参数转发
+	HashKey string        `yaml:"hashKey"` // 一致性哈希键: user (用户 ID)、query:<name> (路径或查询参数)、header:<name>，目标服务需使用 consistent_hash
+	Mirror  *MirrorConfig `yaml:"mirror"`  // 流量镜像配置，为空表示不镜像
+}
+
+type MirrorConfig struct {
+	URL          string  `yaml:"url"`          // 影子上游地址，如 http://controller-staging:8081
+	Percent      float64 `yaml:"percent"`      // 镜像的请求百分比 (0-100]
+	MaxBodyBytes int64   `yaml:"maxBodyBytes"` // 镜像请求体上限（单位: 字节），超过时不镜像，默认 1048576
+	Timeout      int     `yaml:"timeout"`      // 影子请求超时（单位: 毫秒），默认 5000
+}
+
+type TransportConfig struct {
+	MaxIdleConns          int `yaml:"maxIdleConns"`          // 最大空闲连接数，默认 1024
+	MaxIdleConnsPerHost   int `yaml:"maxIdleConnsPerHost"`   // 单个实例最大空闲连接数，默认 256
+	MaxConnsPerHost       int `yaml:"maxConnsPerHost"`       // 单个实例最大连接数，默认 0 (不限制)
+	IdleConnTimeout       int `yaml:"idleConnTimeout"`       // 空闲连接超时（单位: 秒），默认 90
+	DialTimeout           int `yaml:"dialTimeout"`           // 建立连接超时（单位: 毫秒），默认 5000
+	KeepAlive             int `yaml:"keepAlive"`             // TCP keep-alive 间隔（单位: 秒），默认 30
+	TLSHandshakeTimeout   int `yaml:"tlsHandshakeTimeout"`   // TLS 握手超时（单位: 毫秒），默认 10000
+	ResponseHeaderTimeout int `yaml:"responseHeaderTimeout"` // 等待响应头超时（单位: 毫秒），默认 0 (不限制)
+}
+
+type StreamConfig struct {
+	IdleTimeout int `yaml:"idleTimeout"` // 流式连接空闲超时（单位: 秒），默认 300
+}
+
+type AdminConfig struct {
+	PollInterval int `yaml:"pollInterval"` // 检查 Redis 中服务配置版本的间隔（单位: 秒），默认 2
+	AuditSize    int `yaml:"auditSize"`    // 保留的审计记录条数，默认 1000
+}
+
+type ServiceConfig struct {
+	Name             string               `yaml:"name"`             // 服务名称，对应 /api/<name>
+	LoadBalancer     string               `yaml:"loadBalancer"`     // 负载均衡策略: round_robin, random, weighted_random, weighted_round_robin, least_conn, consistent_hash
+	HashLoadFactor   float64              `yaml:"hashLoadFactor"`   // consistent_hash: 实例进行中请求数上限相对平均值的倍数，默认 1.25
+	Instances        []InstanceConfig     `yaml:"instances"`        // 服务实例列表
+	HealthCheck      HealthCheckConfig    `yaml:"healthCheck"`      // 健康检查配置
+	CircuitBreaker   CircuitBreakerConfig `yaml:"circuitBreaker"`   // 服务级熔断配置
+	OutlierDetection CircuitBreakerConfig `yaml:"outlierDetection"` // 实例级被动异常检测配置
+	Retry            RetryConfig          `yaml:"retry"`            // 服务级重试配置
+	ConcurrencyLimit ConcurrencyConfig    `yaml:"concurrencyLimit"` // 服务级并发限制配置
+	Timeout          int                  `yaml:"timeout"`          // 服务级转发超时（单位: 毫秒），包含重试，0 表示不限制
+	Commands         []CommandConfig      `yaml:"commands"`         // cmd 级配置，覆盖服务级配置
+	Protocol         string               `yaml:"protocol"`         // 后端协议: http (默认) 或 grpc
+	GRPC             GRPCConfig           `yaml:"grpc"`             // gRPC 服务配置，protocol 为 grpc 时生效
+	Discovery        DiscoveryConfig      `yaml:"discovery"`        // 服务发现配置，配置后 instances 仅作为初始实例
+	Variants         []VariantConfig      `yaml:"variants"`         // 发布版本 (如灰度版本)，按顺序匹配，未命中的请求使用 instances
+}
+
+type VariantConfig struct {
+	Name         string           `yaml:"name"`         // 版本名称，用于监控标签和主动选择，不能为 stable
+	Instances    []InstanceConfig `yaml:"instances"`    // 版本的实例列表
+	LoadBalancer string           `yaml:"loadBalancer"` // 负载均衡策略，为空时与服务相同
+	Percent      float64          `yaml:"percent"`      // 分配到该版本的流量百分比 (0-100)，已登录用户按用户 ID 固定分配
+	Admin        bool             `yaml:"admin"`        // 管理员的请求全部进入该版本
+	OptIn        bool             `yaml:"optIn"`        // 允许通过 X-Release-Variant 请求头或 release_variant Cookie 选择该版本
+	Users        []uint64         `yaml:"users"`        // 用户 ID 白名单，白名单用户的请求全部进入该版本
+}
+
+type DiscoveryConfig struct {
+	Type   string                `yaml:"type"`   // 服务发现类型: file, dns, consul，为空表示使用静态 instances
+	File   string                `yaml:"file"`   // file: 实例文件路径，格式与 proxy.services 相同，文件变化后自动重新加载
+	DNS    DNSDiscoveryConfig    `yaml:"dns"`    // dns: 定期解析 A/SRV 记录
+	Consul ConsulDiscoveryConfig `yaml:"consul"` // consul: 阻塞查询 Consul 兼容的健康实例接口
+}
+
+type DNSDiscoveryConfig struct {
+	Name     string `yaml:"name"`     // 域名，SRV 记录如 _http._tcp.controller.default.svc.cluster.local
+	Record   string `yaml:"record"`   // 记录类型: a (默认) 或 srv
+	Port     int    `yaml:"port"`     // A 记录使用的实例端口
+	Scheme   string `yaml:"scheme"`   // 实例协议，默认 http
+	Interval int    `yaml:"interval"` // 重新解析间隔（单位: 秒），默认 30
+}
+
+type ConsulDiscoveryConfig struct {
+	Address    string `yaml:"address"`    // Consul HTTP 地址，如 http://consul:8500
+	Service    string `yaml:"service"`    // Consul 中注册的服务名，默认与服务名称相同
+	Tag        string `yaml:"tag"`        // 仅使用带有该标签的实例
+	Datacenter string `yaml:"datacenter"` // 数据中心，为空表示 agent 所在数据中心
+	Token      string `yaml:"token"`      // ACL token
+	Scheme     string `yaml:"scheme"`     // 实例协议，默认 http
+	Wait       int    `yaml:"wait"`       // 阻塞查询最长等待时间（单位: 秒），默认 30
+}
+
+type GRPCConfig struct {
+	Service       string   `yaml:"service"`       // proto 服务全名，如 judge.v1.JudgeService，cmd 对应其中的方法名
+	DescriptorSet string   `yaml:"descriptorSet"` // protoc --include_imports --descriptor_set_out 生成的描述文件路径
+	TrustedCIDRs  []string `yaml:"trustedCIDRs"`  // 允许直接使用 gRPC (h2c) 访问的客户端网段，为空时仅支持 JSON 转码
+}
+
+type CommandConfig struct {
+	Name     string          `yaml:"name"`     // cmd 名称，如 GetProblem
+	Retry    *RetryConfig    `yaml:"retry"`    // 重试配置，为空时使用服务级配置
+	Timeout  int             `yaml:"timeout"`  // 转发超时（单位: 毫秒），0 表示使用服务级配置
+	Cache    *CacheConfig    `yaml:"cache"`    // 响应缓存配置，为空表示不缓存
+	Coalesce *CoalesceConfig `yaml:"coalesce"` // 合并相同并发读请求的配置，为空表示不合并
+}
+
+type CoalesceConfig struct {
+	Scope        string   `yaml:"scope"`        // 共享响应的维度: public (默认，所有用户共享)、role (按角色)、user (按用户)
+	MaxBodyBytes int64    `yaml:"maxBodyBytes"` // 共享的响应体上限（单位: 字节），超过时等待的请求各自转发，默认 1048576
+	VaryHeaders  []string `yaml:"varyHeaders"`  // 参与合并键的请求头，如 X-Description-Hash
+}
+
+type CacheConfig struct {
+	Scope        string   `yaml:"scope"`        // 缓存维度: public (默认，所有用户共享)、role (按角色)、user (按用户)
+	TTL          int      `yaml:"ttl"`          // 最长缓存时间（单位: 秒），后端 Cache-Control 的 max-age 更短时以后端为准
+	MaxBodyBytes int64    `yaml:"maxBodyBytes"` // 响应体上限（单位: 字节），超过时不缓存，默认 1048576
+	VaryHeaders  []string `yaml:"varyHeaders"`  // 参与缓存键的请求头，如 X-Description-Hash；后端 Vary 其它请求头时不缓存
+}
+
+type RetryConfig struct {
+	Enabled             bool  `yaml:"enabled"`             // 是否开启
+	MaxAttempts         int   `yaml:"maxAttempts"`         // 最大尝试次数（包含首次请求），默认 2
+	BackoffBase         int   `yaml:"backoffBase"`         // 退避基准时间（单位: 毫秒），默认 50
+	BackoffMax          int   `yaml:"backoffMax"`          // 退避时间上限（单位: 毫秒），默认 1000
+	RetryOnConnectError bool  `yaml:"retryOnConnectError"` // 连接失败或连接被重置时重试
+	RetryOnStatus       []int `yaml:"retryOnStatus"`       // 后端返回这些状态码时重试，如 502, 503
+	Safe                bool  `yaml:"safe"`                // 允许非幂等方法 (POST 等) 重试，仅对确认可重复执行的 cmd 开启
+}
+
+type HealthCheckConfig struct {
+	Enabled            bool   `yaml:"enabled"`            // 是否开启主动健康检查
+	Path               string `yaml:"path"`               // 健康检查路径，默认 /health
+	Interval           int    `yaml:"interval"`           // 检查间隔（单位: 秒），默认 30
+	Timeout            int    `yaml:"timeout"`            // 检查超时（单位: 秒），默认 5
+	HealthyThreshold   int    `yaml:"healthyThreshold"`   // 连续成功多少次后恢复，默认 1
+	UnhealthyThreshold int    `yaml:"unhealthyThreshold"` // 连续失败多少次后剔除，默认 1
+}
+
+type CircuitBreakerConfig struct {
+	Enabled             bool    `yaml:"enabled"`             // 是否开启
+	ConsecutiveFailures int     `yaml:"consecutiveFailures"` // 连续失败多少次后熔断，0 表示不按连续失败熔断
+	FailureRatio        float64 `yaml:"failureRatio"`        // 滑动窗口内 5xx/连接错误比例达到多少后熔断，0 表示不按比例熔断
+	MinRequests         int     `yaml:"minRequests"`         // 滑动窗口内至少多少个请求才按比例判断，默认 20
+	Window              int     `yaml:"window"`              // 滑动窗口长度（单位: 秒），默认 10
+	Cooldown            int     `yaml:"cooldown"`            // 熔断多久后进入半开状态（单位: 秒），默认 30
+	HalfOpenRequests    int     `yaml:"halfOpenRequests"`    // 半开状态允许通过的探测请求数，默认 1
+}
+
+type ConcurrencyConfig struct {
+	Enabled          bool    `yaml:"enabled"`          // 是否开启
+	MaxInFlight      int     `yaml:"maxInFlight"`      // 最大进行中请求数，自适应模式下为上限的最大值
+	MaxQueue         int     `yaml:"maxQueue"`         // 排队等待的请求数上限，0 表示不排队，此时请求优先级不生效
+	QueueTimeout     int     `yaml:"queueTimeout"`     // 排队等待超时（单位: 毫秒），默认 1000
+	Adaptive         bool    `yaml:"adaptive"`         // 根据延迟和失败自动调整上限
+	MinInFlight      int     `yaml:"minInFlight"`      // 自适应模式下上限的最小值，默认 1
+	LatencyThreshold int     `yaml:"latencyThreshold"` // 自适应模式: 请求延迟超过该值时减小上限（单位: 毫秒），0 表示按延迟均值的变化判断
+	LatencyTolerance float64 `yaml:"latencyTolerance"` // 自适应模式: 短期延迟均值超过长期均值的多少倍时减小上限，默认 2
+	Backoff          float64 `yaml:"backoff"`          // 自适应模式: 减小上限时乘以的系数 (0-1)，默认 0.9
+}
+
+type InstanceConfig struct {
+	URL    string `yaml:"url"`    // 实例地址，格式: http://host:port 或 host:port
+	Weight int    `yaml:"weight"` // 权重，默认 1
 }
 
 func (ProxyConfig) Key() string {
@@ -4,18 +4,35 @@ const GatewayServiceName = "OnlineJudge-Gateway"
 
 const ProxyKey = "cmd" // 代理时需要转发的路径的查询参数键
 
+const AdminPathPrefix = "/admin/" // 管理接口路径前缀，始终要求管理员权限
+
 const (
-	HeaderForwardedByKey = "X-Forwarded-By"
-	HeaderUserIDKey      = "X-User-ID"
-	HeaderRequestIDKey   = "X-Request-ID"
-	HeaderProxyByKey     = "X-Proxy-By"
-	HeaderLoginTokenKey  = "X-JWT-Token"
+	HeaderForwardedByKey      = "X-Forwarded-By"
+	HeaderUserIDKey           = "X-User-ID"
+	HeaderRequestIDKey        = "X-Request-ID"
+	HeaderProxyByKey          = "X-Proxy-By"
+	HeaderLoginTokenKey       = "X-JWT-Token"
+	HeaderRefreshTokenKey     = "X-Refresh-Token"
+	HeaderCompetitionTokenKey = "X-Competition-JWT-Token" // 比赛令牌
+	HeaderCompetitionIDKey    = "X-Competition-ID"        // 比赛令牌校验后的比赛 ID，由网关写入转发请求
+	HeaderTimeoutKey          = "X-Request-Timeout"       // 转发请求剩余的处理时间（单位: 毫秒）
+	HeaderVariantKey          = "X-Release-Variant"       // 主动选择的发布版本，响应中返回实际使用的版本
+	HeaderShadowKey           = "X-Shadow-Request"        // 标记流量镜像的影子请求
+	HeaderCacheKey            = "X-Cache"                 // 网关响应缓存结果: HIT 或 MISS
+
+	HeaderIdempotencyKey        = "Idempotency-Key"     // 非 GET 请求的幂等键
+	HeaderIdempotentReplayedKey = "Idempotent-Replayed" // 响应为重复请求重放的原始响应
 )
 
 const (
 	ContextUserClaimsKey = "X-User-Claims"
+	ContextRouteMatchKey = "X-Route-Match" // 代理路由匹配结果
+	ContextProxyCmdKey   = "X-Proxy-Cmd"   // 代理路由解析出的 cmd
+	ContextUserRoleKey   = "X-User-Role"   // 用户角色 (int8)
 )
 
+const CookieVariantKey = "release_variant" // 主动选择的发布版本
+
 const (
 	CacheUserKey = "user:%d" // args: user.ID
 )
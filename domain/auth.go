@@ -1,5 +1,7 @@
 package domain
 
+import "time"
+
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
@@ -11,3 +13,15 @@ type InfoResponse struct {
 	Role     int8   `json:"role"`
 	Status   int8   `json:"status"`
 }
+
+type CompetitionLoginRequest struct {
+	CompetitionID uint64 `json:"competition_id" binding:"required"`
+}
+
+type CompetitionLoginResponse struct {
+	CompetitionID uint64    `json:"competition_id"`
+	Name          string    `json:"name"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	ExpiresAt     time.Time `json:"expires_at"` // 比赛令牌过期时间
+}
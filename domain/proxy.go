@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+type ServiceConfig struct {
+	ServiceName  string            `json:"service_name" binding:"required"`
+	Instances    []ServiceInstance `json:"instances" binding:"required,min=1,dive"`
+	HealthCheck  string            `json:"health_check" binding:"required"`
+	LoadBalancer string            `json:"load_balancer" binding:"required"`
+}
+
+type ServiceInstance struct {
+	URL       string     `json:"url" binding:"required"`
+	Weight    int        `json:"weight" binding:"omitempty,min=1,max=100"`
+	Healthy   bool       `json:"healthy"`
+	LastCheck *time.Time `json:"last_check,omitempty"`
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"github.com/to404hanga/online_judge_gateway/config"
 	"github.com/to404hanga/online_judge_gateway/web"
@@ -16,7 +17,7 @@ import (
 	"gorm.io/gorm"
 )
 
-func InitGinServer(l loggerv2.Logger, jwtHandler jwt.Handler, db *gorm.DB, cache *lru.Cache, authHandler *web.AuthHandler, proxyHandler *web.ProxyHandler) *web.GinServer {
+func InitGinServer(l loggerv2.Logger, jwtHandler jwt.Handler, db *gorm.DB, cmd redis.Cmdable, cache *lru.Cache, authHandler *web.AuthHandler, proxyHandler *web.ProxyHandler, proxyAdminHandler *web.ProxyAdminHandler) *web.GinServer {
 	var cfg config.GinConfig
 	err := viper.UnmarshalKey(cfg.Key(), &cfg)
 	if err != nil {
@@ -32,16 +33,52 @@ func InitGinServer(l loggerv2.Logger, jwtHandler jwt.Handler, db *gorm.DB, cache
 		time.Duration(cfg.MaxAge)*time.Second)
 	jwtBuilder := middleware.NewJWTMiddlewareBuilder(jwtHandler, db, cache, cfg.LoginCheckPassPairs, cfg.AdminCheckPairs, l)
 
+	var rateLimitCfg config.RateLimitConfig
+	if err = viper.UnmarshalKey(rateLimitCfg.Key(), &rateLimitCfg); err != nil {
+		log.Panicf("unmarshal rate limit config failed, err: %v", err)
+	}
+	rateLimitBuilder, err := middleware.NewRateLimitMiddlewareBuilder(jwtHandler, cmd, rateLimitCfg.Rules, l)
+	if err != nil {
+		log.Panicf("init rate limit middleware failed, err: %v", err)
+	}
+
+	var competitionCfg config.CompetitionConfig
+	if err = viper.UnmarshalKey(competitionCfg.Key(), &competitionCfg); err != nil {
+		log.Panicf("unmarshal competition config failed, err: %v", err)
+	}
+	competitionBuilder := middleware.NewCompetitionMiddlewareBuilder(jwtHandler, competitionCfg.Cmds, competitionCfg.IDParam, l)
+
+	var idempotencyCfg config.IdempotencyConfig
+	if err = viper.UnmarshalKey(idempotencyCfg.Key(), &idempotencyCfg); err != nil {
+		log.Panicf("unmarshal idempotency config failed, err: %v", err)
+	}
+
 	engine := gin.Default()
+	if err = engine.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Panicf("set trusted proxies failed, err: %v", err)
+	}
+	engine.UseH2C = proxyHandler.GRPCEnabled() // gRPC 直连需要 HTTP/2 明文
 	engine.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	engine.Use(
 		corsBuilder.Build(),
+		proxyHandler.ResolveRoute(),
 		jwtBuilder.CheckLogin(),
+		rateLimitBuilder.Build(),
 		jwtBuilder.CheckAdmin(),
+		competitionBuilder.Build(),
 	)
+	if idempotencyCfg.Enabled {
+		engine.Use(middleware.NewIdempotencyMiddlewareBuilder(jwtHandler, cmd, middleware.IdempotencyOptions{
+			TTL:          time.Duration(idempotencyCfg.TTL) * time.Second,
+			PendingTTL:   time.Duration(idempotencyCfg.PendingTTL) * time.Second,
+			WaitTimeout:  time.Duration(idempotencyCfg.WaitTimeout) * time.Millisecond,
+			MaxBodyBytes: idempotencyCfg.MaxBodyBytes,
+		}, l).Build())
+	}
 
 	authHandler.Register(engine)
 	proxyHandler.Register(engine)
+	proxyAdminHandler.Register(engine)
 	// web.NewHealthHandler().Register(engine)
 
 	return &web.GinServer{
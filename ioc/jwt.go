@@ -7,7 +7,10 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"github.com/to404hanga/online_judge_gateway/config"
+	"github.com/to404hanga/online_judge_gateway/service"
+	"github.com/to404hanga/online_judge_gateway/web"
 	"github.com/to404hanga/online_judge_gateway/web/jwt"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
 )
 
 func InitJWTHandler(rdb redis.Cmdable) jwt.Handler {
@@ -16,6 +19,29 @@ func InitJWTHandler(rdb redis.Cmdable) jwt.Handler {
 		log.Panicf("unmarshal jwt config failed: %v", err)
 	}
 
-	jwtHandler := jwt.NewRedisJWTHandler(rdb, []byte(cfg.JWTKey), time.Duration(cfg.JWTExpiration)*time.Minute)
+	refreshExpiration := time.Duration(cfg.RefreshExpiration) * time.Minute
+	if refreshExpiration <= 0 {
+		refreshExpiration = 3 * 24 * time.Hour
+	}
+	maxSessions := make(map[int8]int, len(cfg.SessionPolicies))
+	for _, p := range cfg.SessionPolicies {
+		if p.MaxSessions < 0 {
+			log.Panicf("invalid session policy for role %d: maxSessions must not be negative", p.Role)
+		}
+		maxSessions[p.Role] = p.MaxSessions
+	}
+	jwtHandler := jwt.NewRedisJWTHandler(rdb, []byte(cfg.JWTKey), time.Duration(cfg.JWTExpiration)*time.Minute, refreshExpiration, maxSessions)
 	return jwtHandler
 }
+
+func InitAuthHandler(l loggerv2.Logger, authService service.AuthService, jwtHandler jwt.Handler) *web.AuthHandler {
+	var cfg config.CompetitionConfig
+	if err := viper.UnmarshalKey(cfg.Key(), &cfg); err != nil {
+		log.Panicf("unmarshal competition config failed: %v", err)
+	}
+
+	return web.NewAuthHandler(authService, jwtHandler, web.CompetitionTokenOptions{
+		EarlyLogin: time.Duration(cfg.EarlyLogin) * time.Minute,
+		Grace:      time.Duration(cfg.Grace) * time.Second,
+	}, l)
+}
@@ -1,29 +1,509 @@
 package ioc
 
 import (
+	"context"
 	"log"
-	"strings"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/viper"
 	"github.com/to404hanga/online_judge_gateway/config"
 	"github.com/to404hanga/online_judge_gateway/web"
-	"github.com/to404hanga/pkg404/gotools/transform"
+	"github.com/to404hanga/online_judge_gateway/web/discovery"
+	"github.com/to404hanga/online_judge_gateway/web/grpcx"
+	"github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/online_judge_gateway/web/respcache"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+	"github.com/to404hanga/online_judge_gateway/web/servicestore"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
 	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
 )
 
-func InitProxyHandler(l loggerv2.Logger) *web.ProxyHandler {
+var variantNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,31}$`)
+
+func InitProxyHandler(l loggerv2.Logger, client redis.Cmdable) *web.ProxyHandler {
+	var cfg config.ProxyConfig
+	if err := viper.UnmarshalKey(cfg.Key(), &cfg); err != nil {
+		log.Panicf("unmarshal proxy config failed: %v", err)
+	}
+
+	services := make(map[string]*upstream.Service, len(cfg.Services))
+	serviceList := make([]*upstream.Service, 0, len(cfg.Services))
+	var checked []*upstream.Service // 需要健康检查的服务，包括发布版本
+	for _, svcCfg := range cfg.Services {
+		if svcCfg.Name == "" {
+			log.Panicf("invalid service config: empty service name")
+		}
+		if _, ok := services[svcCfg.Name]; ok {
+			log.Panicf("invalid service config: duplicate service %s", svcCfg.Name)
+		}
+		if len(svcCfg.Instances) == 0 && svcCfg.Discovery.Type == "" {
+			log.Panicf("invalid service config: service %s has no instance", svcCfg.Name)
+		}
+
+		instances := toInstances(svcCfg.Name, svcCfg.Instances)
+		balancer := toBalancer(svcCfg.Name, svcCfg.LoadBalancer, svcCfg.HashLoadFactor)
+		opts := toServiceOptions(svcCfg)
+		if len(svcCfg.Commands) > 0 {
+			opts = append(opts, upstream.WithCommands(toCommandPolicies(svcCfg.Commands)))
+		}
+		if len(svcCfg.Variants) > 0 {
+			variants := toVariants(svcCfg)
+			for _, v := range variants {
+				checked = append(checked, v.Service)
+			}
+			opts = append(opts, upstream.WithVariants(variants))
+		}
+		switch svcCfg.Protocol {
+		case "", "http":
+		case "grpc":
+			if svcCfg.HealthCheck.Enabled {
+				// 健康检查使用 HTTP/1.1 GET，h2c 的 gRPC 后端会拒绝，所有实例都会被摘除
+				log.Panicf("invalid service config: health check is not supported by grpc service %s", svcCfg.Name)
+			}
+			opts = append(opts, upstream.WithGRPC(toGRPCOptions(svcCfg)))
+		default:
+			log.Panicf("invalid service config: service %s has unknown protocol %s", svcCfg.Name, svcCfg.Protocol)
+		}
+
+		svc := upstream.NewService(svcCfg.Name, instances, balancer, opts...)
+		services[svcCfg.Name] = svc
+		serviceList = append(serviceList, svc)
+		checked = append(checked, svc)
+	}
+
+	checker := upstream.NewHealthChecker(l, checked)
+	checker.Start()
+
+	routes := route.DefaultRoutes()
+	if len(cfg.Routes) > 0 {
+		routes = make([]route.Route, 0, len(cfg.Routes))
+		for _, rtCfg := range cfg.Routes {
+			if rtCfg.Service != "" {
+				svc, ok := services[rtCfg.Service]
+				if !ok {
+					log.Panicf("invalid route config %s: service %s not found", rtCfg.Name, rtCfg.Service)
+				}
+				if rtCfg.Cmd != "" && !svc.AllowCommand(rtCfg.Cmd) {
+					log.Panicf("invalid route config %s: cmd %s not registered in service %s", rtCfg.Name, rtCfg.Cmd, rtCfg.Service)
+				}
+				if rtCfg.HashKey != "" && !svc.Hashed() {
+					log.Panicf("invalid route config %s: service %s does not use %s", rtCfg.Name, rtCfg.Service, upstream.StrategyConsistentHash)
+				}
+			}
+			routes = append(routes, route.Route{
+				Name:    rtCfg.Name,
+				Kind:    rtCfg.Kind,
+				Methods: rtCfg.Methods,
+				Host:    rtCfg.Host,
+				Pattern: rtCfg.Path,
+				Service: rtCfg.Service,
+				Cmd:     rtCfg.Cmd,
+				Rewrite: rtCfg.Rewrite,
+				HashKey: rtCfg.HashKey,
+				Mirror:  toMirror(rtCfg),
+			})
+		}
+	}
+	table, err := route.NewTable(routes)
+	if err != nil {
+		log.Panicf("invalid route config: %v", err)
+	}
+
+	transportOpts := upstream.TransportOptions{
+		MaxIdleConns:          cfg.Transport.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.Transport.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.Transport.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(cfg.Transport.IdleConnTimeout) * time.Second,
+		DialTimeout:           time.Duration(cfg.Transport.DialTimeout) * time.Millisecond,
+		KeepAlive:             time.Duration(cfg.Transport.KeepAlive) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(cfg.Transport.TLSHandshakeTimeout) * time.Millisecond,
+		ResponseHeaderTimeout: time.Duration(cfg.Transport.ResponseHeaderTimeout) * time.Millisecond,
+	}
+	transports := web.ProxyTransports{
+		HTTP:   upstream.NewTransport(transportOpts),
+		Stream: upstream.NewStreamTransport(transportOpts, time.Duration(cfg.Stream.IdleTimeout)*time.Second),
+		GRPC:   upstream.NewH2CTransport(transportOpts),
+		Mirror: upstream.NewTransport(transportOpts),
+	}
+
+	handler := web.NewProxyHandler(l, upstream.NewRegistry(checker, serviceList...), table, transports, toPriorityRules(cfg.Priorities), newResponseCache(l, client, cfg))
+	for _, svcCfg := range cfg.Services {
+		if svcCfg.Discovery.Type == "" {
+			continue
+		}
+		if err = handler.Discover(context.Background(), svcCfg.Name, newDiscoveryProvider(l, svcCfg)); err != nil {
+			log.Panicf("start discovery of service %s failed: %v", svcCfg.Name, err)
+		}
+	}
+	return handler
+}
+
+func InitProxyAdminHandler(l loggerv2.Logger, client redis.Cmdable, jwtHandler jwt.Handler, proxyHandler *web.ProxyHandler) *web.ProxyAdminHandler {
 	var cfg config.ProxyConfig
 	if err := viper.UnmarshalKey(cfg.Key(), &cfg); err != nil {
 		log.Panicf("unmarshal proxy config failed: %v", err)
 	}
+	interval := time.Duration(cfg.Admin.PollInterval) * time.Second
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	store := servicestore.NewRedisStore(client, int64(cfg.Admin.AuditSize), l)
+	handler := web.NewProxyAdminHandler(l, proxyHandler, store, jwtHandler)
+	handler.Watch(context.Background(), interval)
+	return handler
+}
+
+func newDiscoveryProvider(l loggerv2.Logger, svcCfg config.ServiceConfig) discovery.Provider {
+	var (
+		provider discovery.Provider
+		err      error
+	)
+	cfg := svcCfg.Discovery
+	switch cfg.Type {
+	case "file":
+		provider, err = discovery.NewFileProvider(cfg.File, svcCfg.Name, l)
+	case "dns":
+		provider, err = discovery.NewDNSProvider(discovery.DNSOptions{
+			Name:     cfg.DNS.Name,
+			Record:   cfg.DNS.Record,
+			Port:     cfg.DNS.Port,
+			Scheme:   cfg.DNS.Scheme,
+			Interval: time.Duration(cfg.DNS.Interval) * time.Second,
+		}, l)
+	case "consul":
+		service := cfg.Consul.Service
+		if service == "" {
+			service = svcCfg.Name
+		}
+		provider, err = discovery.NewConsulProvider(discovery.ConsulOptions{
+			Address:    cfg.Consul.Address,
+			Service:    service,
+			Tag:        cfg.Consul.Tag,
+			Datacenter: cfg.Consul.Datacenter,
+			Token:      cfg.Consul.Token,
+			Scheme:     cfg.Consul.Scheme,
+			Wait:       time.Duration(cfg.Consul.Wait) * time.Second,
+		}, l)
+	default:
+		log.Panicf("invalid discovery config of service %s: unknown type %s", svcCfg.Name, cfg.Type)
+	}
+	if err != nil {
+		log.Panicf("invalid discovery config of service %s: %v", svcCfg.Name, err)
+	}
+	return provider
+}
+
+func toInstances(service string, instCfgs []config.InstanceConfig) []*upstream.Instance {
+	instances := make([]*upstream.Instance, 0, len(instCfgs))
+	for _, instCfg := range instCfgs {
+		inst, err := upstream.NewInstance(instCfg.URL, instCfg.Weight)
+		if err != nil {
+			log.Panicf("invalid instance config of service %s: %v", service, err)
+		}
+		instances = append(instances, inst)
+	}
+	return instances
+}
+
+func toBalancer(service, strategy string, hashLoadFactor float64) upstream.Balancer {
+	balancer, err := upstream.NewBalancer(strategy)
+	if err != nil {
+		log.Panicf("invalid load balancer of service %s: %v", service, err)
+	}
+	if strategy == upstream.StrategyConsistentHash && hashLoadFactor > 0 {
+		if hashLoadFactor <= 1 {
+			log.Panicf("invalid load balancer of service %s: hashLoadFactor must be greater than 1", service)
+		}
+		balancer = upstream.NewConsistentHashBalancer(hashLoadFactor)
+	}
+	return balancer
+}
+
+// toServiceOptions 转换服务和发布版本共用的健康检查、熔断、重试、并发限制和超时配置，发布版本单独计算并发
+func toServiceOptions(svcCfg config.ServiceConfig) []upstream.ServiceOption {
+	var opts []upstream.ServiceOption
+	if hc := svcCfg.HealthCheck; hc.Enabled {
+		opts = append(opts, upstream.WithHealthCheck(upstream.HealthCheckOptions{
+			Path:               hc.Path,
+			Interval:           time.Duration(hc.Interval) * time.Second,
+			Timeout:            time.Duration(hc.Timeout) * time.Second,
+			HealthyThreshold:   hc.HealthyThreshold,
+			UnhealthyThreshold: hc.UnhealthyThreshold,
+		}))
+	}
+	if cb := svcCfg.CircuitBreaker; cb.Enabled {
+		opts = append(opts, upstream.WithCircuitBreaker(toBreakerOptions(cb)))
+	}
+	if od := svcCfg.OutlierDetection; od.Enabled {
+		opts = append(opts, upstream.WithOutlierDetection(toBreakerOptions(od)))
+	}
+	if svcCfg.Retry.Enabled {
+		opts = append(opts, upstream.WithRetryPolicy(toRetryPolicy(svcCfg.Retry)))
+	}
+	if cl := svcCfg.ConcurrencyLimit; cl.Enabled {
+		if cl.MaxInFlight <= 0 || cl.MaxQueue < 0 {
+			log.Panicf("invalid concurrency limit config of service %s: maxInFlight must be positive and maxQueue must not be negative", svcCfg.Name)
+		}
+		opts = append(opts, upstream.WithConcurrencyLimit(upstream.LimiterOptions{
+			MaxInFlight:      cl.MaxInFlight,
+			MaxQueue:         cl.MaxQueue,
+			QueueTimeout:     time.Duration(cl.QueueTimeout) * time.Millisecond,
+			Adaptive:         cl.Adaptive,
+			MinInFlight:      cl.MinInFlight,
+			LatencyThreshold: time.Duration(cl.LatencyThreshold) * time.Millisecond,
+			LatencyTolerance: cl.LatencyTolerance,
+			Backoff:          cl.Backoff,
+		}))
+	}
+	if svcCfg.Timeout > 0 {
+		opts = append(opts, upstream.WithTimeout(time.Duration(svcCfg.Timeout)*time.Millisecond))
+	}
+	return opts
+}
+
+// toVariants 创建服务的发布版本，版本沿用服务的健康检查和熔断配置，负载均衡策略未配置时与服务相同
+func toVariants(svcCfg config.ServiceConfig) []*upstream.Variant {
+	if svcCfg.Discovery.Type != "" {
+		log.Panicf("invalid variant config of service %s: variants are not supported with discovery", svcCfg.Name)
+	}
+	variants := make([]*upstream.Variant, 0, len(svcCfg.Variants))
+	names := make(map[string]struct{}, len(svcCfg.Variants))
+	var percent float64
+	for _, vCfg := range svcCfg.Variants {
+		if !variantNameRegexp.MatchString(vCfg.Name) || vCfg.Name == upstream.StableVariant {
+			log.Panicf("invalid variant config of service %s: invalid variant name %q", svcCfg.Name, vCfg.Name)
+		}
+		if _, ok := names[vCfg.Name]; ok {
+			log.Panicf("invalid variant config of service %s: duplicate variant %s", svcCfg.Name, vCfg.Name)
+		}
+		names[vCfg.Name] = struct{}{}
+		if len(vCfg.Instances) == 0 {
+			log.Panicf("invalid variant config of service %s: variant %s has no instance", svcCfg.Name, vCfg.Name)
+		}
+		if vCfg.Percent < 0 || vCfg.Percent > 100 {
+			log.Panicf("invalid variant config of service %s: invalid percent %v of variant %s", svcCfg.Name, vCfg.Percent, vCfg.Name)
+		}
+		percent += vCfg.Percent
+		if percent > 100 {
+			log.Panicf("invalid variant config of service %s: total percent exceeds 100", svcCfg.Name)
+		}
+
+		name := svcCfg.Name + "@" + vCfg.Name
+		strategy := vCfg.LoadBalancer
+		if strategy == "" {
+			strategy = svcCfg.LoadBalancer
+		}
+		users := make(map[uint64]struct{}, len(vCfg.Users))
+		for _, uid := range vCfg.Users {
+			users[uid] = struct{}{}
+		}
+		variants = append(variants, &upstream.Variant{
+			Name: vCfg.Name,
+			Service: upstream.NewService(name,
+				toInstances(name, vCfg.Instances),
+				toBalancer(name, strategy, svcCfg.HashLoadFactor),
+				toServiceOptions(svcCfg)...,
+			),
+			Percent: vCfg.Percent,
+			Admin:   vCfg.Admin,
+			OptIn:   vCfg.OptIn,
+			Users:   users,
+		})
+	}
+	return variants
+}
+
+func toPriorityRules(cfgs []config.PriorityConfig) []web.PriorityRule {
+	if len(cfgs) == 0 {
+		return web.DefaultPriorityRules()
+	}
+	rules := make([]web.PriorityRule, 0, len(cfgs))
+	for i, pCfg := range cfgs {
+		priority, err := upstream.ParsePriority(pCfg.Priority)
+		if err != nil || pCfg.Priority == "" {
+			log.Panicf("invalid priority config #%d: unknown priority %q", i, pCfg.Priority)
+		}
+		rules = append(rules, web.PriorityRule{
+			Roles:    pCfg.Roles,
+			Cmds:     pCfg.Cmds,
+			Routes:   pCfg.Routes,
+			Priority: priority,
+		})
+	}
+	return rules
+}
+
+func toMirror(rtCfg config.RouteConfig) *route.Mirror {
+	if rtCfg.Mirror == nil {
+		return nil
+	}
+	target, err := url.Parse(rtCfg.Mirror.URL)
+	if err != nil {
+		log.Panicf("invalid mirror config of route %s: %v", rtCfg.Name, err)
+	}
+	mirror := &route.Mirror{
+		Target:       target,
+		Percent:      rtCfg.Mirror.Percent,
+		MaxBodyBytes: rtCfg.Mirror.MaxBodyBytes,
+		Timeout:      time.Duration(rtCfg.Mirror.Timeout) * time.Millisecond,
+	}
+	if mirror.MaxBodyBytes <= 0 {
+		mirror.MaxBodyBytes = 1 << 20
+	}
+	if mirror.Timeout <= 0 {
+		mirror.Timeout = 5 * time.Second
+	}
+	return mirror
+}
+
+func toBreakerOptions(cfg config.CircuitBreakerConfig) upstream.BreakerOptions {
+	return upstream.BreakerOptions{
+		ConsecutiveFailures: cfg.ConsecutiveFailures,
+		FailureRatio:        cfg.FailureRatio,
+		MinRequests:         cfg.MinRequests,
+		Window:              time.Duration(cfg.Window) * time.Second,
+		Cooldown:            time.Duration(cfg.Cooldown) * time.Second,
+		HalfOpenRequests:    cfg.HalfOpenRequests,
+	}
+}
 
-	services := transform.MapFromSlice(cfg.Services, func(i int, svc string) (string, string) {
-		parts := strings.Split(svc, ":")
-		if len(parts) != 2 {
-			log.Panicf("invalid service config: %s", svc)
+func toRetryPolicy(cfg config.RetryConfig) upstream.RetryPolicy {
+	return upstream.RetryPolicy{
+		MaxAttempts:    cfg.MaxAttempts,
+		BackoffBase:    time.Duration(cfg.BackoffBase) * time.Millisecond,
+		BackoffMax:     time.Duration(cfg.BackoffMax) * time.Millisecond,
+		OnConnectError: cfg.RetryOnConnectError,
+		OnStatus:       cfg.RetryOnStatus,
+		Safe:           cfg.Safe,
+	}
+}
+
+func toCommandPolicies(cmds []config.CommandConfig) []upstream.CommandPolicy {
+	policies := make([]upstream.CommandPolicy, 0, len(cmds))
+	for _, cmd := range cmds {
+		if !route.ValidCmd(cmd.Name) {
+			log.Panicf("invalid command config: invalid command name %q", cmd.Name)
+		}
+		policy := upstream.CommandPolicy{
+			Name:     cmd.Name,
+			Timeout:  time.Duration(cmd.Timeout) * time.Millisecond,
+			Cache:    toCachePolicy(cmd),
+			Coalesce: toCoalescePolicy(cmd),
 		}
-		return parts[0], svc
-	})
+		if cmd.Retry != nil {
+			retry := toRetryPolicy(*cmd.Retry)
+			if !cmd.Retry.Enabled {
+				retry.MaxAttempts = 1
+			}
+			policy.Retry = &retry
+		}
+		policies = append(policies, policy)
+	}
+	return policies
+}
 
-	return web.NewProxyHandler(l, services)
+// newResponseCache 有 cmd 开启响应缓存时创建缓存并开始同步清除缓存的版本号
+func newResponseCache(l loggerv2.Logger, client redis.Cmdable, cfg config.ProxyConfig) *respcache.Cache {
+	enabled := false
+	for _, svcCfg := range cfg.Services {
+		for _, cmd := range svcCfg.Commands {
+			enabled = enabled || cmd.Cache != nil
+		}
+	}
+	if !enabled {
+		return nil
+	}
+	interval := time.Duration(cfg.Cache.PollInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+	cache := respcache.New(client, respcache.Options{
+		MaxEntries:   cfg.Cache.MaxEntries,
+		MaxBytes:     cfg.Cache.MaxBytes,
+		RedisTimeout: time.Duration(cfg.Cache.RedisTimeout) * time.Millisecond,
+	}, l)
+	cache.Watch(context.Background(), interval)
+	return cache
+}
+
+func toCachePolicy(cmd config.CommandConfig) *upstream.CachePolicy {
+	if cmd.Cache == nil {
+		return nil
+	}
+	scope, err := upstream.ParseCacheScope(cmd.Cache.Scope)
+	if err != nil {
+		log.Panicf("invalid cache config of command %s: %v", cmd.Name, err)
+	}
+	if cmd.Cache.TTL <= 0 {
+		log.Panicf("invalid cache config of command %s: ttl must be positive", cmd.Name)
+	}
+	policy := &upstream.CachePolicy{
+		Scope:        scope,
+		TTL:          time.Duration(cmd.Cache.TTL) * time.Second,
+		MaxBodyBytes: cmd.Cache.MaxBodyBytes,
+		VaryHeaders:  make([]string, 0, len(cmd.Cache.VaryHeaders)),
+	}
+	if policy.MaxBodyBytes <= 0 {
+		policy.MaxBodyBytes = 1 << 20
+	}
+	for _, name := range cmd.Cache.VaryHeaders {
+		policy.VaryHeaders = append(policy.VaryHeaders, http.CanonicalHeaderKey(name))
+	}
+	return policy
+}
+
+func toCoalescePolicy(cmd config.CommandConfig) *upstream.CoalescePolicy {
+	if cmd.Coalesce == nil {
+		return nil
+	}
+	scope, err := upstream.ParseCacheScope(cmd.Coalesce.Scope)
+	if err != nil {
+		log.Panicf("invalid coalesce config of command %s: %v", cmd.Name, err)
+	}
+	policy := &upstream.CoalescePolicy{
+		Scope:        scope,
+		MaxBodyBytes: cmd.Coalesce.MaxBodyBytes,
+		VaryHeaders:  make([]string, 0, len(cmd.Coalesce.VaryHeaders)),
+	}
+	if policy.MaxBodyBytes <= 0 {
+		policy.MaxBodyBytes = 1 << 20
+	}
+	for _, name := range cmd.Coalesce.VaryHeaders {
+		policy.VaryHeaders = append(policy.VaryHeaders, http.CanonicalHeaderKey(name))
+	}
+	return policy
+}
+
+func toGRPCOptions(svcCfg config.ServiceConfig) upstream.GRPCOptions {
+	codec, err := grpcx.LoadCodec(svcCfg.GRPC.DescriptorSet, svcCfg.GRPC.Service)
+	if err != nil {
+		log.Panicf("invalid grpc config of service %s: %v", svcCfg.Name, err)
+	}
+	for _, cmd := range svcCfg.Commands {
+		if _, ok := codec.UnaryMethod(cmd.Name); !ok {
+			log.Panicf("invalid grpc config of service %s: unary method %s not found", svcCfg.Name, cmd.Name)
+		}
+		if cmd.Cache != nil || cmd.Coalesce != nil {
+			log.Panicf("invalid grpc config of service %s: response cache or coalescing of command %s is not supported", svcCfg.Name, cmd.Name)
+		}
+	}
+
+	nets := make([]netip.Prefix, 0, len(svcCfg.GRPC.TrustedCIDRs))
+	for _, cidr := range svcCfg.GRPC.TrustedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			log.Panicf("invalid grpc config of service %s: invalid trusted cidr %s: %v", svcCfg.Name, cidr, err)
+		}
+		nets = append(nets, prefix.Masked())
+	}
+	return upstream.GRPCOptions{
+		Codec:       codec,
+		TrustedNets: nets,
+	}
 }
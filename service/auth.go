@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/redis/go-redis/v9"
@@ -9,14 +10,25 @@ import (
 	constants "github.com/to404hanga/online_judge_gateway/constant"
 	"github.com/to404hanga/online_judge_gateway/domain"
 	"github.com/to404hanga/pkg404/cachex/lru"
+	"github.com/to404hanga/pkg404/logger"
 	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrCompetitionNotFound       = errors.New("competition not found")
+	ErrCompetitionNotParticipant = errors.New("user is not a participant of the competition")
+	ErrUserNotFound              = errors.New("user not found")
+)
+
 type AuthService interface {
-	Login(ctx context.Context, req *domain.LoginRequest) (uint64, error)
+	// Login 校验用户名和密码，返回用户 ID 和角色
+	Login(ctx context.Context, req *domain.LoginRequest) (uint64, int8, error)
 	Info(ctx context.Context, userId uint64) (*domain.InfoResponse, error)
+	// Role 从本地缓存或数据库中获取用户当前的角色
+	Role(ctx context.Context, userId uint64) (int8, error)
+	CompetitionLogin(ctx context.Context, userId, competitionId uint64, isAdmin bool) (*ojmodel.Competition, error)
 }
 
 type AuthServiceImpl struct {
@@ -35,7 +47,7 @@ func NewAuthService(db *gorm.DB, rds redis.Cmdable, log loggerv2.Logger, cache *
 	}
 }
 
-func (s *AuthServiceImpl) Login(ctx context.Context, req *domain.LoginRequest) (uint64, error) {
+func (s *AuthServiceImpl) Login(ctx context.Context, req *domain.LoginRequest) (uint64, int8, error) {
 	var user ojmodel.User
 	err := s.db.WithContext(ctx).Model(&ojmodel.User{}).
 		Where("username = ?", req.Username).
@@ -43,12 +55,12 @@ func (s *AuthServiceImpl) Login(ctx context.Context, req *domain.LoginRequest) (
 		Select("id", "username", "realname", "realname", "role", "password").
 		First(&user).Error
 	if err != nil {
-		return 0, fmt.Errorf("get user from db error: %w", err)
+		return 0, 0, fmt.Errorf("get user from db error: %w", err)
 	}
 
 	// 密码校验
 	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
-		return 0, fmt.Errorf("password not match")
+		return 0, 0, fmt.Errorf("password not match")
 	}
 
 	s.cache.Add(fmt.Sprintf(constants.CacheUserKey, user.ID), constants.CacheUser{
@@ -57,7 +69,7 @@ func (s *AuthServiceImpl) Login(ctx context.Context, req *domain.LoginRequest) (
 		Role:     user.Role.Int8(),
 	})
 
-	return user.ID, nil
+	return user.ID, user.Role.Int8(), nil
 }
 
 func (s *AuthServiceImpl) Info(ctx context.Context, userId uint64) (*domain.InfoResponse, error) {
@@ -77,3 +89,64 @@ func (s *AuthServiceImpl) Info(ctx context.Context, userId uint64) (*domain.Info
 		Status:   user.Status.Int8(),
 	}, nil
 }
+
+func (s *AuthServiceImpl) Role(ctx context.Context, userId uint64) (int8, error) {
+	cacheKey := fmt.Sprintf(constants.CacheUserKey, userId)
+	if val, ok := s.cache.Get(cacheKey); ok {
+		if user, ok := val.(constants.CacheUser); ok {
+			return user.Role, nil
+		}
+		s.log.ErrorContext(ctx, "Role assert cache failed", logger.Any("value", val))
+	}
+	var user ojmodel.User
+	err := s.db.WithContext(ctx).Model(&ojmodel.User{}).
+		Where("id = ?", userId).
+		Select("username", "realname", "role").
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrUserNotFound
+		}
+		return 0, fmt.Errorf("get user from db error: %w", err)
+	}
+	s.cache.Add(cacheKey, constants.CacheUser{
+		Username: user.Username,
+		Realname: user.Realname,
+		Role:     user.Role.Int8(),
+	})
+	return user.Role.Int8(), nil
+}
+
+// CompetitionLogin 校验用户能否参加比赛：比赛已发布，且用户在比赛名单中未被禁用；管理员不校验比赛名单。
+// 比赛时间窗口由调用方校验
+func (s *AuthServiceImpl) CompetitionLogin(ctx context.Context, userId, competitionId uint64, isAdmin bool) (*ojmodel.Competition, error) {
+	var competition ojmodel.Competition
+	err := s.db.WithContext(ctx).Model(&ojmodel.Competition{}).
+		Where("id = ?", competitionId).
+		Where("status = ?", ojmodel.CompetitionStatusPublished).
+		Select("id", "name", "start_time", "end_time").
+		First(&competition).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCompetitionNotFound
+		}
+		return nil, fmt.Errorf("get competition from db error: %w", err)
+	}
+	if isAdmin {
+		return &competition, nil
+	}
+
+	var cnt int64
+	err = s.db.WithContext(ctx).Model(&ojmodel.CompetitionUser{}).
+		Where("competition_id = ?", competitionId).
+		Where("user_id = ?", userId).
+		Where("status = ?", ojmodel.CompetitionUserStatusNormal).
+		Count(&cnt).Error
+	if err != nil {
+		return nil, fmt.Errorf("get competition user from db error: %w", err)
+	}
+	if cnt == 0 {
+		return nil, ErrCompetitionNotParticipant
+	}
+	return &competition, nil
+}
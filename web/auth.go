@@ -1,9 +1,12 @@
 package web
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	ojmodel "github.com/to404hanga/online_judge_common/model"
 	"github.com/to404hanga/online_judge_gateway/domain"
 	"github.com/to404hanga/online_judge_gateway/service"
 	ojjwt "github.com/to404hanga/online_judge_gateway/web/jwt"
@@ -11,18 +14,26 @@ import (
 	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
 )
 
+// CompetitionTokenOptions 比赛令牌的时间窗口
+type CompetitionTokenOptions struct {
+	EarlyLogin time.Duration // 比赛开始前多久可以换取比赛令牌
+	Grace      time.Duration // 比赛令牌在比赛结束后继续有效的时间
+}
+
 type AuthHandler struct {
 	authService service.AuthService
 	jwtHandler  ojjwt.Handler
+	competition CompetitionTokenOptions
 	log         loggerv2.Logger
 }
 
 var _ Handler = (*AuthHandler)(nil)
 
-func NewAuthHandler(authService service.AuthService, jwtHandler ojjwt.Handler, log loggerv2.Logger) *AuthHandler {
+func NewAuthHandler(authService service.AuthService, jwtHandler ojjwt.Handler, competition CompetitionTokenOptions, log loggerv2.Logger) *AuthHandler {
 	return &AuthHandler{
 		authService: authService,
 		jwtHandler:  jwtHandler,
+		competition: competition,
 		log:         log,
 	}
 }
@@ -32,7 +43,12 @@ func (h *AuthHandler) Register(r *gin.Engine) {
 	{
 		auth.POST("/login", h.LoginHandler)
 		auth.POST("/logout", h.LogoutHandler)
+		auth.POST("/refresh", h.RefreshHandler)
 		auth.GET("/info", h.InfoHandler)
+		auth.POST("/competition/login", h.CompetitionLoginHandler)
+		auth.GET("/sessions", h.ListSessionsHandler)
+		auth.DELETE("/sessions", h.RevokeOtherSessionsHandler)
+		auth.DELETE("/sessions/:ssid", h.RevokeSessionHandler)
 	}
 }
 
@@ -46,18 +62,115 @@ func (h *AuthHandler) LoginHandler(c *gin.Context) {
 
 	ctx := loggerv2.ContextWithFields(c, logger.String("username", req.Username))
 
-	userID, err := h.authService.Login(ctx, &req)
+	userID, role, err := h.authService.Login(ctx, &req)
 	if err != nil {
 		h.log.ErrorContext(ctx, "loginHandler login failed", logger.Error(err))
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	h.jwtHandler.SetLoginToken(c, userID)
+	if err = h.jwtHandler.SetLoginToken(c, userID, role); err != nil {
+		h.log.ErrorContext(ctx, "loginHandler set login token failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "login success"})
 }
 
+// RefreshHandler 使用刷新令牌换取新的访问令牌和刷新令牌，访问令牌使用用户当前的角色
+func (h *AuthHandler) RefreshHandler(c *gin.Context) {
+	if err := h.jwtHandler.Refresh(c, h.authService.Role); err != nil {
+		switch {
+		case errors.Is(err, ojjwt.ErrRefreshTokenReused):
+			// 已轮换的刷新令牌被再次使用，可能已泄露，会话已撤销
+			h.log.WarnContext(c, "refreshHandler refresh token reused", logger.Error(err))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reused, session revoked"})
+		case errors.Is(err, ojjwt.ErrRefreshTokenInvalid), errors.Is(err, service.ErrUserNotFound):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		default:
+			h.log.ErrorContext(c, "refreshHandler refresh failed", logger.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "refresh success"})
+}
+
+// CompetitionLoginHandler 使用当前会话换取一场比赛的比赛令牌，令牌在比赛结束后失效
+func (h *AuthHandler) CompetitionLoginHandler(c *gin.Context) {
+	var req domain.CompetitionLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log.ErrorContext(c, "competitionLoginHandler bind json failed", logger.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	uc, err := h.jwtHandler.GetUserClaims(c)
+	if err != nil {
+		h.log.ErrorContext(c, "competitionLoginHandler get user claims failed", logger.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := loggerv2.ContextWithFields(c,
+		logger.Uint64("user_id", uc.UserId),
+		logger.Uint64("competition_id", req.CompetitionID),
+	)
+
+	// 管理员身份以数据库中的角色为准，令牌中的角色在降级后仍可能是管理员
+	role, err := h.authService.Role(ctx, uc.UserId)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		h.log.ErrorContext(ctx, "competitionLoginHandler get user role failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	competition, err := h.authService.CompetitionLogin(ctx, uc.UserId, req.CompetitionID, role == int8(ojmodel.UserRoleAdmin))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrCompetitionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrCompetitionNotParticipant):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			h.log.ErrorContext(ctx, "competitionLoginHandler competition login failed", logger.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	now := time.Now()
+	if now.Before(competition.StartTime.Add(-h.competition.EarlyLogin)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "competition not started"})
+		return
+	}
+	expiresAt := competition.EndTime.Add(h.competition.Grace)
+	if !now.Before(expiresAt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "competition ended"})
+		return
+	}
+
+	if err = h.jwtHandler.SetCompetitionToken(c, uc.UserId, uc.Ssid, competition.ID, expiresAt); err != nil {
+		h.log.ErrorContext(ctx, "competitionLoginHandler set competition token failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, domain.CompetitionLoginResponse{
+		CompetitionID: competition.ID,
+		Name:          competition.Name,
+		StartTime:     competition.StartTime,
+		EndTime:       competition.EndTime,
+		ExpiresAt:     expiresAt,
+	})
+}
+
 func (h *AuthHandler) LogoutHandler(c *gin.Context) {
 	if err := h.jwtHandler.ClearToken(c); err != nil {
 		h.log.ErrorContext(c, "logoutHandler clear token failed", logger.Error(err))
@@ -68,6 +181,46 @@ func (h *AuthHandler) LogoutHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "logout success"})
 }
 
+// ListSessionsHandler 列出当前用户登录的会话
+func (h *AuthHandler) ListSessionsHandler(c *gin.Context) {
+	sessions, err := h.jwtHandler.ListSessions(c)
+	if err != nil {
+		h.log.ErrorContext(c, "listSessionsHandler list sessions failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSessionHandler 撤销当前用户的一个会话，撤销当前会话等同于登出
+func (h *AuthHandler) RevokeSessionHandler(c *gin.Context) {
+	ssid := c.Param("ssid")
+	if err := h.jwtHandler.RevokeSession(c, ssid); err != nil {
+		if errors.Is(err, ojjwt.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		h.log.ErrorContext(c, "revokeSessionHandler revoke session failed", logger.Error(err), logger.String("ssid", ssid))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeOtherSessionsHandler 撤销当前用户除当前会话外的所有会话
+func (h *AuthHandler) RevokeOtherSessionsHandler(c *gin.Context) {
+	revoked, err := h.jwtHandler.RevokeOtherSessions(c)
+	if err != nil {
+		h.log.ErrorContext(c, "revokeOtherSessionsHandler revoke sessions failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "other sessions revoked", "revoked": revoked})
+}
+
 func (h *AuthHandler) InfoHandler(c *gin.Context) {
 	uc, err := h.jwtHandler.GetUserClaims(c)
 	if err != nil {
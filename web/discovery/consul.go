@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+const (
+	consulIndexHeader = "X-Consul-Index"
+	consulTokenHeader = "X-Consul-Token"
+
+	consulMinRetryInterval = time.Second
+	consulMaxRetryInterval = 30 * time.Second
+	consulPollInterval     = 5 * time.Second // 服务端不支持阻塞查询时的轮询间隔
+)
+
+// ConsulOptions Consul 服务发现配置
+type ConsulOptions struct {
+	Address    string        // Consul HTTP 地址，如 http://consul:8500
+	Service    string        // Consul 中注册的服务名
+	Tag        string        // 仅使用带有该标签的实例，为空表示不过滤
+	Datacenter string        // 数据中心，为空表示 agent 所在数据中心
+	Token      string        // ACL token
+	Scheme     string        // 实例协议，默认 http
+	Wait       time.Duration // 阻塞查询的最长等待时间，默认 30s
+}
+
+// ConsulProvider 通过 Consul 兼容的 /v1/health/service 接口阻塞查询健康实例
+type ConsulProvider struct {
+	opts   ConsulOptions
+	client *http.Client
+	log    loggerv2.Logger
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+		Weights struct {
+			Passing int
+		}
+	}
+}
+
+func NewConsulProvider(opts ConsulOptions, log loggerv2.Logger) (*ConsulProvider, error) {
+	if opts.Address == "" || opts.Service == "" {
+		return nil, fmt.Errorf("NewConsulProvider failed: address and service are required")
+	}
+	if !strings.Contains(opts.Address, "://") {
+		opts.Address = "http://" + opts.Address
+	}
+	if _, err := url.Parse(opts.Address); err != nil {
+		return nil, fmt.Errorf("NewConsulProvider failed: %w", err)
+	}
+	if opts.Scheme == "" {
+		opts.Scheme = "http"
+	}
+	if opts.Wait <= 0 {
+		opts.Wait = 30 * time.Second
+	}
+	return &ConsulProvider{
+		opts:   opts,
+		client: &http.Client{},
+		log:    log,
+	}, nil
+}
+
+func (p *ConsulProvider) Watch(ctx context.Context, update func([]Endpoint)) {
+	n := &notifier{update: update}
+	go func() {
+		var index uint64
+		retry := consulMinRetryInterval
+		for {
+			endpoints, next, err := p.query(ctx, index)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// 查询失败时保留当前实例，按指数退避重试
+				p.log.WarnContext(ctx, "query consul failed",
+					logger.String("service", p.opts.Service),
+					logger.Error(err),
+				)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retry):
+				}
+				retry = min(retry*2, consulMaxRetryInterval)
+				continue
+			}
+			retry = consulMinRetryInterval
+			n.notify(endpoints)
+
+			if next == 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(consulPollInterval):
+				}
+			}
+			// index 回退时需要重新开始阻塞查询，见 Consul 文档 Blocking Queries
+			if next < index {
+				next = 0
+			}
+			index = next
+		}
+	}()
+}
+
+func (p *ConsulProvider) query(ctx context.Context, index uint64) ([]Endpoint, uint64, error) {
+	q := url.Values{}
+	q.Set("passing", "true")
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.FormatInt(int64(p.opts.Wait/time.Second), 10)+"s")
+	}
+	if p.opts.Tag != "" {
+		q.Set("tag", p.opts.Tag)
+	}
+	if p.opts.Datacenter != "" {
+		q.Set("dc", p.opts.Datacenter)
+	}
+	u := strings.TrimSuffix(p.opts.Address, "/") + "/v1/health/service/" + url.PathEscape(p.opts.Service) + "?" + q.Encode()
+
+	// 阻塞查询在 wait 基础上最多再等待 wait/16 的随机时间
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Wait+p.opts.Wait/16+5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.opts.Token != "" {
+		req.Header.Set(consulTokenHeader, p.opts.Token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("decode consul response failed: %w", err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get(consulIndexHeader), 10, 64)
+
+	endpoints := make([]Endpoint, 0, len(entries))
+	for _, entry := range entries {
+		addr := entry.Service.Address
+		if addr == "" {
+			addr = entry.Node.Address
+		}
+		endpoints = append(endpoints, Endpoint{
+			URL:    p.opts.Scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(entry.Service.Port)),
+			Weight: entry.Service.Weights.Passing,
+		})
+	}
+	return endpoints, next, nil
+}
@@ -0,0 +1,154 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+// consulStub 模拟 Consul 的 /v1/health/service 阻塞查询
+type consulStub struct {
+	mu      sync.Mutex
+	index   uint64
+	entries []map[string]any
+	changed chan struct{}
+	queries []*http.Request
+}
+
+func newConsulStub(entries ...map[string]any) *consulStub {
+	return &consulStub{
+		index:   1,
+		entries: entries,
+		changed: make(chan struct{}),
+	}
+}
+
+func (s *consulStub) set(entries ...map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index++
+	s.entries = entries
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *consulStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/health/service/controller" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.mu.Lock()
+	s.queries = append(s.queries, r)
+	index, changed := s.index, s.changed
+	s.mu.Unlock()
+
+	if want, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); want >= index {
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set(consulIndexHeader, strconv.FormatUint(s.index, 10))
+	json.NewEncoder(w).Encode(s.entries)
+}
+
+func (s *consulStub) lastQuery() *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[len(s.queries)-1]
+}
+
+func consulEntry(nodeAddr, svcAddr string, port, weight int) map[string]any {
+	return map[string]any{
+		"Node": map[string]any{"Address": nodeAddr},
+		"Service": map[string]any{
+			"Address": svcAddr,
+			"Port":    port,
+			"Weights": map[string]any{"Passing": weight},
+		},
+	}
+}
+
+func waitEndpoints(t *testing.T, updates <-chan []Endpoint) []Endpoint {
+	t.Helper()
+	select {
+	case endpoints := <-updates:
+		return endpoints
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for endpoints")
+		return nil
+	}
+}
+
+func TestConsulProviderWatch(t *testing.T) {
+	stub := newConsulStub(
+		consulEntry("10.0.0.1", "", 8081, 1),
+		consulEntry("10.0.0.9", "10.0.0.2", 8081, 3),
+	)
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	provider, err := NewConsulProvider(ConsulOptions{
+		Address: srv.URL,
+		Service: "controller",
+		Tag:     "v2",
+		Token:   "secret",
+		Wait:    time.Second,
+	}, struct{ loggerv2.Logger }{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan []Endpoint, 4)
+	provider.Watch(ctx, func(endpoints []Endpoint) {
+		updates <- endpoints
+	})
+
+	got := waitEndpoints(t, updates)
+	want := []Endpoint{
+		{URL: "http://10.0.0.1:8081", Weight: 1},
+		{URL: "http://10.0.0.2:8081", Weight: 3},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("endpoints = %v, want %v", got, want)
+	}
+	q := stub.lastQuery()
+	if q.URL.Query().Get("passing") != "true" || q.URL.Query().Get("tag") != "v2" || q.Header.Get(consulTokenHeader) != "secret" {
+		t.Fatalf("unexpected query %s %v", q.URL, q.Header)
+	}
+
+	// 实例列表未变化时不通知
+	stub.set(
+		consulEntry("10.0.0.9", "10.0.0.2", 8081, 3),
+		consulEntry("10.0.0.1", "", 8081, 1),
+	)
+	select {
+	case endpoints := <-updates:
+		t.Fatalf("unexpected update %v", endpoints)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	stub.set(consulEntry("10.0.0.3", "", 9090, 0))
+	got = waitEndpoints(t, updates)
+	if len(got) != 1 || got[0] != (Endpoint{URL: "http://10.0.0.3:9090"}) {
+		t.Fatalf("endpoints = %v", got)
+	}
+	if q := stub.lastQuery(); q.URL.Query().Get("index") == "" || q.URL.Query().Get("wait") != "1s" {
+		t.Fatalf("expected blocking query, got %s", q.URL)
+	}
+}
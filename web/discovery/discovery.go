@@ -0,0 +1,42 @@
+package discovery
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Endpoint 发现的服务实例
+type Endpoint struct {
+	URL    string // 实例地址，如 http://10.0.0.1:8081
+	Weight int    // 权重，0 表示默认权重
+}
+
+// Provider 服务发现，持续监听一个服务的实例列表
+type Provider interface {
+	// Watch 在后台监听实例变化，首次发现及之后实例列表变化时调用 update，ctx 结束后停止
+	Watch(ctx context.Context, update func([]Endpoint))
+}
+
+// notifier 过滤未变化的实例列表，仅在所属 Provider 的监听协程中使用
+type notifier struct {
+	update func([]Endpoint)
+	last   []Endpoint
+	synced bool
+}
+
+func (n *notifier) notify(endpoints []Endpoint) {
+	endpoints = slices.Clone(endpoints)
+	slices.SortFunc(endpoints, func(a, b Endpoint) int {
+		if c := strings.Compare(a.URL, b.URL); c != 0 {
+			return c
+		}
+		return a.Weight - b.Weight
+	})
+	if n.synced && slices.Equal(n.last, endpoints) {
+		return
+	}
+	n.last = endpoints
+	n.synced = true
+	n.update(endpoints)
+}
@@ -0,0 +1,127 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+const (
+	RecordA   = "a"
+	RecordSRV = "srv"
+)
+
+// DNSOptions DNS 服务发现配置
+type DNSOptions struct {
+	Name     string        // 域名，SRV 记录如 _http._tcp.controller.default.svc.cluster.local
+	Record   string        // 记录类型: a (默认, 同时解析 AAAA) 或 srv
+	Port     int           // A 记录使用的实例端口
+	Scheme   string        // 实例协议，默认 http
+	Interval time.Duration // 重新解析间隔，默认 30s
+}
+
+// DNSProvider 定期解析 A/SRV 记录得到服务实例
+type DNSProvider struct {
+	opts     DNSOptions
+	resolver *net.Resolver
+	log      loggerv2.Logger
+}
+
+func NewDNSProvider(opts DNSOptions, log loggerv2.Logger) (*DNSProvider, error) {
+	if opts.Name == "" {
+		return nil, fmt.Errorf("NewDNSProvider failed: empty name")
+	}
+	opts.Record = strings.ToLower(opts.Record)
+	switch opts.Record {
+	case "":
+		opts.Record = RecordA
+		fallthrough
+	case RecordA:
+		if opts.Port <= 0 || opts.Port > 65535 {
+			return nil, fmt.Errorf("NewDNSProvider failed: invalid port %d", opts.Port)
+		}
+	case RecordSRV:
+	default:
+		return nil, fmt.Errorf("NewDNSProvider failed: unknown record type %q", opts.Record)
+	}
+	if opts.Scheme == "" {
+		opts.Scheme = "http"
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	return &DNSProvider{
+		opts:     opts,
+		resolver: net.DefaultResolver,
+		log:      log,
+	}, nil
+}
+
+func (p *DNSProvider) Watch(ctx context.Context, update func([]Endpoint)) {
+	n := &notifier{update: update}
+	p.refresh(ctx, n)
+
+	go func() {
+		ticker := time.NewTicker(p.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.refresh(ctx, n)
+			}
+		}
+	}()
+}
+
+func (p *DNSProvider) refresh(ctx context.Context, n *notifier) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Interval)
+	defer cancel()
+	endpoints, err := p.resolve(ctx)
+	if err != nil {
+		// 解析失败时保留当前实例，避免 DNS 短暂故障导致服务不可用
+		p.log.WarnContext(ctx, "resolve discovery dns failed",
+			logger.String("name", p.opts.Name),
+			logger.Error(err),
+		)
+		return
+	}
+	n.notify(endpoints)
+}
+
+func (p *DNSProvider) resolve(ctx context.Context) ([]Endpoint, error) {
+	if p.opts.Record == RecordSRV {
+		_, records, err := p.resolver.LookupSRV(ctx, "", "", p.opts.Name)
+		if err != nil {
+			return nil, err
+		}
+		endpoints := make([]Endpoint, 0, len(records))
+		for _, rec := range records {
+			host := strings.TrimSuffix(rec.Target, ".")
+			endpoints = append(endpoints, Endpoint{
+				URL:    p.opts.Scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(rec.Port))),
+				Weight: int(rec.Weight),
+			})
+		}
+		return endpoints, nil
+	}
+
+	addrs, err := p.resolver.LookupHost(ctx, p.opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, Endpoint{
+			URL: p.opts.Scheme + "://" + net.JoinHostPort(addr, strconv.Itoa(p.opts.Port)),
+		})
+	}
+	return endpoints, nil
+}
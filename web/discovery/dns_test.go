@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewDNSProviderOptions(t *testing.T) {
+	invalid := []DNSOptions{
+		{},
+		{Name: "controller"},
+		{Name: "controller", Port: 70000},
+		{Name: "controller", Record: "mx", Port: 80},
+	}
+	for _, opts := range invalid {
+		if _, err := NewDNSProvider(opts, nil); err == nil {
+			t.Errorf("NewDNSProvider(%+v) succeeded, want error", opts)
+		}
+	}
+
+	p, err := NewDNSProvider(DNSOptions{Name: "_http._tcp.controller", Record: "SRV"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.opts.Record != RecordSRV || p.opts.Scheme != "http" || p.opts.Interval != 30*time.Second {
+		t.Fatalf("unexpected defaults %+v", p.opts)
+	}
+}
+
+func TestDNSProviderResolveA(t *testing.T) {
+	p, err := NewDNSProvider(DNSOptions{Name: "localhost", Port: 8081, Scheme: "https"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints, err := p.resolve(context.Background())
+	if err != nil {
+		t.Skipf("resolve localhost: %v", err)
+	}
+	if len(endpoints) == 0 {
+		t.Fatal("no endpoints for localhost")
+	}
+	for _, ep := range endpoints {
+		if ep.URL != "https://127.0.0.1:8081" && ep.URL != "https://[::1]:8081" {
+			t.Errorf("unexpected endpoint %q", ep.URL)
+		}
+	}
+}
+
+func TestDNSProviderResolveError(t *testing.T) {
+	for _, record := range []string{RecordA, RecordSRV} {
+		p, err := NewDNSProvider(DNSOptions{Name: "controller.gateway.invalid", Record: record, Port: 8081}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errors.New("dns server unreachable")
+			},
+		}
+		if endpoints, err := p.resolve(context.Background()); err == nil {
+			t.Errorf("%s: resolve = %v, want error", record, endpoints)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+// FileProvider 从本地文件读取服务实例，文件变化后自动重新加载，文件格式与 proxy.services 一致:
+//
+//	services:
+//	  - name: "online-judge-controller"
+//	    instances:
+//	      - url: "http://10.0.0.1:8081"
+//	        weight: 1
+type FileProvider struct {
+	path    string
+	service string
+	log     loggerv2.Logger
+}
+
+type fileService struct {
+	Name      string
+	Instances []Endpoint
+}
+
+func NewFileProvider(path, service string, log loggerv2.Logger) (*FileProvider, error) {
+	p := &FileProvider{
+		path:    path,
+		service: service,
+		log:     log,
+	}
+	if _, err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *FileProvider) Watch(ctx context.Context, update func([]Endpoint)) {
+	n := &notifier{update: update}
+	endpoints, err := p.load()
+	if err == nil {
+		n.notify(endpoints)
+	}
+
+	// 监听所在目录而不是文件本身，以兼容编辑器和 ConfigMap 通过重命名替换文件的方式
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		p.log.ErrorContext(ctx, "create file watcher failed",
+			logger.String("path", p.path),
+			logger.Error(err),
+		)
+		return
+	}
+	if err = watcher.Add(filepath.Dir(p.path)); err != nil {
+		watcher.Close()
+		p.log.ErrorContext(ctx, "watch discovery file failed",
+			logger.String("path", p.path),
+			logger.Error(err),
+		)
+		return
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-watcher.Events:
+				// ctx 与事件同时就绪时 select 随机选择，停止后不再重新加载
+				if !ok || ctx.Err() != nil {
+					return
+				}
+				endpoints, err := p.load()
+				if err != nil {
+					// 文件替换过程中可能短暂不可读或内容不完整，保留当前实例
+					p.log.WarnContext(ctx, "reload discovery file failed",
+						logger.String("path", p.path),
+						logger.Error(err),
+					)
+					continue
+				}
+				n.notify(endpoints)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				p.log.WarnContext(ctx, "discovery file watcher error",
+					logger.String("path", p.path),
+					logger.Error(err),
+				)
+			}
+		}
+	}()
+}
+
+func (p *FileProvider) load() ([]Endpoint, error) {
+	v := viper.New()
+	v.SetConfigFile(p.path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read discovery file failed: %w", err)
+	}
+	var services []fileService
+	if err := v.UnmarshalKey("services", &services); err != nil {
+		return nil, fmt.Errorf("unmarshal discovery file failed: %w", err)
+	}
+	// 文件中缺少该服务或实例为空时视为读取失败而不是下线全部实例，
+	// 避免文件被截断或误删条目导致服务不可用
+	for _, svc := range services {
+		if svc.Name != p.service {
+			continue
+		}
+		if len(svc.Instances) == 0 {
+			return nil, fmt.Errorf("discovery file has no instances for service %q", p.service)
+		}
+		return svc.Instances, nil
+	}
+	return nil, fmt.Errorf("discovery file has no service %q", p.service)
+}
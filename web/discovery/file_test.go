@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+func writeDiscoveryFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileProviderLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instances.yaml")
+	p := &FileProvider{path: path, service: "controller"}
+
+	writeDiscoveryFile(t, path, `
+services:
+  - name: "controller"
+    instances:
+      - url: "http://10.0.0.1:8081"
+        weight: 2
+      - url: "http://10.0.0.2:8081"
+`)
+	endpoints, err := p.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 2 || endpoints[0] != (Endpoint{URL: "http://10.0.0.1:8081", Weight: 2}) {
+		t.Fatalf("endpoints = %v", endpoints)
+	}
+
+	// 缺少服务、实例为空或文件为空时返回错误，由调用方保留当前实例
+	invalid := map[string]string{
+		"missing service": "services:\n  - name: \"other\"\n    instances:\n      - url: \"http://10.0.0.3:8081\"\n",
+		"empty instances": "services:\n  - name: \"controller\"\n    instances: []\n",
+		"empty file":      "",
+		"malformed":       "services: [",
+	}
+	for name, content := range invalid {
+		writeDiscoveryFile(t, path, content)
+		if endpoints, err := p.load(); err == nil {
+			t.Errorf("%s: load = %v, want error", name, endpoints)
+		}
+	}
+
+	if _, err := NewFileProvider(filepath.Join(t.TempDir(), "missing.yaml"), "controller", nil); err == nil {
+		t.Fatal("NewFileProvider succeeded for missing file")
+	}
+}
+
+func TestFileProviderWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instances.yaml")
+	writeDiscoveryFile(t, path, "services:\n  - name: \"controller\"\n    instances:\n      - url: \"http://10.0.0.1:8081\"\n")
+	provider, err := NewFileProvider(path, "controller", struct{ loggerv2.Logger }{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan []Endpoint, 4)
+	provider.Watch(ctx, func(endpoints []Endpoint) {
+		updates <- endpoints
+	})
+	if got := waitEndpoints(t, updates); len(got) != 1 || got[0].URL != "http://10.0.0.1:8081" {
+		t.Fatalf("endpoints = %v", got)
+	}
+
+	// 先写入临时文件再重命名，模拟 ConfigMap 的原子替换
+	tmp := path + ".tmp"
+	writeDiscoveryFile(t, tmp, "services:\n  - name: \"controller\"\n    instances:\n      - url: \"http://10.0.0.2:8081\"\n")
+	if err = os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitEndpoints(t, updates); len(got) != 1 || got[0].URL != "http://10.0.0.2:8081" {
+		t.Fatalf("endpoints after reload = %v", got)
+	}
+}
@@ -0,0 +1,223 @@
+package grpcx
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const frameHeaderLen = 5 // gRPC 消息帧头: 1 字节压缩标志 + 4 字节大端长度
+
+var ErrCompressedMessage = errors.New("compressed grpc message not supported")
+
+// Codec 基于 proto 描述文件，在 JSON 与某个 gRPC 服务的消息之间转换
+type Codec struct {
+	service protoreflect.ServiceDescriptor
+}
+
+// LoadCodec 从 protoc --descriptor_set_out --include_imports 生成的描述文件中加载服务
+func LoadCodec(descriptorSetPath, serviceName string) (*Codec, error) {
+	data, err := os.ReadFile(descriptorSetPath)
+	if err != nil {
+		return nil, fmt.Errorf("read descriptor set failed: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("unmarshal descriptor set failed: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("build descriptor set failed: %w", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found in descriptor set: %w", serviceName, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", serviceName)
+	}
+	return NewCodec(sd), nil
+}
+
+func NewCodec(sd protoreflect.ServiceDescriptor) *Codec {
+	return &Codec{service: sd}
+}
+
+// ServiceName 返回 proto 服务全名，如 judge.v1.JudgeService
+func (c *Codec) ServiceName() string {
+	return string(c.service.FullName())
+}
+
+// HasMethod 判断服务是否定义了该方法，包括流式方法
+func (c *Codec) HasMethod(name string) bool {
+	return c.service.Methods().ByName(protoreflect.Name(name)) != nil
+}
+
+// UnaryMethod 返回可转码的一元方法
+func (c *Codec) UnaryMethod(name string) (protoreflect.MethodDescriptor, bool) {
+	md := c.service.Methods().ByName(protoreflect.Name(name))
+	if md == nil || md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, false
+	}
+	return md, true
+}
+
+// MethodPath 返回方法的 HTTP/2 路径，如 /judge.v1.JudgeService/GetStatus
+func (c *Codec) MethodPath(md protoreflect.MethodDescriptor) string {
+	return "/" + c.ServiceName() + "/" + string(md.Name())
+}
+
+// SplitMethodPath 将 /<service>/<method> 拆分为服务全名和方法名
+func SplitMethodPath(path string) (service, method string, ok bool) {
+	service, method, ok = strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", false
+	}
+	return service, method, true
+}
+
+// EncodeRequest 将 JSON 请求体和查询参数转换为方法的请求消息，并编码为 gRPC 消息帧。
+// 查询参数只能设置请求消息的顶层标量字段，会覆盖请求体中的同名字段
+func EncodeRequest(md protoreflect.MethodDescriptor, body []byte, query url.Values) ([]byte, error) {
+	msg := dynamicpb.NewMessage(md.Input())
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := protojson.Unmarshal(body, msg); err != nil {
+			return nil, fmt.Errorf("invalid request body: %w", err)
+		}
+	}
+	if err := setQueryFields(msg, query); err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request failed: %w", err)
+	}
+	frame := make([]byte, frameHeaderLen+len(data))
+	binary.BigEndian.PutUint32(frame[1:frameHeaderLen], uint32(len(data)))
+	copy(frame[frameHeaderLen:], data)
+	return frame, nil
+}
+
+// DecodeResponse 解析一元方法的响应消息帧并转换为 JSON
+func DecodeResponse(md protoreflect.MethodDescriptor, body []byte) ([]byte, error) {
+	if len(body) < frameHeaderLen {
+		return nil, fmt.Errorf("grpc response too short: %d bytes", len(body))
+	}
+	if body[0] != 0 {
+		return nil, ErrCompressedMessage
+	}
+	n := binary.BigEndian.Uint32(body[1:frameHeaderLen])
+	if uint64(len(body)-frameHeaderLen) < uint64(n) {
+		return nil, fmt.Errorf("grpc response truncated: want %d bytes, got %d", n, len(body)-frameHeaderLen)
+	}
+	msg := dynamicpb.NewMessage(md.Output())
+	if err := proto.Unmarshal(body[frameHeaderLen:frameHeaderLen+int(n)], msg); err != nil {
+		return nil, fmt.Errorf("unmarshal response failed: %w", err)
+	}
+	return protojson.Marshal(msg)
+}
+
+func setQueryFields(msg *dynamicpb.Message, query url.Values) error {
+	fields := msg.Descriptor().Fields()
+	for key, values := range query {
+		fd := fields.ByJSONName(key)
+		if fd == nil {
+			fd = fields.ByName(protoreflect.Name(key))
+		}
+		if fd == nil {
+			return fmt.Errorf("unknown field %q", key)
+		}
+		if fd.IsMap() || fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			return fmt.Errorf("field %q cannot be set by query parameter", key)
+		}
+		if fd.IsList() {
+			list := msg.Mutable(fd).List()
+			list.Truncate(0)
+			for _, s := range values {
+				v, err := parseScalar(fd, s)
+				if err != nil {
+					return err
+				}
+				list.Append(v)
+			}
+			continue
+		}
+		v, err := parseScalar(fd, values[len(values)-1])
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	var (
+		v   protoreflect.Value
+		err error
+	)
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		v = protoreflect.ValueOfInt32(int32(i))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 64)
+		v = protoreflect.ValueOfInt64(i)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, 32)
+		v = protoreflect.ValueOfUint32(uint32(u))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var u uint64
+		u, err = strconv.ParseUint(s, 10, 64)
+		v = protoreflect.ValueOfUint64(u)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 32)
+		v = protoreflect.ValueOfFloat32(float32(f))
+	case protoreflect.DoubleKind:
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		v = protoreflect.ValueOfFloat64(f)
+	case protoreflect.StringKind:
+		v = protoreflect.ValueOfString(s)
+	case protoreflect.BytesKind:
+		var b []byte
+		b, err = base64.StdEncoding.DecodeString(s)
+		v = protoreflect.ValueOfBytes(b)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			v = protoreflect.ValueOfEnum(ev.Number())
+			break
+		}
+		var i int64
+		i, err = strconv.ParseInt(s, 10, 32)
+		v = protoreflect.ValueOfEnum(protoreflect.EnumNumber(i))
+	default:
+		return v, fmt.Errorf("field %q cannot be set by query parameter", fd.JSONName())
+	}
+	if err != nil {
+		return v, fmt.Errorf("invalid value %q for field %q: %w", s, fd.JSONName(), err)
+	}
+	return v, nil
+}
@@ -0,0 +1,289 @@
+package grpcx
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testDescriptorSet 返回相当于以下 proto 的描述文件:
+//
+//	package judge.v1;
+//	enum Verdict { VERDICT_UNSPECIFIED = 0; ACCEPTED = 1; }
+//	message Inner { string note = 1; }
+//	message GetStatusRequest { int64 submission_id = 1; string lang = 2; repeated int32 cases = 3; Verdict verdict = 4; Inner inner = 5; }
+//	message GetStatusResponse { string status = 1; int64 score = 2; }
+//	service JudgeService { rpc GetStatus(GetStatusRequest) returns (GetStatusResponse); rpc Watch(GetStatusRequest) returns (stream GetStatusResponse); }
+func testDescriptorSet() *descriptorpb.FileDescriptorSet {
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  label.Enum(),
+			Type:   typ.Enum(),
+		}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	return &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("judge/v1/judge.proto"),
+		Package: proto.String("judge.v1"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Verdict"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("VERDICT_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("ACCEPTED"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name:  proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("note", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, "")},
+			},
+			{
+				Name: proto.String("GetStatusRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("submission_id", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					field("lang", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("cases", 3, repeated, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					field("verdict", 4, optional, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".judge.v1.Verdict"),
+					field("inner", 5, optional, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".judge.v1.Inner"),
+				},
+			},
+			{
+				Name: proto.String("GetStatusResponse"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("status", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("score", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("JudgeService"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("GetStatus"), InputType: proto.String(".judge.v1.GetStatusRequest"), OutputType: proto.String(".judge.v1.GetStatusResponse")},
+				{Name: proto.String("Watch"), InputType: proto.String(".judge.v1.GetStatusRequest"), OutputType: proto.String(".judge.v1.GetStatusResponse"), ServerStreaming: proto.Bool(true)},
+			},
+		}},
+	}}}
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "judge.pb")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestCodec(t *testing.T) *Codec {
+	t.Helper()
+	data, err := proto.Marshal(testDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, err := LoadCodec(writeFile(t, data), "judge.v1.JudgeService")
+	if err != nil {
+		t.Fatalf("LoadCodec: %v", err)
+	}
+	return codec
+}
+
+func TestLoadCodec(t *testing.T) {
+	codec := loadTestCodec(t)
+	if name := codec.ServiceName(); name != "judge.v1.JudgeService" {
+		t.Fatalf("ServiceName = %q", name)
+	}
+	if !codec.HasMethod("GetStatus") || !codec.HasMethod("Watch") || codec.HasMethod("Submit") {
+		t.Fatal("HasMethod must report every method of the service")
+	}
+	md, ok := codec.UnaryMethod("GetStatus")
+	if !ok {
+		t.Fatal("GetStatus is not unary")
+	}
+	if path := codec.MethodPath(md); path != "/judge.v1.JudgeService/GetStatus" {
+		t.Fatalf("MethodPath = %q", path)
+	}
+	if _, ok = codec.UnaryMethod("Watch"); ok {
+		t.Fatal("streaming method returned as unary")
+	}
+
+	valid, _ := proto.Marshal(testDescriptorSet())
+	missingImport := testDescriptorSet()
+	missingImport.File[0].Dependency = []string{"google/protobuf/empty.proto"}
+	missing, _ := proto.Marshal(missingImport)
+	cases := []struct {
+		name, path, service string
+	}{
+		{"no file", filepath.Join(t.TempDir(), "missing.pb"), "judge.v1.JudgeService"},
+		{"not a descriptor set", writeFile(t, []byte("not a descriptor set")), "judge.v1.JudgeService"},
+		{"missing import", writeFile(t, missing), "judge.v1.JudgeService"},
+		{"unknown service", writeFile(t, valid), "judge.v1.ProblemService"},
+		{"not a service", writeFile(t, valid), "judge.v1.GetStatusRequest"},
+	}
+	for _, tc := range cases {
+		if _, err := LoadCodec(tc.path, tc.service); err == nil {
+			t.Errorf("%s: LoadCodec succeeded, want error", tc.name)
+		}
+	}
+}
+
+func TestSplitMethodPath(t *testing.T) {
+	cases := []struct {
+		path            string
+		service, method string
+		ok              bool
+	}{
+		{"/judge.v1.JudgeService/GetStatus", "judge.v1.JudgeService", "GetStatus", true},
+		{"/judge.v1.JudgeService", "", "", false},
+		{"/judge.v1.JudgeService/", "", "", false},
+		{"//GetStatus", "", "", false},
+		{"/judge.v1.JudgeService/Get/Status", "", "", false},
+	}
+	for _, tc := range cases {
+		service, method, ok := SplitMethodPath(tc.path)
+		if service != tc.service || method != tc.method || ok != tc.ok {
+			t.Errorf("SplitMethodPath(%q) = %q, %q, %t, want %q, %q, %t",
+				tc.path, service, method, ok, tc.service, tc.method, tc.ok)
+		}
+	}
+}
+
+// decodeFrame 解析 gRPC 消息帧并以 proto3 JSON 的字段名返回消息内容
+func decodeFrame(t *testing.T, md protoreflect.MethodDescriptor, frame []byte) map[string]any {
+	t.Helper()
+	if len(frame) < frameHeaderLen || frame[0] != 0 {
+		t.Fatalf("invalid frame header % x", frame)
+	}
+	if n := binary.BigEndian.Uint32(frame[1:frameHeaderLen]); int(n) != len(frame)-frameHeaderLen {
+		t.Fatalf("frame length = %d, want %d", n, len(frame)-frameHeaderLen)
+	}
+	msg := dynamicpb.NewMessage(md.Input())
+	if err := proto.Unmarshal(frame[frameHeaderLen:], msg); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+	fields := make(map[string]any)
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			var list []int32
+			for i := 0; i < v.List().Len(); i++ {
+				list = append(list, int32(v.List().Get(i).Int()))
+			}
+			fields[fd.JSONName()] = list
+		case fd.Kind() == protoreflect.EnumKind:
+			fields[fd.JSONName()] = int32(v.Enum())
+		case fd.Kind() == protoreflect.MessageKind:
+			fields[fd.JSONName()] = v.Message().Get(fd.Message().Fields().ByName("note")).String()
+		default:
+			fields[fd.JSONName()] = v.Interface()
+		}
+		return true
+	})
+	return fields
+}
+
+func TestEncodeRequest(t *testing.T) {
+	md, _ := loadTestCodec(t).UnaryMethod("GetStatus")
+
+	cases := []struct {
+		name, body, query string
+		want              map[string]any
+	}{
+		{"empty", "", "", map[string]any{}},
+		{"body", `{"submissionId": "12", "lang": "go", "inner": {"note": "n"}}`, "",
+			map[string]any{"submissionId": int64(12), "lang": "go", "inner": "n"}},
+		{"proto field name", `{"submission_id": 12}`, "", map[string]any{"submissionId": int64(12)}},
+		// 查询参数覆盖请求体中的同名字段
+		{"query overrides body", `{"lang": "go", "cases": [1]}`, "lang=cpp&submission_id=7&cases=2&cases=3",
+			map[string]any{"submissionId": int64(7), "lang": "cpp", "cases": []int32{2, 3}}},
+		{"enum by name", "", "verdict=ACCEPTED", map[string]any{"verdict": int32(1)}},
+		{"enum by number", "", "verdict=1", map[string]any{"verdict": int32(1)}},
+	}
+	for _, tc := range cases {
+		query, _ := url.ParseQuery(tc.query)
+		frame, err := EncodeRequest(md, []byte(tc.body), query)
+		if err != nil {
+			t.Errorf("%s: EncodeRequest: %v", tc.name, err)
+			continue
+		}
+		if got := decodeFrame(t, md, frame); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: request = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	invalid := []struct {
+		name, body, query string
+	}{
+		{"bad json", `{"lang": `, ""},
+		{"not an object", `[1]`, ""},
+		{"unknown body field", `{"unknown": 1}`, ""},
+		{"wrong body type", `{"submissionId": "abc"}`, ""},
+		{"unknown query field", "", "unknown=1"},
+		{"message field in query", "", "inner=x"},
+		{"invalid integer", "", "submission_id=abc"},
+		{"int32 overflow", "", "cases=4294967296"},
+	}
+	for _, tc := range invalid {
+		query, _ := url.ParseQuery(tc.query)
+		if _, err := EncodeRequest(md, []byte(tc.body), query); err == nil {
+			t.Errorf("%s: EncodeRequest succeeded, want error", tc.name)
+		}
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	md, _ := loadTestCodec(t).UnaryMethod("GetStatus")
+	resp := dynamicpb.NewMessage(md.Output())
+	resp.Set(md.Output().Fields().ByName("status"), protoreflect.ValueOfString("accepted"))
+	resp.Set(md.Output().Fields().ByName("score"), protoreflect.ValueOfInt64(100))
+	data, err := proto.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, frameHeaderLen+len(data))
+	binary.BigEndian.PutUint32(frame[1:frameHeaderLen], uint32(len(data)))
+	copy(frame[frameHeaderLen:], data)
+
+	body, err := DecodeResponse(md, frame)
+	if err != nil {
+		t.Fatalf("DecodeResponse: %v", err)
+	}
+	var got map[string]any
+	if err = json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("response is not JSON: %s", body)
+	}
+	// proto3 JSON 中 int64 编码为字符串
+	if want := map[string]any{"status": "accepted", "score": "100"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("response = %v, want %v", got, want)
+	}
+
+	compressed := append([]byte(nil), frame...)
+	compressed[0] = 1
+	invalid := map[string][]byte{
+		"too short":   frame[:frameHeaderLen-1],
+		"compressed":  compressed,
+		"truncated":   frame[:len(frame)-1],
+		"bad message": append([]byte{0, 0, 0, 0, 2}, 0x0a, 0x05),
+	}
+	for name, body := range invalid {
+		if _, err = DecodeResponse(md, body); err == nil {
+			t.Errorf("%s: DecodeResponse succeeded, want error", name)
+		}
+	}
+}
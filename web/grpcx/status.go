@@ -0,0 +1,143 @@
+package grpcx
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ContentType = "application/grpc"
+
+	HeaderStatus  = "Grpc-Status"
+	HeaderMessage = "Grpc-Message"
+	HeaderTimeout = "Grpc-Timeout"
+)
+
+// Code gRPC 状态码
+type Code int
+
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+// HTTPStatus 将 gRPC 状态码映射为 HTTP 状态码，与 grpc-gateway 的映射一致
+func HTTPStatus(code Code) int {
+	switch code {
+	case OK:
+		return http.StatusOK
+	case Canceled:
+		return 499
+	case InvalidArgument, FailedPrecondition, OutOfRange:
+		return http.StatusBadRequest
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case NotFound:
+		return http.StatusNotFound
+	case AlreadyExists, Aborted:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case Unimplemented:
+		return http.StatusNotImplemented
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ParseStatus 从响应的 trailer 或 header (Trailers-Only 响应) 中解析 gRPC 状态
+func ParseStatus(resp *http.Response) (Code, string, bool) {
+	raw := resp.Trailer.Get(HeaderStatus)
+	msg := resp.Trailer.Get(HeaderMessage)
+	if raw == "" {
+		raw = resp.Header.Get(HeaderStatus)
+		msg = resp.Header.Get(HeaderMessage)
+	}
+	if raw == "" {
+		return Unknown, "", false
+	}
+	code, err := strconv.Atoi(raw)
+	if err != nil {
+		return Unknown, "", false
+	}
+	return Code(code), decodeMessage(msg), true
+}
+
+// WriteStatus 以 Trailers-Only 形式返回 gRPC 错误
+func WriteStatus(w http.ResponseWriter, code Code, msg string) {
+	h := w.Header()
+	h.Set("Content-Type", ContentType)
+	h.Set(HeaderStatus, strconv.Itoa(int(code)))
+	h.Set(HeaderMessage, encodeMessage(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// FormatTimeout 将剩余时间编码为 grpc-timeout 请求头，最多 8 位数字
+func FormatTimeout(d time.Duration) string {
+	ms := max(d.Milliseconds(), 1)
+	if ms <= 99999999 {
+		return strconv.FormatInt(ms, 10) + "m"
+	}
+	return strconv.FormatInt(min(int64(d/time.Second), 99999999), 10) + "S"
+}
+
+// encodeMessage 按 gRPC 协议对 grpc-message 做百分号编码
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		ch := msg[i]
+		if ch < 0x20 || ch > 0x7e || ch == '%' {
+			fmt.Fprintf(&b, "%%%02X", ch)
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+func decodeMessage(msg string) string {
+	if !strings.Contains(msg, "%") {
+		return msg
+	}
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if msg[i] == '%' && i+2 < len(msg) {
+			if v, err := strconv.ParseUint(msg[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(msg[i])
+	}
+	return b.String()
+}
+
+// IsGRPCRequest 判断是否为客户端直接发起的 gRPC 请求
+func IsGRPCRequest(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), ContentType)
+}
@@ -0,0 +1,85 @@
+package grpcx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	cases := map[Code]int{
+		OK:                 http.StatusOK,
+		Canceled:           499,
+		InvalidArgument:    http.StatusBadRequest,
+		FailedPrecondition: http.StatusBadRequest,
+		DeadlineExceeded:   http.StatusGatewayTimeout,
+		NotFound:           http.StatusNotFound,
+		AlreadyExists:      http.StatusConflict,
+		PermissionDenied:   http.StatusForbidden,
+		ResourceExhausted:  http.StatusTooManyRequests,
+		Unimplemented:      http.StatusNotImplemented,
+		Unavailable:        http.StatusServiceUnavailable,
+		Unauthenticated:    http.StatusUnauthorized,
+		Internal:           http.StatusInternalServerError,
+		Code(99):           http.StatusInternalServerError,
+	}
+	for code, want := range cases {
+		if got := HTTPStatus(code); got != want {
+			t.Errorf("HTTPStatus(%d) = %d, want %d", code, got, want)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	cases := []struct {
+		name            string
+		header, trailer http.Header
+		code            Code
+		msg             string
+		ok              bool
+	}{
+		{"trailer", http.Header{}, http.Header{HeaderStatus: {"5"}, HeaderMessage: {"not found"}}, NotFound, "not found", true},
+		// Trailers-Only 响应的状态在响应头中
+		{"trailers only", http.Header{HeaderStatus: {"14"}, HeaderMessage: {"down"}}, http.Header{}, Unavailable, "down", true},
+		{"percent encoded", http.Header{}, http.Header{HeaderStatus: {"3"}, HeaderMessage: {"bad%20id%3A%E4%BD%A0"}}, InvalidArgument, "bad id:你", true},
+		{"missing", http.Header{}, http.Header{}, Unknown, "", false},
+		{"not a number", http.Header{HeaderStatus: {"x"}}, http.Header{}, Unknown, "", false},
+	}
+	for _, tc := range cases {
+		code, msg, ok := ParseStatus(&http.Response{Header: tc.header, Trailer: tc.trailer})
+		if code != tc.code || msg != tc.msg || ok != tc.ok {
+			t.Errorf("%s: ParseStatus = %d, %q, %t, want %d, %q, %t", tc.name, code, msg, ok, tc.code, tc.msg, tc.ok)
+		}
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	WriteStatus(w, PermissionDenied, "100% denied\n")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("code = %d, content type = %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if got := w.Header().Get(HeaderMessage); got != "100%25 denied%0A" {
+		t.Fatalf("%s = %q, want percent encoded", HeaderMessage, got)
+	}
+	code, msg, ok := ParseStatus(&http.Response{Header: w.Header(), Trailer: http.Header{}})
+	if !ok || code != PermissionDenied || msg != "100% denied\n" {
+		t.Fatalf("ParseStatus = %d, %q, %t", code, msg, ok)
+	}
+}
+
+func TestFormatTimeout(t *testing.T) {
+	cases := map[time.Duration]string{
+		0:                           "1m",
+		1500 * time.Microsecond:     "1m",
+		2 * time.Second:             "2000m",
+		99999999 * time.Millisecond: "99999999m",
+		100000 * time.Second:        "100000S",
+	}
+	for d, want := range cases {
+		if got := FormatTimeout(d); got != want {
+			t.Errorf("FormatTimeout(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package jwt
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -16,48 +19,182 @@ import (
 )
 
 var (
-	ssidKey             = "users:ssid:%s"
-	userTokenVersionKey = "users:token_version:%d"
+	userSessionsKey = "users:sessions:%d" // zset: 用户有效的会话 ID -> 最近活跃时间 (毫秒)，精度为 sessionTouchInterval
+	ssidKey         = "users:ssid:%s"     // hash: uid, user_agent, ip, created_at (毫秒)，会话的登录信息
+	refreshTokenKey = "users:refresh:%s"  // hash: uid, gen (当前有效的刷新令牌序号), rotated_at (最近一次轮换时间，毫秒)
 )
 
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+
+	ErrCompetitionTokenMissing = errors.New("competition token missing")
+	ErrCompetitionTokenInvalid = errors.New("competition token invalid")
+
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// loginSessionScript 登记新会话，保存会话的登录信息并创建刷新令牌记录：清理超过会话有效期未活跃的会话，
+// 会话数超过上限时移除最久未活跃的会话并返回其会话 ID；上限为 0 表示不限制
+// KEYS: userSessionsKey, refreshTokenKey, ssidKey
+// ARGV: 会话 ID, 用户 ID, 当前时间 (毫秒), 会话数上限, 会话有效期 (毫秒), 刷新令牌有效期 (毫秒), User-Agent, 客户端 IP
+var loginSessionScript = redis.NewScript(`
+local now = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - tonumber(ARGV[5]))
+redis.call('ZADD', KEYS[1], now, ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+redis.call('HSET', KEYS[2], 'uid', ARGV[2], 'gen', 1)
+redis.call('PEXPIRE', KEYS[2], ARGV[6])
+redis.call('HSET', KEYS[3], 'uid', ARGV[2], 'user_agent', ARGV[7], 'ip', ARGV[8], 'created_at', ARGV[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[5])
+
+local evicted = {}
+local limit = tonumber(ARGV[4])
+if limit <= 0 then
+	return evicted
+end
+local excess = redis.call('ZCARD', KEYS[1]) - limit
+if excess <= 0 then
+	return evicted
+end
+for _, ssid in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	if #evicted >= excess then
+		break
+	end
+	if ssid ~= ARGV[1] then
+		table.insert(evicted, ssid)
+	end
+end
+redis.call('ZREM', KEYS[1], unpack(evicted))
+return evicted
+`)
+
+// rotateRefreshScript 校验刷新令牌序号并轮换：序号等于当前序号时递增并返回新序号，同时更新会话的活跃时间；
+// 上一次轮换后的宽限期内再次使用上一个序号 (同一会话的多个页面并发刷新) 时不轮换，返回当前序号；
+// 其它序号小于当前序号的情况说明已轮换的令牌被再次使用，撤销整个会话并返回 -2；会话不存在或已撤销返回 -1
+// KEYS: refreshTokenKey, userSessionsKey, ssidKey
+// ARGV: 用户 ID, 令牌序号, 刷新令牌有效期 (毫秒), 会话 ID, 当前时间 (毫秒), 会话有效期 (毫秒), 并发刷新宽限期 (毫秒)
+var rotateRefreshScript = redis.NewScript(`
+if not redis.call('ZSCORE', KEYS[2], ARGV[4]) then
+	return -1
+end
+local uid = redis.call('HGET', KEYS[1], 'uid')
+local cur = tonumber(redis.call('HGET', KEYS[1], 'gen'))
+if uid ~= ARGV[1] or not cur then
+	return -1
+end
+local gen = tonumber(ARGV[2])
+local now = tonumber(ARGV[5])
+local rotatedAt = tonumber(redis.call('HGET', KEYS[1], 'rotated_at'))
+local next = cur
+if gen == cur then
+	next = redis.call('HINCRBY', KEYS[1], 'gen', 1)
+	redis.call('HSET', KEYS[1], 'rotated_at', now)
+elseif gen > cur then
+	return -1
+elseif gen < cur - 1 or not rotatedAt or now - rotatedAt > tonumber(ARGV[7]) then
+	redis.call('DEL', KEYS[1], KEYS[3])
+	redis.call('ZREM', KEYS[2], ARGV[4])
+	return -2
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('ZADD', KEYS[2], 'XX', now, ARGV[4])
+redis.call('PEXPIRE', KEYS[2], ARGV[6])
+redis.call('PEXPIRE', KEYS[3], ARGV[6])
+return next
+`)
+
 type RedisJWTHandler struct {
-	client        redis.Cmdable
-	signingMethod jwt.SigningMethod
-	jwtExpiration time.Duration
-	jwtKey        []byte
+	client            redis.Cmdable
+	signingMethod     jwt.SigningMethod
+	jwtExpiration     time.Duration
+	jwtKey            []byte
+	refreshExpiration time.Duration
+	refreshKey        []byte       // 由 jwtKey 派生，刷新令牌不能当作访问令牌使用
+	competitionKey    []byte       // 由 jwtKey 派生，比赛令牌不能当作访问令牌或刷新令牌使用
+	maxSessions       map[int8]int // 角色 -> 同时有效的会话数上限
 }
 
-func NewRedisJWTHandler(client redis.Cmdable, jwtKey []byte, jwtExpiration time.Duration) Handler {
+// NewRedisJWTHandler jwtExpiration 为访问令牌有效期，refreshExpiration 为刷新令牌有效期，每次刷新后重新计算；
+// maxSessions 为各角色同时有效的会话数上限，0 表示不限制，未配置的角色只保留最近登录的一个会话
+func NewRedisJWTHandler(client redis.Cmdable, jwtKey []byte, jwtExpiration, refreshExpiration time.Duration, maxSessions map[int8]int) Handler {
 	return &RedisJWTHandler{
-		client:        client,
-		signingMethod: jwt.SigningMethodHS512,
-		jwtExpiration: jwtExpiration,
-		jwtKey:        jwtKey,
+		client:            client,
+		signingMethod:     jwt.SigningMethodHS512,
+		jwtExpiration:     jwtExpiration,
+		jwtKey:            jwtKey,
+		refreshExpiration: refreshExpiration,
+		refreshKey:        deriveKey(jwtKey, "refresh token"),
+		competitionKey:    deriveKey(jwtKey, "competition token"),
+		maxSessions:       maxSessions,
 	}
 }
 
+func deriveKey(jwtKey []byte, label string) []byte {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
 var _ Handler = &RedisJWTHandler{}
 
-func (h *RedisJWTHandler) CheckSession(ctx *gin.Context, ssid string) error {
-	cnt, err := h.client.Exists(ctx, fmt.Sprintf(ssidKey, ssid)).Result()
+const refreshCookiePath = "/auth"
+
+// sessionTouchInterval 请求校验会话时更新活跃时间的最小间隔，避免每个请求都写 Redis
+const sessionTouchInterval = time.Minute
+
+// refreshReuseGrace 刷新令牌轮换后的该时间内，上一个刷新令牌仍可换取当前序号的令牌，用于同一会话的多个页面并发刷新
+const refreshReuseGrace = 10 * time.Second
+
+// CheckSession 检查会话是否仍在用户的会话集合中，登出、被新登录挤下线或被撤销的会话返回错误；
+// 会话的活跃时间超过 sessionTouchInterval 未更新时更新为当前时间
+func (h *RedisJWTHandler) CheckSession(ctx *gin.Context, uid uint64, ssid string) error {
+	key := fmt.Sprintf(userSessionsKey, uid)
+	lastSeen, err := h.client.ZScore(ctx, key, ssid).Result()
 	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return errors.New("token invalid")
+		}
 		return err
 	}
-	if cnt > 0 {
-		return errors.New("token invalid")
+	now := time.Now().UnixMilli()
+	if now-int64(lastSeen) >= sessionTouchInterval.Milliseconds() {
+		// XX 保证不会重新加入并发撤销的会话；更新失败只影响活跃时间的精度，不影响本次请求
+		h.client.ZAddXX(ctx, key, redis.Z{Score: float64(now), Member: ssid})
 	}
 	return nil
 }
 
 func (h *RedisJWTHandler) ClearToken(ctx *gin.Context) error {
 	ctx.Header(constants.HeaderLoginTokenKey, "")
+	ctx.Header(constants.HeaderRefreshTokenKey, "")
+	ctx.SetCookie(constants.HeaderRefreshTokenKey, "", -1, refreshCookiePath, "", false, true)
+	ctx.Header(constants.HeaderCompetitionTokenKey, "")
+	ctx.SetCookie(constants.HeaderCompetitionTokenKey, "", -1, "/", "", false, true)
 	uc := ctx.MustGet(constants.ContextUserClaimsKey).(UserClaims)
-	return h.client.Set(ctx, fmt.Sprintf(ssidKey, uc.Ssid), "", h.jwtExpiration).Err()
+	return h.revokeSessions(ctx, uc.UserId, uc.Ssid)
 }
 
-func (h *RedisJWTHandler) SetLoginToken(ctx *gin.Context, UserId uint64) error {
+// revokeSessions 将会话移出用户的会话集合并删除其刷新令牌记录和登录信息，会话的访问令牌随之失效
+func (h *RedisJWTHandler) revokeSessions(ctx *gin.Context, uid uint64, ssids ...string) error {
+	if len(ssids) == 0 {
+		return nil
+	}
+	_, err := h.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		members := make([]any, 0, len(ssids))
+		for _, ssid := range ssids {
+			members = append(members, ssid)
+			pipe.Del(ctx, fmt.Sprintf(refreshTokenKey, ssid), fmt.Sprintf(ssidKey, ssid))
+		}
+		pipe.ZRem(ctx, fmt.Sprintf(userSessionsKey, uid), members...)
+		return nil
+	})
+	return err
+}
+
+func (h *RedisJWTHandler) SetLoginToken(ctx *gin.Context, UserId uint64, role int8) error {
 	ssid := uuid.New().String()
-	return h.SetJWTToken(ctx, UserId, ssid)
+	return h.SetJWTToken(ctx, UserId, ssid, role)
 }
 
 func (h *RedisJWTHandler) ExtractToken(ctx *gin.Context) string {
@@ -80,20 +217,205 @@ func (h *RedisJWTHandler) ExtractToken(ctx *gin.Context) string {
 	return tokenFromCookie
 }
 
-func (h *RedisJWTHandler) SetJWTToken(ctx *gin.Context, UserId uint64, ssid string) error {
-	ver, err := h.GetUserTokenVersion(ctx, UserId)
+// SetJWTToken 为新登录的会话签发访问令牌和刷新令牌，会话数超过角色的上限时移除最久未活跃的会话
+func (h *RedisJWTHandler) SetJWTToken(ctx *gin.Context, UserId uint64, ssid string, role int8) error {
+	limit, ok := h.maxSessions[role]
+	if !ok {
+		limit = 1
+	}
+	evicted, err := loginSessionScript.Run(ctx, h.client,
+		[]string{fmt.Sprintf(userSessionsKey, UserId), fmt.Sprintf(refreshTokenKey, ssid), fmt.Sprintf(ssidKey, ssid)},
+		ssid, UserId, time.Now().UnixMilli(), limit, h.sessionExpiration().Milliseconds(), h.refreshExpiration.Milliseconds(),
+		ctx.GetHeader("User-Agent"), ctx.ClientIP(),
+	).StringSlice()
 	if err != nil {
+		return fmt.Errorf("SetJWTToken failed: register session failed: %w", err)
+	}
+	if len(evicted) > 0 {
+		// 被移除的会话已不能通过校验，这里只是清理其刷新令牌记录和登录信息
+		keys := make([]string, 0, 2*len(evicted))
+		for _, old := range evicted {
+			keys = append(keys, fmt.Sprintf(refreshTokenKey, old), fmt.Sprintf(ssidKey, old))
+		}
+		if err = h.client.Del(ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("SetJWTToken failed: delete evicted refresh tokens failed: %w", err)
+		}
+	}
+	if err = h.setAccessToken(ctx, UserId, ssid, role); err != nil {
 		return fmt.Errorf("SetJWTToken failed: %w", err)
 	}
-	ver += 1
-	if err = h.client.Set(ctx, fmt.Sprintf(userTokenVersionKey, UserId), ver, h.jwtExpiration).Err(); err != nil {
-		return fmt.Errorf("SetJWTToken failed: Set UserTokenVersion failed: %w", err)
+	if err = h.setRefreshToken(ctx, UserId, ssid, 1); err != nil {
+		return fmt.Errorf("SetJWTToken failed: %w", err)
+	}
+	return nil
+}
+
+// ListSessions 返回当前用户有效的会话，最近活跃的在前
+func (h *RedisJWTHandler) ListSessions(ctx *gin.Context) ([]Session, error) {
+	uc, err := h.GetUserClaims(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ListSessions failed: %w", err)
+	}
+	// 超过会话有效期未活跃的会话已无法使用，不再列出
+	members, err := h.client.ZRevRangeByScoreWithScores(ctx, fmt.Sprintf(userSessionsKey, uc.UserId), &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-h.sessionExpiration()).UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("ListSessions failed: %w", err)
+	}
+
+	infos := make([]*redis.MapStringStringCmd, len(members))
+	_, err = h.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, member := range members {
+			infos[i] = pipe.HGetAll(ctx, fmt.Sprintf(ssidKey, member.Member))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ListSessions failed: %w", err)
+	}
+
+	sessions := make([]Session, 0, len(members))
+	for i, member := range members {
+		ssid, _ := member.Member.(string)
+		info := infos[i].Val()
+		createdAt, _ := strconv.ParseInt(info["created_at"], 10, 64)
+		sessions = append(sessions, Session{
+			Ssid:      ssid,
+			UserAgent: info["user_agent"],
+			IP:        info["ip"],
+			CreatedAt: time.UnixMilli(createdAt),
+			LastSeen:  time.UnixMilli(int64(member.Score)),
+			Current:   ssid == uc.Ssid,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession 撤销当前用户的一个会话，会话不存在或不属于当前用户时返回 ErrSessionNotFound
+func (h *RedisJWTHandler) RevokeSession(ctx *gin.Context, ssid string) error {
+	uc, err := h.GetUserClaims(ctx)
+	if err != nil {
+		return fmt.Errorf("RevokeSession failed: %w", err)
+	}
+	err = h.client.ZScore(ctx, fmt.Sprintf(userSessionsKey, uc.UserId), ssid).Err()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return fmt.Errorf("RevokeSession failed: %w", ErrSessionNotFound)
+		}
+		return fmt.Errorf("RevokeSession failed: %w", err)
+	}
+	if ssid == uc.Ssid {
+		// 撤销当前会话等同于登出
+		return h.ClearToken(ctx)
+	}
+	if err = h.revokeSessions(ctx, uc.UserId, ssid); err != nil {
+		return fmt.Errorf("RevokeSession failed: %w", err)
+	}
+	return nil
+}
+
+// RevokeOtherSessions 撤销当前用户除当前会话外的所有会话，返回撤销的会话数
+func (h *RedisJWTHandler) RevokeOtherSessions(ctx *gin.Context) (int, error) {
+	uc, err := h.GetUserClaims(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("RevokeOtherSessions failed: %w", err)
+	}
+	members, err := h.client.ZRange(ctx, fmt.Sprintf(userSessionsKey, uc.UserId), 0, -1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("RevokeOtherSessions failed: %w", err)
 	}
+	others := slices.DeleteFunc(members, func(ssid string) bool {
+		return ssid == uc.Ssid
+	})
+	if err = h.revokeSessions(ctx, uc.UserId, others...); err != nil {
+		return 0, fmt.Errorf("RevokeOtherSessions failed: %w", err)
+	}
+	return len(others), nil
+}
+
+// sessionExpiration 会话超过该时间未活跃时访问令牌和刷新令牌都已过期
+func (h *RedisJWTHandler) sessionExpiration() time.Duration {
+	return max(h.jwtExpiration, h.refreshExpiration)
+}
+
+// Refresh 校验请求携带的刷新令牌，轮换刷新令牌并签发新的访问令牌，访问令牌中的角色由 loadRole 重新获取；
+// 已轮换的刷新令牌在宽限期 (refreshReuseGrace) 外被再次使用时撤销整个会话并返回 ErrRefreshTokenReused
+func (h *RedisJWTHandler) Refresh(ctx *gin.Context, loadRole RoleLoader) error {
+	var rc RefreshClaims
+	token, err := jwt.ParseWithClaims(h.extractRefreshToken(ctx), &rc, func(t *jwt.Token) (any, error) {
+		return h.refreshKey, nil
+	}, jwt.WithValidMethods([]string{h.signingMethod.Alg()}))
+	if err != nil || token == nil || !token.Valid {
+		return fmt.Errorf("Refresh failed: %w", ErrRefreshTokenInvalid)
+	}
+	// 在轮换前获取角色，获取失败时刷新令牌仍可使用
+	role, err := loadRole(ctx, rc.UserId)
+	if err != nil {
+		return fmt.Errorf("Refresh failed: load role of user %d failed: %w", rc.UserId, err)
+	}
+
+	// 会话被挤下线或撤销后不能再刷新
+	gen, err := rotateRefreshScript.Run(ctx, h.client,
+		[]string{fmt.Sprintf(refreshTokenKey, rc.Ssid), fmt.Sprintf(userSessionsKey, rc.UserId), fmt.Sprintf(ssidKey, rc.Ssid)},
+		rc.UserId, rc.Generation, h.refreshExpiration.Milliseconds(), rc.Ssid, time.Now().UnixMilli(), h.sessionExpiration().Milliseconds(),
+		refreshReuseGrace.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return fmt.Errorf("Refresh failed: %w", err)
+	}
+	switch gen {
+	case -1:
+		return fmt.Errorf("Refresh failed: %w", ErrRefreshTokenInvalid)
+	case -2:
+		return fmt.Errorf("Refresh failed: user %d ssid %s: %w", rc.UserId, rc.Ssid, ErrRefreshTokenReused)
+	}
+
+	if err = h.setAccessToken(ctx, rc.UserId, rc.Ssid, role); err != nil {
+		return fmt.Errorf("Refresh failed: %w", err)
+	}
+	if err = h.setRefreshToken(ctx, rc.UserId, rc.Ssid, gen); err != nil {
+		return fmt.Errorf("Refresh failed: %w", err)
+	}
+	return nil
+}
+
+// extractRefreshToken 优先从 X-Refresh-Token 请求头提取刷新令牌，其次从 Cookie 中提取
+func (h *RedisJWTHandler) extractRefreshToken(ctx *gin.Context) string {
+	if token := ctx.GetHeader(constants.HeaderRefreshTokenKey); token != "" {
+		return token
+	}
+	token, _ := ctx.Cookie(constants.HeaderRefreshTokenKey)
+	return token
+}
+
+func (h *RedisJWTHandler) setRefreshToken(ctx *gin.Context, UserId uint64, ssid string, gen int64) error {
+	rc := RefreshClaims{
+		UserId:     UserId,
+		Ssid:       ssid,
+		Generation: gen,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(h.refreshExpiration)),
+		},
+	}
+	tokenStr, err := jwt.NewWithClaims(h.signingMethod, rc).SignedString(h.refreshKey)
+	if err != nil {
+		return err
+	}
+
+	ctx.Header(constants.HeaderRefreshTokenKey, tokenStr)
+	// 刷新令牌的 Cookie 仅发送给 /auth 下的接口
+	ctx.SetCookie(constants.HeaderRefreshTokenKey, tokenStr, int(h.refreshExpiration.Seconds()), refreshCookiePath, "", false, true)
+	return nil
+}
+
+func (h *RedisJWTHandler) setAccessToken(ctx *gin.Context, UserId uint64, ssid string, role int8) error {
 	uc := UserClaims{
-		UserId:       UserId,
-		Ssid:         ssid,
-		UserAgent:    ctx.GetHeader("User-Agent"),
-		TokenVersion: ver,
+		UserId:    UserId,
+		Ssid:      ssid,
+		UserAgent: ctx.GetHeader("User-Agent"),
+		Role:      role,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(h.jwtExpiration)),
 		},
@@ -101,7 +423,7 @@ func (h *RedisJWTHandler) SetJWTToken(ctx *gin.Context, UserId uint64, ssid stri
 	token := jwt.NewWithClaims(h.signingMethod, uc)
 	tokenStr, err := token.SignedString(h.jwtKey)
 	if err != nil {
-		return fmt.Errorf("SetJWTToken failed: %w", err)
+		return err
 	}
 
 	// 设置响应头
@@ -121,23 +443,58 @@ func (h *RedisJWTHandler) SetJWTToken(ctx *gin.Context, UserId uint64, ssid stri
 	return nil
 }
 
-func (h *RedisJWTHandler) JwtKey() []byte {
-	return h.jwtKey
+// SetCompetitionToken 为会话签发比赛令牌，expiresAt 之后失效
+func (h *RedisJWTHandler) SetCompetitionToken(ctx *gin.Context, UserId uint64, ssid string, competitionID uint64, expiresAt time.Time) error {
+	cc := CompetitionClaims{
+		UserId:        UserId,
+		Ssid:          ssid,
+		CompetitionId: competitionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	tokenStr, err := jwt.NewWithClaims(h.signingMethod, cc).SignedString(h.competitionKey)
+	if err != nil {
+		return fmt.Errorf("SetCompetitionToken failed: %w", err)
+	}
+
+	ctx.Header(constants.HeaderCompetitionTokenKey, tokenStr)
+	ctx.SetCookie(constants.HeaderCompetitionTokenKey, tokenStr, int(time.Until(expiresAt).Seconds()), "/", "", false, true)
+	return nil
 }
 
-func (h *RedisJWTHandler) GetUserTokenVersion(ctx *gin.Context, uid uint64) (int64, error) {
-	val, err := h.client.Get(ctx, fmt.Sprintf(userTokenVersionKey, uid)).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return 0, nil
-		}
-		return 0, fmt.Errorf("GetUserTokenVersion failed: %w", err)
+// GetCompetitionClaims 解析请求携带的比赛令牌，比赛令牌必须属于当前登录的会话；
+// 未携带时返回 ErrCompetitionTokenMissing
+func (h *RedisJWTHandler) GetCompetitionClaims(ctx *gin.Context) (*CompetitionClaims, error) {
+	tokenStr := ctx.GetHeader(constants.HeaderCompetitionTokenKey)
+	if tokenStr == "" {
+		tokenStr, _ = ctx.Cookie(constants.HeaderCompetitionTokenKey)
+	}
+	if tokenStr == "" {
+		return nil, fmt.Errorf("GetCompetitionClaims failed: %w", ErrCompetitionTokenMissing)
+	}
+
+	var cc CompetitionClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &cc, func(t *jwt.Token) (any, error) {
+		return h.competitionKey, nil
+	}, jwt.WithValidMethods([]string{h.signingMethod.Alg()}))
+	if err != nil || token == nil || !token.Valid {
+		return nil, fmt.Errorf("GetCompetitionClaims failed: %w", ErrCompetitionTokenInvalid)
 	}
-	ver, err := strconv.ParseInt(val, 10, 64)
+
+	// 会话登出或过期后，比赛令牌随之失效
+	uc, err := h.GetUserClaims(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("GetUserTokenVersion failed: %w", err)
+		return nil, fmt.Errorf("GetCompetitionClaims failed: %w", err)
+	}
+	if cc.UserId != uc.UserId || cc.Ssid != uc.Ssid {
+		return nil, fmt.Errorf("GetCompetitionClaims failed: %w", ErrCompetitionTokenInvalid)
 	}
-	return ver, nil
+	return &cc, nil
+}
+
+func (h *RedisJWTHandler) JwtKey() []byte {
+	return h.jwtKey
 }
 
 func (h *RedisJWTHandler) GetUserClaims(ctx *gin.Context) (*UserClaims, error) {
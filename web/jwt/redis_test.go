@@ -0,0 +1,383 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+)
+
+// newTestHandler 返回连接到 miniredis 的 RedisJWTHandler，脚本由 miniredis 执行
+func newTestHandler(t *testing.T, maxSessions map[int8]int) (*RedisJWTHandler, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisJWTHandler(client, []byte("test key"), time.Minute, time.Hour, maxSessions).(*RedisJWTHandler), mr
+}
+
+func newTestContext(method, path string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(method, path, nil)
+	return ctx, w
+}
+
+type testSession struct {
+	uid             uint64
+	ssid            string
+	access, refresh string
+}
+
+func login(t *testing.T, h *RedisJWTHandler, uid uint64, ssid string, role int8) testSession {
+	t.Helper()
+	ctx, w := newTestContext(http.MethodPost, "/auth/login")
+	if err := h.SetJWTToken(ctx, uid, ssid, role); err != nil {
+		t.Fatalf("SetJWTToken: %v", err)
+	}
+	// 会话按登录时间 (毫秒) 排序，避免两次登录的活跃时间相同
+	time.Sleep(2 * time.Millisecond)
+	return testSession{
+		uid:     uid,
+		ssid:    ssid,
+		access:  w.Header().Get(constants.HeaderLoginTokenKey),
+		refresh: w.Header().Get(constants.HeaderRefreshTokenKey),
+	}
+}
+
+// refresh 使用刷新令牌换取新令牌，返回新的刷新令牌
+func refresh(h *RedisJWTHandler, token string) (string, error) {
+	ctx, w := newTestContext(http.MethodPost, "/auth/refresh")
+	ctx.Request.Header.Set(constants.HeaderRefreshTokenKey, token)
+	err := h.Refresh(ctx, func(ctx context.Context, uid uint64) (int8, error) {
+		return 0, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return w.Header().Get(constants.HeaderRefreshTokenKey), nil
+}
+
+func (s testSession) valid(h *RedisJWTHandler) bool {
+	ctx, _ := newTestContext(http.MethodGet, "/")
+	return h.CheckSession(ctx, s.uid, s.ssid) == nil
+}
+
+// expireReuseGrace 将会话最近一次轮换的时间提前到宽限期之外
+func expireReuseGrace(t *testing.T, mr *miniredis.Miniredis, ssid string) {
+	t.Helper()
+	key := fmt.Sprintf(refreshTokenKey, ssid)
+	rotatedAt, err := strconv.ParseInt(mr.HGet(key, "rotated_at"), 10, 64)
+	if err != nil {
+		t.Fatalf("rotated_at of %s: %v", ssid, err)
+	}
+	mr.HSet(key, "rotated_at", strconv.FormatInt(rotatedAt-refreshReuseGrace.Milliseconds()-1, 10))
+}
+
+func TestRefreshRotation(t *testing.T) {
+	h, mr := newTestHandler(t, nil)
+	s := login(t, h, 1, "s1", 0)
+
+	token := s.refresh
+	for i := 0; i < 3; i++ {
+		next, err := refresh(h, token)
+		if err != nil {
+			t.Fatalf("refresh %d: %v", i, err)
+		}
+		if next == "" || next == token {
+			t.Fatalf("refresh %d: refresh token not rotated", i)
+		}
+		token = next
+	}
+	if gen := mr.HGet(fmt.Sprintf(refreshTokenKey, s.ssid), "gen"); gen != "4" {
+		t.Fatalf("generation = %s after 3 refreshes, want 4", gen)
+	}
+	if !s.valid(h) {
+		t.Fatal("session invalid after refresh")
+	}
+
+	// 访问令牌不能当作刷新令牌使用
+	if _, err := refresh(h, s.access); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("refresh with access token: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	h, mr := newTestHandler(t, map[int8]int{0: 0})
+	s := login(t, h, 1, "s1", 0)
+	other := login(t, h, 1, "s2", 0)
+
+	next, err := refresh(h, s.refresh)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	expireReuseGrace(t, mr, s.ssid)
+	if _, err = refresh(h, s.refresh); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse after grace: err = %v, want ErrRefreshTokenReused", err)
+	}
+	if s.valid(h) {
+		t.Fatal("session still valid after refresh token reuse")
+	}
+	if _, err = refresh(h, next); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("refresh revoked session: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+	// 只撤销被重复使用的会话
+	if !other.valid(h) {
+		t.Fatal("other session revoked")
+	}
+
+	// 宽限期内使用更早的刷新令牌仍视为重复使用
+	s = login(t, h, 1, "s3", 0)
+	token := s.refresh
+	for i := 0; i < 2; i++ {
+		if token, err = refresh(h, token); err != nil {
+			t.Fatalf("refresh %d: %v", i, err)
+		}
+	}
+	if _, err = refresh(h, s.refresh); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reuse of older generation: err = %v, want ErrRefreshTokenReused", err)
+	}
+}
+
+func TestRefreshConcurrentWithinGrace(t *testing.T) {
+	h, mr := newTestHandler(t, nil)
+	s := login(t, h, 1, "s1", 0)
+
+	// 两个页面使用同一个刷新令牌先后刷新，都得到当前序号的刷新令牌
+	first, err := refresh(h, s.refresh)
+	if err != nil {
+		t.Fatalf("first refresh: %v", err)
+	}
+	second, err := refresh(h, s.refresh)
+	if err != nil {
+		t.Fatalf("concurrent refresh within grace: %v", err)
+	}
+	if gen := mr.HGet(fmt.Sprintf(refreshTokenKey, s.ssid), "gen"); gen != "2" {
+		t.Fatalf("generation = %s, want 2: refresh within grace must not rotate", gen)
+	}
+	if !s.valid(h) {
+		t.Fatal("session revoked by concurrent refresh")
+	}
+
+	// 两个页面持有的刷新令牌都能继续使用
+	if _, err = refresh(h, second); err != nil {
+		t.Fatalf("refresh with second token: %v", err)
+	}
+	if _, err = refresh(h, first); err != nil {
+		t.Fatalf("refresh with first token within grace: %v", err)
+	}
+}
+
+func TestRefreshRevokedSession(t *testing.T) {
+	h, mr := newTestHandler(t, map[int8]int{0: 0})
+	s := login(t, h, 1, "s1", 0)
+
+	ctx, _ := newTestContext(http.MethodPost, "/auth/logout")
+	ctx.Set(constants.ContextUserClaimsKey, UserClaims{UserId: s.uid, Ssid: s.ssid})
+	if err := h.ClearToken(ctx); err != nil {
+		t.Fatalf("ClearToken: %v", err)
+	}
+	if s.valid(h) {
+		t.Fatal("session valid after logout")
+	}
+	if _, err := refresh(h, s.refresh); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("refresh after logout: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	// 未配置的角色只保留一个会话，被挤下线的会话不能再刷新
+	evicted := login(t, h, 2, "s2", 1)
+	current := login(t, h, 2, "s3", 1)
+	if evicted.valid(h) || !current.valid(h) {
+		t.Fatalf("valid = %t/%t, want evicted session invalid", evicted.valid(h), current.valid(h))
+	}
+	if _, err := refresh(h, evicted.refresh); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("refresh evicted session: err = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if mr.Exists(fmt.Sprintf(refreshTokenKey, evicted.ssid)) {
+		t.Fatal("refresh record of evicted session not deleted")
+	}
+}
+
+func TestSessionPolicies(t *testing.T) {
+	// 角色 0 未配置，按单会话处理
+	h, mr := newTestHandler(t, map[int8]int{1: 2, 2: 0})
+
+	first := login(t, h, 1, "a1", 0)
+	second := login(t, h, 1, "a2", 0)
+	if first.valid(h) || !second.valid(h) {
+		t.Fatalf("unconfigured role: valid = %t/%t, want only the latest session", first.valid(h), second.valid(h))
+	}
+
+	// 上限为 N 时挤掉最久未活跃的会话，刷新令牌会更新活跃时间
+	b1 := login(t, h, 2, "b1", 1)
+	b2 := login(t, h, 2, "b2", 1)
+	if _, err := refresh(h, b1.refresh); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	b3 := login(t, h, 2, "b3", 1)
+	if !b1.valid(h) || b2.valid(h) || !b3.valid(h) {
+		t.Fatalf("maxSessions 2: valid = %t/%t/%t, want the least recently active session evicted",
+			b1.valid(h), b2.valid(h), b3.valid(h))
+	}
+	if mr.Exists(fmt.Sprintf(ssidKey, b2.ssid)) {
+		t.Fatal("session info of evicted session not deleted")
+	}
+
+	// 上限为 0 时不限制
+	var sessions []testSession
+	for i := 0; i < 5; i++ {
+		sessions = append(sessions, login(t, h, 3, fmt.Sprintf("c%d", i), 2))
+	}
+	for _, s := range sessions {
+		if !s.valid(h) {
+			t.Fatalf("unlimited role: session %s evicted", s.ssid)
+		}
+	}
+}
+
+func TestCheckSessionTouchesLastSeen(t *testing.T) {
+	h, mr := newTestHandler(t, nil)
+	s := login(t, h, 1, "s1", 0)
+	key := fmt.Sprintf(userSessionsKey, s.uid)
+
+	// 距上次更新不足 sessionTouchInterval 时不更新
+	recent := float64(time.Now().Add(-sessionTouchInterval / 2).UnixMilli())
+	mr.ZAdd(key, recent, s.ssid)
+	if !s.valid(h) {
+		t.Fatal("session invalid")
+	}
+	if got, _ := mr.ZScore(key, s.ssid); got != recent {
+		t.Fatalf("last seen updated within touch interval: %.0f -> %.0f", recent, got)
+	}
+
+	stale := float64(time.Now().Add(-2 * sessionTouchInterval).UnixMilli())
+	mr.ZAdd(key, stale, s.ssid)
+	before := float64(time.Now().UnixMilli())
+	if !s.valid(h) {
+		t.Fatal("session invalid")
+	}
+	if got, _ := mr.ZScore(key, s.ssid); got < before {
+		t.Fatalf("last seen = %.0f, want updated to at least %.0f", got, before)
+	}
+}
+
+// asSession 返回以会话 ssid 的身份发起请求的上下文
+func asSession(uid uint64, ssid string) *gin.Context {
+	ctx, _ := newTestContext(http.MethodGet, "/auth/sessions")
+	ctx.Set(constants.ContextUserClaimsKey, UserClaims{UserId: uid, Ssid: ssid})
+	return ctx
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	h, mr := newTestHandler(t, map[int8]int{0: 0})
+	var sessions []testSession
+	for i := 0; i < 3; i++ {
+		ctx, _ := newTestContext(http.MethodPost, "/auth/login")
+		ctx.Request.Header.Set("User-Agent", fmt.Sprintf("agent-%d", i))
+		ctx.Request.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i)
+		ssid := fmt.Sprintf("s%d", i)
+		if err := h.SetJWTToken(ctx, 9, ssid, 0); err != nil {
+			t.Fatalf("SetJWTToken: %v", err)
+		}
+		sessions = append(sessions, testSession{uid: 9, ssid: ssid})
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	list, err := h.ListSessions(asSession(9, "s1"))
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(list) != 3 || list[0].Ssid != "s2" || list[2].Ssid != "s0" {
+		t.Fatalf("sessions = %+v, want s2, s1, s0", list)
+	}
+	if !list[1].Current || list[0].Current || list[2].UserAgent != "agent-0" || list[2].IP != "10.0.0.0" ||
+		list[2].CreatedAt.IsZero() || list[2].LastSeen.IsZero() {
+		t.Fatalf("session fields = %+v", list)
+	}
+
+	// 不能撤销其它用户的会话
+	if err = h.RevokeSession(asSession(10, "x"), "s0"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("revoke other user's session: err = %v, want ErrSessionNotFound", err)
+	}
+	if err = h.RevokeSession(asSession(9, "s1"), "s0"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if sessions[0].valid(h) {
+		t.Fatal("revoked session still valid")
+	}
+	if mr.Exists(fmt.Sprintf(ssidKey, "s0")) {
+		t.Fatal("session info of revoked session not deleted")
+	}
+	if err = h.RevokeSession(asSession(9, "s1"), "s0"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("revoke revoked session: err = %v, want ErrSessionNotFound", err)
+	}
+
+	n, err := h.RevokeOtherSessions(asSession(9, "s1"))
+	if err != nil || n != 1 {
+		t.Fatalf("RevokeOtherSessions = %d, %v, want 1", n, err)
+	}
+	if sessions[2].valid(h) || !sessions[1].valid(h) {
+		t.Fatal("RevokeOtherSessions must revoke all sessions but the current one")
+	}
+	if n, err = h.RevokeOtherSessions(asSession(9, "s1")); err != nil || n != 0 {
+		t.Fatalf("RevokeOtherSessions without other sessions = %d, %v, want 0", n, err)
+	}
+
+	// 撤销当前会话等同于登出
+	if err = h.RevokeSession(asSession(9, "s1"), "s1"); err != nil {
+		t.Fatalf("revoke current session: %v", err)
+	}
+	if sessions[1].valid(h) {
+		t.Fatal("current session still valid after revoking it")
+	}
+}
+
+func TestRefreshReloadsRole(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	s := login(t, h, 1, "s1", 1)
+
+	// 获取角色失败时不轮换，刷新令牌仍可使用
+	ctx, _ := newTestContext(http.MethodPost, "/auth/refresh")
+	ctx.Request.Header.Set(constants.HeaderRefreshTokenKey, s.refresh)
+	errLoad := errors.New("db down")
+	err := h.Refresh(ctx, func(ctx context.Context, uid uint64) (int8, error) {
+		return 0, errLoad
+	})
+	if !errors.Is(err, errLoad) {
+		t.Fatalf("Refresh: err = %v, want the role loader error", err)
+	}
+
+	// 角色降级后刷新得到的访问令牌使用新角色
+	ctx, w := newTestContext(http.MethodPost, "/auth/refresh")
+	ctx.Request.Header.Set(constants.HeaderRefreshTokenKey, s.refresh)
+	err = h.Refresh(ctx, func(ctx context.Context, uid uint64) (int8, error) {
+		if uid != s.uid {
+			t.Errorf("role loaded for user %d, want %d", uid, s.uid)
+		}
+		return 0, nil
+	})
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	var uc UserClaims
+	if _, err = jwt.ParseWithClaims(w.Header().Get(constants.HeaderLoginTokenKey), &uc, func(*jwt.Token) (any, error) {
+		return h.JwtKey(), nil
+	}); err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if uc.Role != 0 || uc.UserId != s.uid || uc.Ssid != s.ssid {
+		t.Fatalf("access token claims = %+v, want role 0", uc)
+	}
+}
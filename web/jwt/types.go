@@ -1,6 +1,9 @@
 package jwt
 
 import (
+	"context"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -8,19 +11,53 @@ import (
 type Handler interface {
 	ClearToken(ctx *gin.Context) error
 	ExtractToken(ctx *gin.Context) string
-	SetLoginToken(ctx *gin.Context, uid uint64) error
-	SetJWTToken(ctx *gin.Context, uid uint64, ssid string) error
-	CheckSession(ctx *gin.Context, ssid string) error
-	GetUserTokenVersion(ctx *gin.Context, uid uint64) (int64, error)
+	SetLoginToken(ctx *gin.Context, uid uint64, role int8) error
+	SetJWTToken(ctx *gin.Context, uid uint64, ssid string, role int8) error
+	Refresh(ctx *gin.Context, loadRole RoleLoader) error
+	SetCompetitionToken(ctx *gin.Context, uid uint64, ssid string, competitionID uint64, expiresAt time.Time) error
+	GetCompetitionClaims(ctx *gin.Context) (*CompetitionClaims, error)
+	CheckSession(ctx *gin.Context, uid uint64, ssid string) error
+	ListSessions(ctx *gin.Context) ([]Session, error)
+	RevokeSession(ctx *gin.Context, ssid string) error
+	RevokeOtherSessions(ctx *gin.Context) (int, error)
 
 	JwtKey() []byte
 	GetUserClaims(ctx *gin.Context) (*UserClaims, error)
 }
 
+// RoleLoader 获取用户当前的角色
+type RoleLoader func(ctx context.Context, uid uint64) (int8, error)
+
 type UserClaims struct {
 	jwt.RegisteredClaims
-	UserId       uint64
-	Ssid         string
-	UserAgent    string
-	TokenVersion int64
+	UserId    uint64
+	Ssid      string
+	UserAgent string
+	Role      int8 // 签发时的用户角色，登录和刷新令牌时从数据库获取
+}
+
+// RefreshClaims 刷新令牌，Generation 为会话内的轮换序号
+type RefreshClaims struct {
+	jwt.RegisteredClaims
+	UserId     uint64
+	Ssid       string
+	Generation int64
+}
+
+// CompetitionClaims 比赛令牌，只在签发时的会话内对一场比赛有效
+type CompetitionClaims struct {
+	jwt.RegisteredClaims
+	UserId        uint64
+	Ssid          string
+	CompetitionId uint64
+}
+
+// Session 用户的一个登录会话
+type Session struct {
+	Ssid      string    `json:"ssid"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"` // 登录时间
+	LastSeen  time.Time `json:"last_seen"`  // 最近活跃时间，精度为 1 分钟
+	Current   bool      `json:"current"`    // 是否为发起请求的会话
 }
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	ojjwt "github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+type CompetitionMiddlewareBuilder struct {
+	handler ojjwt.Handler
+	cmds    map[string]struct{}
+	idParam string
+	log     loggerv2.Logger
+}
+
+// NewCompetitionMiddlewareBuilder cmds 为需要比赛令牌的 cmd，idParam 为请求中比赛 ID 的查询参数名
+func NewCompetitionMiddlewareBuilder(handler ojjwt.Handler, cmds []string, idParam string, log loggerv2.Logger) *CompetitionMiddlewareBuilder {
+	set := make(map[string]struct{}, len(cmds))
+	for _, cmd := range cmds {
+		set[cmd] = struct{}{}
+	}
+	if idParam == "" {
+		idParam = "competition_id"
+	}
+	return &CompetitionMiddlewareBuilder{
+		handler: handler,
+		cmds:    set,
+		idParam: idParam,
+		log:     log,
+	}
+}
+
+// Build 校验请求携带的比赛令牌，并将令牌中的比赛 ID 写入 X-Competition-ID 请求头转发给后端；
+// 客户端自带的 X-Competition-ID 始终被移除。需要比赛令牌的 cmd 未携带有效令牌，
+// 或查询参数中的比赛 ID 与令牌不一致时返回 403；其它 cmd 的比赛 ID 不一致时不写入 X-Competition-ID
+func (m *CompetitionMiddlewareBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Request.Header.Del(constants.HeaderCompetitionIDKey)
+		_, required := m.cmds[requestCmd(ctx)]
+
+		cc, err := m.handler.GetCompetitionClaims(ctx)
+		if err != nil {
+			if !required {
+				// 比赛结束后令牌过期不影响其它请求
+				ctx.Next()
+				return
+			}
+			if errors.Is(err, ojjwt.ErrCompetitionTokenMissing) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "competition token required"})
+				return
+			}
+			m.log.WarnContext(ctx, "CheckCompetition failed", logger.Error(err))
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid competition token"})
+			return
+		}
+
+		competitionID := strconv.FormatUint(cc.CompetitionId, 10)
+		if id := ctx.Query(m.idParam); id != "" && id != competitionID {
+			if !required {
+				// 查看其它比赛的公开信息不需要比赛令牌，也不写入与请求不符的比赛 ID
+				ctx.Next()
+				return
+			}
+			m.log.WarnContext(ctx, "CheckCompetition competition id mismatch",
+				logger.String("token_competition_id", competitionID),
+				logger.String("request_competition_id", id),
+			)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "competition token does not match " + m.idParam})
+			return
+		}
+
+		ctx.Request.Header.Set(constants.HeaderCompetitionIDKey, competitionID)
+		ctx.Next()
+	}
+}
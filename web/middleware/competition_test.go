@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	ojjwt "github.com/to404hanga/online_judge_gateway/web/jwt"
+)
+
+// competitionToken 为会话 s1 签发比赛 42 的比赛令牌
+func competitionToken(t *testing.T, h ojjwt.Handler, expiresAt time.Time) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/auth/competition/login", nil)
+	if err := h.SetCompetitionToken(ctx, 7, "s1", 42, expiresAt); err != nil {
+		t.Fatalf("SetCompetitionToken: %v", err)
+	}
+	return w.Header().Get(constants.HeaderCompetitionTokenKey)
+}
+
+func TestCompetitionMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := ojjwt.NewRedisJWTHandler(nil, []byte("key"), time.Minute, time.Hour, nil)
+	valid := competitionToken(t, h, time.Now().Add(time.Hour))
+	expired := competitionToken(t, h, time.Now().Add(-time.Hour))
+
+	var forwarded string
+	r := gin.New()
+	r.Use(func(ctx *gin.Context) {
+		ctx.Set(constants.ContextUserClaimsKey, ojjwt.UserClaims{UserId: 7, Ssid: "s1"})
+	}, NewCompetitionMiddlewareBuilder(h, []string{"Submit"}, "", newTestLogger(t)).Build())
+	r.POST("/api/judge", func(ctx *gin.Context) {
+		forwarded = ctx.GetHeader(constants.HeaderCompetitionIDKey)
+		ctx.Status(http.StatusOK)
+	})
+
+	cases := []struct {
+		name, query, token string
+		code               int
+		competitionID      string
+	}{
+		{"required", "cmd=Submit", valid, http.StatusOK, "42"},
+		{"required matching id", "cmd=Submit&competition_id=42", valid, http.StatusOK, "42"},
+		{"required mismatched id", "cmd=Submit&competition_id=43", valid, http.StatusForbidden, ""},
+		{"required missing token", "cmd=Submit", "", http.StatusForbidden, ""},
+		{"required expired token", "cmd=Submit", expired, http.StatusForbidden, ""},
+		{"other", "cmd=GetRank", valid, http.StatusOK, "42"},
+		{"other expired token", "cmd=GetRank", expired, http.StatusOK, ""},
+		{"other missing token", "cmd=GetRank", "", http.StatusOK, ""},
+		// 查看其它比赛时不写入令牌中的比赛 ID
+		{"other mismatched id", "cmd=GetRank&competition_id=43", valid, http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		forwarded = ""
+		req := httptest.NewRequest(http.MethodPost, "/api/judge?"+tc.query, nil)
+		req.Header.Set(constants.HeaderCompetitionIDKey, "999")
+		if tc.token != "" {
+			req.Header.Set(constants.HeaderCompetitionTokenKey, tc.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code || forwarded != tc.competitionID {
+			t.Errorf("%s: code = %d, %s = %q, want %d %q",
+				tc.name, w.Code, constants.HeaderCompetitionIDKey, forwarded, tc.code, tc.competitionID)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	ojjwt "github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+const (
+	idempotencyKeyPrefix    = "gateway:idempotency"
+	idempotencyMaxKeyLength = 255
+	idempotencyPollInterval = 50 * time.Millisecond // 重复请求检查原始请求是否完成的间隔
+)
+
+var idempotencyRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "online_judge_gateway",
+		Subsystem: "idempotency",
+		Name:      "requests_total",
+		Help:      "Requests carrying an idempotency key by result.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(idempotencyRequestsTotal)
+}
+
+// IdempotencyOptions 幂等键配置
+type IdempotencyOptions struct {
+	TTL          time.Duration // 响应保留时间
+	PendingTTL   time.Duration // 原始请求处理中标记的保留时间，应大于请求的最长处理时间，网关异常退出后到期释放
+	WaitTimeout  time.Duration // 重复请求等待原始请求完成的最长时间
+	MaxBodyBytes int           // 请求体和保存的响应体上限，请求体超过时不做幂等处理，响应体超过时重复请求返回 409
+}
+
+func (o IdempotencyOptions) withDefaults() IdempotencyOptions {
+	if o.TTL <= 0 {
+		o.TTL = 24 * time.Hour
+	}
+	if o.PendingTTL <= 0 {
+		o.PendingTTL = time.Minute
+	}
+	if o.WaitTimeout <= 0 {
+		o.WaitTimeout = 10 * time.Second
+	}
+	if o.MaxBodyBytes <= 0 {
+		o.MaxBodyBytes = 64 << 10
+	}
+	return o
+}
+
+// idempotencyRecord 保存在 Redis 中的原始请求状态和响应
+type idempotencyRecord struct {
+	Token       string      `json:"token,omitempty"` // 处理中标记的随机值，区分持有者，完成后为空
+	Fingerprint string      `json:"fingerprint"`     // 请求方法、地址和请求体摘要，同一幂等键用于不同请求时拒绝
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	Truncated   bool        `json:"truncated,omitempty"` // 响应体超过上限，未保存
+}
+
+func (r *idempotencyRecord) pending() bool {
+	return r.Token != ""
+}
+
+// 不随重放响应返回的响应头
+var idempotencyDroppedHeaders = []string{
+	"Connection",
+	"Content-Length",
+	"Keep-Alive",
+	"Set-Cookie",
+	"Trailer",
+	"Transfer-Encoding",
+}
+
+type IdempotencyMiddlewareBuilder struct {
+	handler ojjwt.Handler
+	client  redis.Cmdable
+	opts    IdempotencyOptions
+	log     loggerv2.Logger
+}
+
+// NewIdempotencyMiddlewareBuilder 创建幂等键中间件，按用户和 cmd 隔离幂等键
+func NewIdempotencyMiddlewareBuilder(handler ojjwt.Handler, client redis.Cmdable, opts IdempotencyOptions, log loggerv2.Logger) *IdempotencyMiddlewareBuilder {
+	return &IdempotencyMiddlewareBuilder{
+		handler: handler,
+		client:  client,
+		opts:    opts.withDefaults(),
+		log:     log,
+	}
+}
+
+// Build 对携带 Idempotency-Key 的 /api 非 GET 请求生效：首次请求的响应保存在 Redis 中，
+// 重复请求直接重放；原始请求处理中时，重复请求等待其完成。需要在 CheckLogin 之后使用
+func (m *IdempotencyMiddlewareBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(constants.HeaderIdempotencyKey)
+		if key == "" || !strings.HasPrefix(ctx.Request.URL.Path, "/api/") {
+			ctx.Next()
+			return
+		}
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		if len(key) > idempotencyMaxKeyLength || !printableASCII(key) {
+			idempotencyRequestsTotal.WithLabelValues("invalid").Inc()
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "invalid idempotency key",
+			})
+			return
+		}
+
+		var uid uint64
+		if uc, err := m.handler.GetUserClaims(ctx); err == nil {
+			uid = uc.UserId
+		}
+		sum := sha256.Sum256([]byte(key))
+		redisKey := fmt.Sprintf("%s:%d:%s:%s", idempotencyKeyPrefix, uid, requestCmd(ctx), hex.EncodeToString(sum[:]))
+		bodySum, ok := m.hashBody(ctx.Request)
+		if !ok {
+			// 请求体过大或读取失败时不做幂等处理
+			idempotencyRequestsTotal.WithLabelValues("body_too_large").Inc()
+			ctx.Next()
+			return
+		}
+		fingerprint := ctx.Request.Method + " " + ctx.Request.URL.RequestURI() + " " + bodySum
+
+		pending, record, err := m.acquire(ctx, redisKey, fingerprint)
+		if err != nil {
+			// Redis 不可用时不做幂等处理，避免影响正常请求
+			idempotencyRequestsTotal.WithLabelValues("error").Inc()
+			m.log.WarnContext(ctx, "idempotency check failed", logger.Error(err))
+			ctx.Next()
+			return
+		}
+		if record != nil {
+			m.replay(ctx, record, fingerprint)
+			return
+		}
+
+		idempotencyRequestsTotal.WithLabelValues("original").Inc()
+		writer := &capturingWriter{ResponseWriter: ctx.Writer, limit: m.opts.MaxBodyBytes}
+		ctx.Writer = writer
+		// 处理中发生 panic 时也要释放处理中标记
+		completed := false
+		defer func() {
+			if !completed {
+				m.release(redisKey, pending)
+			}
+		}()
+		ctx.Next()
+
+		completed = true
+		status := writer.Status()
+		// 未写入响应 (如客户端断开)、服务端错误和限流不保存，允许客户端使用同一幂等键重试
+		if !writer.responded() || status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+			m.release(redisKey, pending)
+			return
+		}
+		header := writer.Header().Clone()
+		for _, h := range idempotencyDroppedHeaders {
+			header.Del(h)
+		}
+		m.complete(ctx, redisKey, pending, &idempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      header,
+			Body:        writer.body,
+			Truncated:   writer.truncated,
+		})
+	}
+}
+
+// hashBody 读取上限以内的请求体并返回其摘要，请求体被替换为已读取的内容以便继续转发；
+// 请求体超过上限或读取失败时返回 false，已读取的部分仍然保留在请求体中
+func (m *IdempotencyMiddlewareBuilder) hashBody(r *http.Request) (string, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:]), true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(m.opts.MaxBodyBytes)+1))
+	if err != nil || len(body) > m.opts.MaxBodyBytes {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return "", false
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// acquire 尝试成为原始请求并返回写入的处理中标记；幂等键已被使用时等待原始请求完成并返回其记录
+func (m *IdempotencyMiddlewareBuilder) acquire(ctx *gin.Context, key, fingerprint string) (string, *idempotencyRecord, error) {
+	pending, err := json.Marshal(idempotencyRecord{Token: randomToken(), Fingerprint: fingerprint})
+	if err != nil {
+		return "", nil, err
+	}
+
+	deadline := time.Now().Add(m.opts.WaitTimeout)
+	for {
+		ok, err := m.client.SetNX(ctx, key, pending, m.opts.PendingTTL).Result()
+		if err != nil {
+			return "", nil, err
+		}
+		if ok {
+			return string(pending), nil, nil
+		}
+
+		val, err := m.client.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// 原始请求失败后释放了幂等键，重新尝试
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		var record idempotencyRecord
+		if err = json.Unmarshal(val, &record); err != nil {
+			return "", nil, err
+		}
+		if !record.pending() || record.Fingerprint != fingerprint || time.Now().After(deadline) {
+			return "", &record, nil
+		}
+
+		select {
+		case <-time.After(idempotencyPollInterval):
+		case <-ctx.Request.Context().Done():
+			return "", &record, nil
+		}
+	}
+}
+
+// replay 向重复请求返回原始请求的响应
+func (m *IdempotencyMiddlewareBuilder) replay(ctx *gin.Context, record *idempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		idempotencyRequestsTotal.WithLabelValues("mismatch").Inc()
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "idempotency key already used for a different request",
+		})
+	case record.pending():
+		idempotencyRequestsTotal.WithLabelValues("in_progress").Inc()
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "request with the same idempotency key is in progress",
+		})
+	case record.Truncated:
+		idempotencyRequestsTotal.WithLabelValues("truncated").Inc()
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "request already processed, response too large to replay",
+		})
+	default:
+		idempotencyRequestsTotal.WithLabelValues("replayed").Inc()
+		header := ctx.Writer.Header()
+		for k, v := range record.Header {
+			header[k] = v
+		}
+		header.Set(constants.HeaderIdempotentReplayedKey, "true")
+		ctx.Status(record.Status)
+		ctx.Writer.Write(record.Body)
+		ctx.Abort()
+	}
+}
+
+// idempotencyCompleteScript 处理中标记仍由本请求持有时保存响应，避免覆盖标记到期后其它请求的结果
+// KEYS[1]: 幂等键; ARGV[1]: 处理中标记; ARGV[2]: 响应记录; ARGV[3]: 保留时间 (毫秒)
+var idempotencyCompleteScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// idempotencyReleaseScript 处理中标记仍由本请求持有时删除
+// KEYS[1]: 幂等键; ARGV[1]: 处理中标记
+var idempotencyReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// complete 保存原始请求的响应，使用独立的 context 以免客户端断开后处理中标记残留到到期
+func (m *IdempotencyMiddlewareBuilder) complete(ctx *gin.Context, key, pending string, record *idempotencyRecord) {
+	val, err := json.Marshal(record)
+	if err != nil {
+		m.log.ErrorContext(ctx, "marshal idempotency record failed", logger.Error(err))
+		m.release(key, pending)
+		return
+	}
+	err = idempotencyCompleteScript.Run(context.Background(), m.client, []string{key},
+		pending, val, m.opts.TTL.Milliseconds()).Err()
+	if err != nil {
+		m.log.WarnContext(ctx, "save idempotency record failed", logger.Error(err))
+	}
+}
+
+// release 删除处理中标记，允许客户端使用同一幂等键重试
+func (m *IdempotencyMiddlewareBuilder) release(key, pending string) {
+	idempotencyReleaseScript.Run(context.Background(), m.client, []string{key}, pending)
+}
+
+// capturingWriter 在写入客户端的同时保存上限以内的响应体
+type capturingWriter struct {
+	gin.ResponseWriter
+	limit       int
+	body        []byte
+	truncated   bool
+	wroteHeader bool
+}
+
+func (w *capturingWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// responded 返回是否写入了响应，无响应体的响应 (如 204) 只调用 WriteHeader
+func (w *capturingWriter) responded() bool {
+	return w.wroteHeader || w.Written()
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *capturingWriter) capture(b []byte) {
+	if w.truncated {
+		return
+	}
+	if len(w.body)+len(b) > w.limit {
+		w.body, w.truncated = nil, true
+		return
+	}
+	w.body = append(w.body, b...)
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
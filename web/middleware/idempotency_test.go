@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	ojjwt "github.com/to404hanga/online_judge_gateway/web/jwt"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+// idempotencyRedis 在内存中实现幂等键用到的命令和脚本，不处理过期时间
+type idempotencyRedis struct {
+	redis.Cmdable
+	mu   sync.Mutex
+	vals map[string]string
+}
+
+func (r *idempotencyRedis) SetNX(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmd := redis.NewBoolCmd(ctx)
+	if _, ok := r.vals[key]; ok {
+		cmd.SetVal(false)
+		return cmd
+	}
+	r.vals[key] = string(value.([]byte))
+	cmd.SetVal(true)
+	return cmd
+}
+
+func (r *idempotencyRedis) Get(ctx context.Context, key string) *redis.StringCmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmd := redis.NewStringCmd(ctx)
+	if val, ok := r.vals[key]; ok {
+		cmd.SetVal(val)
+	} else {
+		cmd.SetErr(redis.Nil)
+	}
+	return cmd
+}
+
+type noScriptError struct{}
+
+func (noScriptError) Error() string { return "NOSCRIPT No matching script" }
+func (noScriptError) RedisError()   {}
+
+func (r *idempotencyRedis) EvalSha(ctx context.Context, sha1 string, keys []string, args ...any) *redis.Cmd {
+	cmd := redis.NewCmd(ctx)
+	cmd.SetErr(noScriptError{})
+	return cmd
+}
+
+// Eval 按脚本内容区分保存响应和删除处理中标记
+func (r *idempotencyRedis) Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmd := redis.NewCmd(ctx)
+	if r.vals[keys[0]] != args[0].(string) {
+		cmd.SetVal(int64(0))
+		return cmd
+	}
+	if strings.Contains(script, "'SET'") {
+		r.vals[keys[0]] = string(args[1].([]byte))
+	} else {
+		delete(r.vals, keys[0])
+	}
+	cmd.SetVal(int64(1))
+	return cmd
+}
+
+func newTestLogger(t *testing.T) loggerv2.Logger {
+	t.Helper()
+	l, err := loggerv2.NewZapContextLoggerWithConfig(loggerv2.LoggerConfig{Development: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// newIdempotencyEngine 返回使用幂等键中间件的 /api/judge 接口，handler 决定每次转发的响应
+func newIdempotencyEngine(t *testing.T, handler gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	client := &idempotencyRedis{vals: make(map[string]string)}
+	jwtHandler := ojjwt.NewRedisJWTHandler(nil, []byte("key"), time.Minute, time.Hour, nil)
+	m := NewIdempotencyMiddlewareBuilder(jwtHandler, client, IdempotencyOptions{
+		WaitTimeout:  2 * time.Second,
+		MaxBodyBytes: 16,
+	}, newTestLogger(t))
+
+	r := gin.New()
+	r.Use(func(ctx *gin.Context) {
+		ctx.Set(constants.ContextUserClaimsKey, ojjwt.UserClaims{UserId: 1})
+	}, m.Build())
+	r.POST("/api/judge", handler)
+	return r
+}
+
+func doIdempotent(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/judge?cmd=Submit", strings.NewReader(body))
+	req.Header.Set(constants.HeaderIdempotencyKey, key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	var calls atomic.Int32
+	r := newIdempotencyEngine(t, func(ctx *gin.Context) {
+		n := calls.Add(1)
+		ctx.Header("Set-Cookie", "session=1")
+		ctx.Header("X-Submission", "1")
+		ctx.String(http.StatusCreated, "created %d", n)
+	})
+
+	first := doIdempotent(r, "k1", `{"code":"a"}`)
+	second := doIdempotent(r, "k1", `{"code":"a"}`)
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times, want 1", calls.Load())
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() ||
+		second.Header().Get("X-Submission") != "1" || second.Header().Get(constants.HeaderIdempotentReplayedKey) != "true" {
+		t.Fatalf("replayed response = %d %q %v", second.Code, second.Body, second.Header())
+	}
+	if second.Header().Get("Set-Cookie") != "" {
+		t.Fatal("Set-Cookie replayed")
+	}
+
+	// 同一幂等键用于不同的请求体
+	if w := doIdempotent(r, "k1", `{"code":"b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("different body status = %d, want 422", w.Code)
+	}
+
+	// 请求体超过上限时不做幂等处理，转发的请求体保持完整
+	var got string
+	r = newIdempotencyEngine(t, func(ctx *gin.Context) {
+		body, _ := ctx.GetRawData()
+		got = string(body)
+		ctx.Status(http.StatusNoContent)
+	})
+	large := strings.Repeat("x", 32)
+	doIdempotent(r, "k2", large)
+	if w := doIdempotent(r, "k2", large); w.Header().Get(constants.HeaderIdempotentReplayedKey) != "" || got != large {
+		t.Fatalf("large body replayed or truncated: %q", got)
+	}
+}
+
+func TestIdempotencyWaitForOriginal(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	r := newIdempotencyEngine(t, func(ctx *gin.Context) {
+		calls.Add(1)
+		close(started)
+		<-finish
+		ctx.String(http.StatusOK, "done")
+	})
+
+	original := make(chan *httptest.ResponseRecorder)
+	go func() {
+		original <- doIdempotent(r, "k1", "")
+	}()
+	<-started
+	duplicate := make(chan *httptest.ResponseRecorder)
+	go func() {
+		duplicate <- doIdempotent(r, "k1", "")
+	}()
+
+	// 重复请求等待原始请求完成后重放其响应
+	select {
+	case w := <-duplicate:
+		t.Fatalf("duplicate returned %d while original in progress", w.Code)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(finish)
+	<-original
+	w := <-duplicate
+	if w.Code != http.StatusOK || w.Body.String() != "done" || w.Header().Get(constants.HeaderIdempotentReplayedKey) != "true" {
+		t.Fatalf("duplicate response = %d %q", w.Code, w.Body)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times, want 1", calls.Load())
+	}
+}
+
+func TestIdempotencyNotStored(t *testing.T) {
+	for _, status := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusTooManyRequests} {
+		var calls atomic.Int32
+		r := newIdempotencyEngine(t, func(ctx *gin.Context) {
+			if calls.Add(1) == 1 {
+				ctx.Status(status)
+				return
+			}
+			ctx.String(http.StatusOK, "retried")
+		})
+
+		doIdempotent(r, "k1", "")
+		// 失败的响应不保存，客户端可以使用同一幂等键重试
+		if w := doIdempotent(r, "k1", ""); w.Code != http.StatusOK || w.Body.String() != "retried" {
+			t.Fatalf("status %d: retry response = %d %q", status, w.Code, w.Body)
+		}
+		if calls.Load() != 2 {
+			t.Fatalf("status %d: handler called %d times, want 2", status, calls.Load())
+		}
+	}
+}
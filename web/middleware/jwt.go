@@ -9,6 +9,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	ojmodel "github.com/to404hanga/online_judge_common/model"
 	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/grpcx"
 	ojjwt "github.com/to404hanga/online_judge_gateway/web/jwt"
 	"github.com/to404hanga/pkg404/cachex/lru"
 	"github.com/to404hanga/pkg404/logger"
@@ -42,6 +43,16 @@ func NewJWTMiddlewareBuilder(handler ojjwt.Handler, db *gorm.DB, cache *lru.Cach
 	}
 }
 
+// abortUnauthorized gRPC 请求以 UNAUTHENTICATED 状态拒绝，其它请求返回 401
+func abortUnauthorized(ctx *gin.Context, isGRPC bool) {
+	if isGRPC {
+		grpcx.WriteStatus(ctx.Writer, grpcx.Unauthenticated, "unauthenticated")
+		ctx.Abort()
+		return
+	}
+	ctx.AbortWithStatus(http.StatusUnauthorized)
+}
+
 // CheckLogin 检查登录状态
 func (m *JWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
@@ -54,8 +65,17 @@ func (m *JWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
 			}
 		}
 
+		isGRPC := grpcx.IsGRPCRequest(ctx.Request)
+		var tokenStr string
+		if isGRPC {
+			// gRPC 客户端通过 authorization 元数据携带令牌；ExtractToken 缺少令牌时会直接写出 HTTP 401
+			tokenStr, _ = strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		} else {
+			tokenStr = m.ExtractToken(ctx)
+		}
+
 		var uc ojjwt.UserClaims
-		token, err := jwt.ParseWithClaims(m.ExtractToken(ctx), &uc, func(t *jwt.Token) (any, error) {
+		token, err := jwt.ParseWithClaims(tokenStr, &uc, func(t *jwt.Token) (any, error) {
 			return m.JwtKey(), nil
 		})
 		if err != nil || token == nil || !token.Valid {
@@ -63,28 +83,18 @@ func (m *JWTMiddlewareBuilder) CheckLogin() gin.HandlerFunc {
 				logger.Error(err),
 				logger.Bool("token==nil", token == nil),
 			)
-			ctx.AbortWithStatus(http.StatusUnauthorized)
+			abortUnauthorized(ctx, isGRPC)
 			return
 		}
 
-		if err = m.CheckSession(ctx, uc.Ssid); err != nil {
+		if err = m.CheckSession(ctx, uc.UserId, uc.Ssid); err != nil {
 			m.log.ErrorContext(ctx, "CheckLogin failed", logger.Error(err))
-			ctx.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-
-		ver, err := m.GetUserTokenVersion(ctx, uc.UserId)
-		if err != nil {
-			m.log.ErrorContext(ctx, "CheckLogin failed", logger.Error(err))
-			ctx.AbortWithStatus(http.StatusUnauthorized)
-			return
-		}
-		if uc.TokenVersion != ver {
-			ctx.AbortWithStatus(http.StatusUnauthorized)
+			abortUnauthorized(ctx, isGRPC)
 			return
 		}
 
 		ctx.Set(constants.ContextUserClaimsKey, uc)
+		ctx.Set(constants.ContextUserRoleKey, uc.Role)
 		ctx.Next()
 	}
 }
@@ -94,9 +104,13 @@ func (m *JWTMiddlewareBuilder) CheckAdmin() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		path := ctx.Request.URL.Path
 		method := ctx.Request.Method
-		shouldCheck := false
-		cmd := ctx.Query(constants.ProxyKey)
+		// 管理接口不依赖 adminCheckPairs 配置，避免漏配导致越权
+		shouldCheck := strings.HasPrefix(path, constants.AdminPathPrefix)
+		cmd := requestCmd(ctx)
 		for _, p := range m.adminCheckPairs {
+			if shouldCheck {
+				break
+			}
 			if path == p.Path && method == p.Method {
 				shouldCheck = true
 				break
@@ -122,46 +136,71 @@ func (m *JWTMiddlewareBuilder) CheckAdmin() gin.HandlerFunc {
 				})
 				return
 			}
-			cacheKey := fmt.Sprintf(constants.CacheUserKey, uc.UserId)
-			val, ok := m.cache.Get(cacheKey)
-			if ok {
-				user, ok := val.(constants.CacheUser)
-				if ok {
-					if user.Role != int8(ojmodel.UserRoleAdmin) {
-						m.log.ErrorContext(ctx, "CheckAdmin failed", logger.Int8("actual_role", user.Role))
-						ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-							"error": "权限不足",
-						})
-						return
-					} else {
-						ctx.Next()
-						return
-					}
-				}
-				m.log.ErrorContext(ctx, "CheckAdmin assert failed", logger.Any("value", val))
-			}
-			var user ojmodel.User
-			if err = m.db.WithContext(ctx).Where("id = ?", uc.UserId).Select("username", "realname", "role").First(&user).Error; err != nil {
+			role, err := m.userRole(ctx, uc.UserId)
+			if err != nil {
 				m.log.ErrorContext(ctx, "CheckAdmin get db failed", logger.Error(err))
 				ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
 					"error": err.Error(),
 				})
 				return
 			}
-			m.cache.Add(cacheKey, constants.CacheUser{
-				Username: user.Username,
-				Realname: user.Realname,
-				Role:     user.Role.Int8(),
-			})
-			if *user.Role != ojmodel.UserRoleAdmin {
-				m.log.ErrorContext(ctx, "CheckAdmin failed", logger.Int8("actual_role", user.Role.Int8()))
+			if role != int8(ojmodel.UserRoleAdmin) {
+				m.log.ErrorContext(ctx, "CheckAdmin failed", logger.Int8("actual_role", role))
 				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
 					"error": "权限不足",
 				})
 				return
 			}
+			ctx.Set(constants.ContextUserRoleKey, role)
 		}
 
 		ctx.Next()
 	}
 }
+
+// userRole 从本地缓存或数据库中获取用户当前的角色，不使用令牌中的角色
+func (m *JWTMiddlewareBuilder) userRole(ctx *gin.Context, uid uint64) (int8, error) {
+	cacheKey := fmt.Sprintf(constants.CacheUserKey, uid)
+	if val, ok := m.cache.Get(cacheKey); ok {
+		if user, ok := val.(constants.CacheUser); ok {
+			return user.Role, nil
+		}
+		m.log.ErrorContext(ctx, "userRole assert failed", logger.Any("value", val))
+	}
+	var user ojmodel.User
+	if err := m.db.WithContext(ctx).Where("id = ?", uid).Select("username", "realname", "role").First(&user).Error; err != nil {
+		return 0, err
+	}
+	m.cache.Add(cacheKey, constants.CacheUser{
+		Username: user.Username,
+		Realname: user.Realname,
+		Role:     user.Role.Int8(),
+	})
+	return user.Role.Int8(), nil
+}
+
+// UserRole 返回 CheckLogin 从访问令牌中解析出的用户角色，用于灰度发布、优先级、缓存维度等；
+// 管理员鉴权由 CheckAdmin 以数据库中的角色为准
+func UserRole(ctx *gin.Context) (int8, bool) {
+	val, ok := ctx.Get(constants.ContextUserRoleKey)
+	if !ok {
+		return 0, false
+	}
+	role, ok := val.(int8)
+	return role, ok
+}
+
+// IsAdmin 返回当前用户是否为管理员，角色未知时视为非管理员
+func IsAdmin(ctx *gin.Context) bool {
+	role, ok := UserRole(ctx)
+	return ok && role == int8(ojmodel.UserRoleAdmin)
+}
+
+// requestCmd 返回请求的 cmd，代理路由解析出的 cmd 优先，path 路由的 cmd 来自路由配置而非查询参数
+func requestCmd(ctx *gin.Context) string {
+	if resolved, ok := ctx.Get(constants.ContextProxyCmdKey); ok {
+		cmd, _ := resolved.(string)
+		return cmd
+	}
+	return ctx.Query(constants.ProxyKey)
+}
@@ -13,6 +13,16 @@ func Logger(log loggerv2.Logger) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		query := ctx.Request.URL.Query().Encode()
 		path := ctx.Request.URL.Path
+		if kind := StreamKind(ctx.Request); kind != "" {
+			// 流式请求的请求体是长连接的一部分，不能读取缓存
+			log.InfoContext(ctx.Request.Context(), "stream request info",
+				logger.String("path", path),
+				logger.String("kind", kind),
+				logger.String("query_parameters", query),
+			)
+			ctx.Next()
+			return
+		}
 		if reqBodyBytes, err := io.ReadAll(ctx.Request.Body); err != nil {
 			log.WarnContext(ctx.Request.Context(), "read request body failed",
 				logger.Error(err),
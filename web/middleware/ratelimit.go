@@ -0,0 +1,333 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	ojjwt "github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+const (
+	RateLimitByUser = "user" // 按用户 ID 计数，未登录时按客户端 IP
+	RateLimitByIP   = "ip"   // 按客户端 IP 计数
+)
+
+const (
+	rateLimitKeyPrefix     = "gateway:ratelimit"
+	rateLimitRedisTimeout  = 50 * time.Millisecond // 单次 Redis 限流请求超时，超时后使用本地限流
+	rateLimitRedisCooldown = 5 * time.Second       // Redis 出错后直接使用本地限流的时间，避免每个请求都等待超时
+	rateLimitSweepInterval = time.Minute           // 本地令牌桶清理间隔
+)
+
+var (
+	rateLimitRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "rate_limit",
+			Name:      "rejected_total",
+			Help:      "Requests rejected by rate limit rules.",
+		},
+		[]string{"rule"},
+	)
+	rateLimitFallbackTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "rate_limit",
+			Name:      "fallback_total",
+			Help:      "Rate limit checks served by the local limiter because Redis was unavailable.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitRejectedTotal, rateLimitFallbackTotal)
+}
+
+var rateLimitRuleNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type RateLimitRule struct {
+	Name    string   `yaml:"name"`    // 规则名称，用于 Redis 键和监控标签
+	By      string   `yaml:"by"`      // 计数维度: user (默认，未登录时按 IP) 或 ip
+	Path    string   `yaml:"path"`    // 路径前缀，为空表示所有路径
+	Methods []string `yaml:"methods"` // 请求方法，为空表示所有方法
+	Cmds    []string `yaml:"cmds"`    // 仅限制这些 cmd，每个 cmd 单独计数；为空表示不区分 cmd
+	Roles   []int8   `yaml:"roles"`   // 仅限制这些角色的已登录用户，为空表示所有请求 (包括未登录)
+	Limit   int      `yaml:"limit"`   // 每个窗口允许的请求数
+	Window  int      `yaml:"window"`  // 窗口长度（单位: 秒），默认 1
+	Burst   int      `yaml:"burst"`   // 允许的突发请求数 (令牌桶容量)，默认等于 limit
+}
+
+// rateLimitDecision 一次限流检查的结果
+type rateLimitDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration // 被拒绝时距离下一个令牌的时间
+	reset      time.Duration // 令牌桶恢复满额的时间
+}
+
+type RateLimitMiddlewareBuilder struct {
+	handler ojjwt.Handler
+	client  redis.Cmdable
+	rules   []RateLimitRule
+	local   *localLimiter
+	log     loggerv2.Logger
+
+	redisDownUntil atomic.Int64 // Redis 出错后恢复尝试的时间 (UnixNano)
+}
+
+// NewRateLimitMiddlewareBuilder 创建限流中间件，各网关副本通过 Redis 共享令牌桶，Redis 不可用时各副本独立限流
+func NewRateLimitMiddlewareBuilder(handler ojjwt.Handler, client redis.Cmdable, rules []RateLimitRule, log loggerv2.Logger) (*RateLimitMiddlewareBuilder, error) {
+	names := make(map[string]struct{}, len(rules))
+	normalized := make([]RateLimitRule, 0, len(rules))
+	for _, rule := range rules {
+		if !rateLimitRuleNameRegexp.MatchString(rule.Name) {
+			return nil, fmt.Errorf("rate limit rule %q: invalid name", rule.Name)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("rate limit rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		switch rule.By {
+		case "":
+			rule.By = RateLimitByUser
+		case RateLimitByUser, RateLimitByIP:
+		default:
+			return nil, fmt.Errorf("rate limit rule %q: unknown by %q", rule.Name, rule.By)
+		}
+		if rule.Limit <= 0 {
+			return nil, fmt.Errorf("rate limit rule %q: limit must be positive", rule.Name)
+		}
+		if rule.Window <= 0 {
+			rule.Window = 1
+		}
+		if rule.Burst <= 0 {
+			rule.Burst = rule.Limit
+		}
+		for i, method := range rule.Methods {
+			rule.Methods[i] = strings.ToUpper(method)
+		}
+		normalized = append(normalized, rule)
+	}
+	return &RateLimitMiddlewareBuilder{
+		handler: handler,
+		client:  client,
+		rules:   normalized,
+		local:   newLocalLimiter(),
+		log:     log,
+	}, nil
+}
+
+// Build 需要在 CheckLogin 之后使用，以便按用户和角色限流
+func (m *RateLimitMiddlewareBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if len(m.rules) == 0 {
+			ctx.Next()
+			return
+		}
+
+		cmd := requestCmd(ctx)
+		var uid uint64
+		if uc, err := m.handler.GetUserClaims(ctx); err == nil {
+			uid = uc.UserId
+		}
+		role, hasRole := UserRole(ctx)
+
+		// 多条规则同时命中时全部需要通过，响应头返回剩余请求数最少的规则
+		var header *rateLimitDecision
+		for i := range m.rules {
+			rule := &m.rules[i]
+			if !rule.match(ctx.Request, cmd, role, hasRole) {
+				continue
+			}
+			d := m.allow(ctx, rule, rule.key(ctx, cmd, uid))
+			if !d.allowed {
+				rateLimitRejectedTotal.WithLabelValues(rule.Name).Inc()
+				setRateLimitHeaders(ctx, d)
+				ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(d.retryAfter.Seconds()))))
+				ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"error": "请求过于频繁，请稍后重试",
+				})
+				return
+			}
+			if header == nil || d.remaining < header.remaining {
+				header = &d
+			}
+		}
+		if header != nil {
+			setRateLimitHeaders(ctx, *header)
+		}
+		ctx.Next()
+	}
+}
+
+func setRateLimitHeaders(ctx *gin.Context, d rateLimitDecision) {
+	ctx.Header("X-RateLimit-Limit", strconv.Itoa(d.limit))
+	ctx.Header("X-RateLimit-Remaining", strconv.Itoa(d.remaining))
+	ctx.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(d.reset.Seconds()))))
+}
+
+func (r *RateLimitRule) match(req *http.Request, cmd string, role int8, hasRole bool) bool {
+	if r.Path != "" && !strings.HasPrefix(req.URL.Path, r.Path) {
+		return false
+	}
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, req.Method) {
+		return false
+	}
+	if len(r.Cmds) > 0 && !slices.Contains(r.Cmds, cmd) {
+		return false
+	}
+	if len(r.Roles) > 0 && (!hasRole || !slices.Contains(r.Roles, role)) {
+		return false
+	}
+	return true
+}
+
+func (r *RateLimitRule) key(ctx *gin.Context, cmd string, uid uint64) string {
+	subject := "ip:" + ctx.ClientIP()
+	if r.By == RateLimitByUser && uid != 0 {
+		subject = "user:" + strconv.FormatUint(uid, 10)
+	}
+	if len(r.Cmds) > 0 {
+		return fmt.Sprintf("%s:%s:%s:%s", rateLimitKeyPrefix, r.Name, cmd, subject)
+	}
+	return fmt.Sprintf("%s:%s:%s", rateLimitKeyPrefix, r.Name, subject)
+}
+
+// rate 每毫秒恢复的令牌数
+func (r *RateLimitRule) rate() float64 {
+	return float64(r.Limit) / float64(r.Window*1000)
+}
+
+// allow 优先使用 Redis 令牌桶，Redis 不可用时使用本地令牌桶
+func (m *RateLimitMiddlewareBuilder) allow(ctx *gin.Context, rule *RateLimitRule, key string) rateLimitDecision {
+	if time.Now().UnixNano() >= m.redisDownUntil.Load() {
+		d, err := m.allowRedis(ctx, rule, key)
+		if err == nil {
+			return d
+		}
+		m.redisDownUntil.Store(time.Now().Add(rateLimitRedisCooldown).UnixNano())
+		m.log.WarnContext(ctx, "rate limit redis failed, fallback to local limiter",
+			logger.String("rule", rule.Name),
+			logger.Error(err),
+		)
+	}
+	rateLimitFallbackTotal.Inc()
+	return m.local.allow(key, rule.rate(), rule.Burst, time.Now())
+}
+
+// rateLimitScript 令牌桶，使用 Redis 服务器时间以免各副本时钟不一致
+// KEYS[1]: 令牌桶键; ARGV[1]: 每毫秒恢复的令牌数; ARGV[2]: 令牌桶容量
+// 返回 {是否允许, 剩余令牌数, 距离下一个令牌的毫秒数, 恢复满额的毫秒数}
+var rateLimitScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+local reset = math.ceil((burst - tokens) / rate)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+func (m *RateLimitMiddlewareBuilder) allowRedis(ctx context.Context, rule *RateLimitRule, key string) (rateLimitDecision, error) {
+	ctx, cancel := context.WithTimeout(ctx, rateLimitRedisTimeout)
+	defer cancel()
+
+	res, err := rateLimitScript.Run(ctx, m.client, []string{key},
+		strconv.FormatFloat(rule.rate(), 'g', -1, 64), rule.Burst).Int64Slice()
+	if err != nil {
+		return rateLimitDecision{}, err
+	}
+	if len(res) != 4 {
+		return rateLimitDecision{}, fmt.Errorf("unexpected rate limit script result: %v", res)
+	}
+	return rateLimitDecision{
+		allowed:    res[0] == 1,
+		limit:      rule.Burst,
+		remaining:  int(res[1]),
+		retryAfter: time.Duration(res[2]) * time.Millisecond,
+		reset:      time.Duration(res[3]) * time.Millisecond,
+	}, nil
+}
+
+// localLimiter 本地令牌桶，算法与 Redis 脚本相同
+type localLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*localBucket
+	lastSweep time.Time
+}
+
+type localBucket struct {
+	tokens float64
+	ts     time.Time
+	full   time.Time // 令牌桶恢复满额的时间，之后可以清理
+}
+
+func newLocalLimiter() *localLimiter {
+	return &localLimiter{
+		buckets:   make(map[string]*localBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *localLimiter) allow(key string, rate float64, burst int, now time.Time) rateLimitDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		for k, b := range l.buckets {
+			if now.After(b.full) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &localBucket{tokens: float64(burst), ts: now}
+		l.buckets[key] = b
+	}
+	elapsed := float64(now.Sub(b.ts)) / float64(time.Millisecond)
+	b.tokens = math.Min(float64(burst), b.tokens+math.Max(0, elapsed)*rate)
+	b.ts = now
+
+	d := rateLimitDecision{limit: burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = time.Duration(math.Ceil((1-b.tokens)/rate)) * time.Millisecond
+	}
+	d.remaining = int(b.tokens)
+	d.reset = time.Duration(math.Ceil((float64(burst)-b.tokens)/rate)) * time.Millisecond
+	b.full = now.Add(d.reset)
+	return d
+}
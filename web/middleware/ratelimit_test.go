@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalLimiter(t *testing.T) {
+	l := newLocalLimiter()
+	now := time.Now()
+	rate := 2.0 / 1000 // 每秒 2 个
+
+	for i := 0; i < 3; i++ {
+		d := l.allow("k", rate, 3, now)
+		if !d.allowed {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+		if d.remaining != 2-i {
+			t.Fatalf("request %d: remaining = %d, want %d", i, d.remaining, 2-i)
+		}
+	}
+	d := l.allow("k", rate, 3, now)
+	if d.allowed {
+		t.Fatal("request allowed after burst exhausted")
+	}
+	if d.retryAfter != 500*time.Millisecond {
+		t.Fatalf("retryAfter = %v, want 500ms", d.retryAfter)
+	}
+	if d.reset != 1500*time.Millisecond {
+		t.Fatalf("reset = %v, want 1.5s", d.reset)
+	}
+
+	if d = l.allow("other", rate, 3, now); !d.allowed {
+		t.Fatal("other key shares bucket")
+	}
+	if d = l.allow("k", rate, 3, now.Add(500*time.Millisecond)); !d.allowed {
+		t.Fatal("request rejected after refill")
+	}
+
+	// 恢复满额的令牌桶在清理时删除
+	l.allow("k", rate, 3, now.Add(rateLimitSweepInterval+time.Second))
+	if _, ok := l.buckets["other"]; ok {
+		t.Fatal("full bucket not swept")
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	StreamKindWebSocket = "websocket" // WebSocket 升级请求
+	StreamKindSSE       = "sse"       // Server-Sent Events 长连接
+)
+
+// StreamKind 返回流式请求的类型，普通请求返回空字符串
+func StreamKind(r *http.Request) string {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && headerContainsToken(r.Header, "Connection", "upgrade") {
+		return StreamKindWebSocket
+	}
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return StreamKindSSE
+	}
+	return ""
+}
+
+// headerContainsToken 判断逗号分隔的请求头中是否包含 token，忽略大小写
+func headerContainsToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
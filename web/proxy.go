@@ -1,26 +1,52 @@
 package web
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/grpcx"
 	"github.com/to404hanga/online_judge_gateway/web/jwt"
 	"github.com/to404hanga/online_judge_gateway/web/middleware"
+	"github.com/to404hanga/online_judge_gateway/web/respcache"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
 	"github.com/to404hanga/pkg404/logger"
 	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
 )
 
 type ProxyHandler struct {
-	services map[string]string
-	log      loggerv2.Logger
+	services      *upstream.Registry
+	routes        *route.Table
+	transports    ProxyTransports
+	proxies       sync.Map // *upstream.Instance -> *httputil.ReverseProxy
+	streamProxies sync.Map // *upstream.Instance -> *httputil.ReverseProxy
+	grpcProxies   sync.Map // *upstream.Instance -> *httputil.ReverseProxy
+	discovered    sync.Map // 服务名 -> struct{}，实例列表由服务发现维护
+	mirrorSem     chan struct{}
+	priorities    []PriorityRule   // 按顺序匹配，决定并发限制的排队顺序
+	cache         *respcache.Cache // 响应缓存，nil 表示不缓存
+	flights       flightGroup      // 进行中的合并转发
+	log           loggerv2.Logger
+}
+
+// ProxyTransports 转发使用的 transport
+type ProxyTransports struct {
+	HTTP   http.RoundTripper // 普通请求
+	Stream http.RoundTripper // WebSocket/SSE，连接空闲超时与普通请求分开
+	GRPC   http.RoundTripper // gRPC 服务，使用 h2c
+	Mirror http.RoundTripper // 流量镜像的影子请求，与正式请求的连接池分开，nil 时使用 HTTP
 }
 
 var _ Handler = (*ProxyHandler)(nil)
@@ -33,7 +59,16 @@ var (
 			Name:      "requests_total",
 			Help:      "Proxy requests total.",
 		},
-		[]string{"service", "path", "method", "code", "reason"},
+		[]string{"service", "variant", "priority", "path", "method", "code", "reason"},
+	)
+	proxyRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "proxy",
+			Name:      "retries_total",
+			Help:      "Proxy retries total.",
+		},
+		[]string{"service", "path"},
 	)
 	proxyDurationSeconds = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -43,7 +78,26 @@ var (
 			Help:      "Proxy request duration in seconds.",
 			Buckets:   prometheus.DefBuckets,
 		},
-		[]string{"service", "path", "method", "code", "reason"},
+		[]string{"service", "variant", "path", "method", "code", "reason"},
+	)
+	proxyActiveStreams = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "proxy",
+			Name:      "active_streams",
+			Help:      "Active proxied WebSocket/SSE streams.",
+		},
+		[]string{"service", "kind"},
+	)
+	proxyStreamDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "proxy",
+			Name:      "stream_duration_seconds",
+			Help:      "Proxied WebSocket/SSE stream duration in seconds.",
+			Buckets:   []float64{1, 5, 30, 60, 300, 900, 1800, 3600, 7200},
+		},
+		[]string{"service", "kind"},
 	)
 )
 
@@ -51,36 +105,120 @@ func init() {
 	prometheus.MustRegister(
 		proxyRequestsTotal,
 		proxyDurationSeconds,
+		proxyRetriesTotal,
+		proxyActiveStreams,
+		proxyStreamDurationSeconds,
 	)
 }
 
-func NewProxyHandler(log loggerv2.Logger, services map[string]string) *ProxyHandler {
+func NewProxyHandler(log loggerv2.Logger, services *upstream.Registry, routes *route.Table, transports ProxyTransports, priorities []PriorityRule, cache *respcache.Cache) *ProxyHandler {
 	return &ProxyHandler{
-		services: services,
-		log:      log,
+		services:   services,
+		routes:     routes,
+		transports: transports,
+		mirrorSem:  make(chan struct{}, maxInFlightMirrors),
+		priorities: priorities,
+		cache:      cache,
+		log:        log,
 	}
 }
 
 func (h *ProxyHandler) Register(r *gin.Engine) {
 	r.Any("/api/*path", middleware.Logger(h.log), h.ProxyHandler) // 转发路由不使用日志中间件
+
+	// /api 以外的路由和 gRPC 直连请求交由未匹配路由处理
+	if h.GRPCEnabled() {
+		r.NoRoute(h.grpcPassthrough, middleware.Logger(h.log), h.ProxyHandler)
+		return
+	}
+	for _, rt := range h.routes.Routes() {
+		if !strings.HasPrefix(rt.Pattern, "/api/") {
+			r.NoRoute(middleware.Logger(h.log), h.ProxyHandler)
+			break
+		}
+	}
+}
+
+// ResolveRoute 在鉴权之前匹配代理路由，并将解析出的 cmd 写入上下文供权限校验等中间件使用
+func (h *ProxyHandler) ResolveRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m, ok := h.routes.Match(c.Request.Method, c.Request.Host, c.Request.URL.Path, c.Request.URL.Query()); ok {
+			c.Set(constants.ContextRouteMatchKey, m)
+			c.Set(constants.ContextProxyCmdKey, m.Cmd)
+		}
+		c.Next()
+	}
+}
+
+// matchRoute 优先使用 ResolveRoute 的匹配结果
+func (h *ProxyHandler) matchRoute(c *gin.Context) (*route.Match, bool) {
+	if val, ok := c.Get(constants.ContextRouteMatchKey); ok {
+		if m, ok := val.(*route.Match); ok {
+			return m, true
+		}
+	}
+	return h.routes.Match(c.Request.Method, c.Request.Host, c.Request.URL.Path, c.Request.URL.Query())
+}
+
+// proxyState 单次转发的上下文，通过 request context 传递给复用的反向代理
+type proxyState struct {
+	target       string
+	upstreamPath string
+	rawQuery     string
+	userID       uint64
+	stream       string // 流式请求类型，普通请求为空
+	grpc         bool   // gRPC 直连请求，错误以 gRPC 状态返回
+	variant      string // 命中的发布版本
+	priority     upstream.Priority
+	cache        *cacheFill // 未命中响应缓存的请求，nil 表示不写入缓存
+	flight       *flight    // 作为 leader 的合并转发，nil 表示未合并
+
+	reason   string
+	outcome  upstream.Outcome
+	retry    *upstream.RetryPolicy // 非 nil 表示本次尝试失败后还可以重试
+	retrying bool                  // 本次尝试失败且将要重试，响应未写入客户端
+}
+
+const maxRetryBodyBytes = 1 << 20 // 可重试请求缓存请求体的上限
+
+// errRetryableStatus 后端返回了可重试的状态码，用于让反向代理放弃写入本次响应
+var errRetryableStatus = errors.New("retryable upstream status")
+
+type proxyStateKey struct{}
+
+func getProxyState(ctx context.Context) *proxyState {
+	return ctx.Value(proxyStateKey{}).(*proxyState)
 }
 
 func (h *ProxyHandler) ProxyHandler(c *gin.Context) {
-	path := c.Param("path")
-	service := strings.TrimPrefix(path, "/")
+	path := c.Request.URL.Path
+	service := "unknown"
 	pathLabel := "unknown"
 	method := c.Request.Method
 	start := time.Now()
-	reason := "ok"
+	state := &proxyState{
+		reason:   "ok",
+		stream:   middleware.StreamKind(c.Request),
+		variant:  upstream.StableVariant,
+		priority: upstream.PriorityNormal,
+		outcome:  upstream.OutcomeIgnored, // 未转发到实例的请求不影响自适应并发限制
+	}
+	var mirror *mirrorCall
 	defer func() {
+		if mirror != nil {
+			mirror.done(c.Writer.Status(), time.Since(start))
+		}
 		codeLabel := strconv.Itoa(c.Writer.Status())
-		proxyRequestsTotal.WithLabelValues(service, pathLabel, method, codeLabel, reason).Inc()
-		proxyDurationSeconds.WithLabelValues(service, pathLabel, method, codeLabel, reason).Observe(time.Since(start).Seconds())
+		proxyRequestsTotal.WithLabelValues(service, state.variant, state.priority.String(), pathLabel, method, codeLabel, state.reason).Inc()
+		if state.stream == "" {
+			// 流式请求的持续时间单独统计，避免影响普通请求的延迟分布
+			proxyDurationSeconds.WithLabelValues(service, state.variant, pathLabel, method, codeLabel, state.reason).Observe(time.Since(start).Seconds())
+		}
 	}()
 
 	ucAny, exists := c.Get(constants.ContextUserClaimsKey)
 	if !exists {
-		reason = "claims_not_found"
+		state.reason = "claims_not_found"
 		h.log.ErrorContext(c, "user claims not found in context",
 			logger.String("service_path", path),
 		)
@@ -89,91 +227,392 @@ func (h *ProxyHandler) ProxyHandler(c *gin.Context) {
 	}
 	uc, ok := ucAny.(jwt.UserClaims)
 	if !ok {
-		reason = "claims_type_assertion_error"
+		state.reason = "claims_type_assertion_error"
 		h.log.ErrorContext(c, "user claims type assertion error",
 			logger.String("service_path", path),
 		)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "user claims type assertion error"})
 		return
 	}
+	state.userID = uc.UserId
+
+	match, ok := h.matchRoute(c)
+	if !ok {
+		state.reason = "route_not_found"
+		h.log.ErrorContext(c, "route not found",
+			logger.String("service_path", path),
+		)
+		c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
+		return
+	}
+	service = match.Service
 
-	path = strings.TrimPrefix(path, "/")
-	target := "http://" + h.services[path]
-	if len(target) == 0 {
-		reason = "service_not_found"
+	svc, ok := h.services.Get(service)
+	if !ok {
+		service = "unknown"
+		state.reason = "service_not_found"
 		h.log.ErrorContext(c, "service not found",
 			logger.String("service_path", path),
 		)
 		c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
 		return
 	}
-	targetURL, err := url.Parse(target)
-	if err != nil {
-		reason = "parse_target_url_error"
-		h.log.ErrorContext(c, "parse target url error",
+
+	cmd := match.Cmd
+	if match.Route.Kind == route.KindCmd {
+		if len(cmd) == 0 {
+			pathLabel = "missing_cmd"
+			state.reason = "missing_cmd"
+			h.log.ErrorContext(c, "request missing cmd parameter",
+				logger.String("service_path", path),
+			)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing cmd parameter"})
+			return
+		}
+		if !route.ValidCmd(cmd) {
+			pathLabel = "invalid_cmd"
+			state.reason = "invalid_cmd"
+			h.log.ErrorContext(c, "request invalid cmd parameter",
+				logger.String("service_path", path),
+				logger.String("cmd", cmd),
+			)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cmd parameter"})
+			return
+		}
+	}
+	if !svc.AllowCommand(cmd) {
+		pathLabel = "unknown_cmd"
+		state.reason = "unknown_cmd"
+		h.log.ErrorContext(c, "cmd not registered",
 			logger.String("service_path", path),
-			logger.String("target", target),
-			logger.Error(err),
+			logger.String("cmd", cmd),
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "parse target url error"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "cmd not found"})
 		return
 	}
 
-	// 创建反向代理
-	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	switch {
+	case len(cmd) == 0:
+		// 未指定 cmd 的 path 路由以路由模式作为监控标签
+		pathLabel = match.Route.Pattern
+	case match.Route.Kind == route.KindCmd && !svc.HasCommands():
+		// 服务未登记 cmd 白名单时统一使用一个标签，避免监控标签基数无限增长
+		pathLabel = "unregistered"
+	default:
+		pathLabel = cmd
+	}
+	// 按路由重写上游路径，cmd 路由重写为 /<cmd> 并移除 cmd 参数
+	state.upstreamPath, state.rawQuery = match.UpstreamURL(c.Request.URL.Query())
+	// 一致性哈希键，服务未使用一致性哈希时忽略
+	hashKey := match.HashKey(c.Request.URL.Query(), c.Request.Header, state.userID)
+	// 路由和 cmd 级策略沿用服务配置，仅从命中版本的实例中选取
+	target := h.selectVariant(c, svc, state)
 
-	// 自定义请求修改
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
+	var call *grpcCall
+	if opts := svc.GRPC(); opts != nil {
+		// gRPC 服务将 JSON 请求转码为一元调用
+		state.stream = ""
+		if call, ok = h.newGRPCCall(c, opts.Codec, cmd, state); !ok {
+			return
+		}
+	}
+
+	// 命中响应缓存时不转发，也不占用并发名额
+	if call == nil && state.stream == "" && h.lookupCache(c, svc, cmd, state) {
+		return
+	}
+	// 相同的并发读请求合并为一次转发，等待的请求不占用并发名额
+	if call == nil && state.stream == "" {
+		finish, served := h.coalesce(c, svc, cmd, state)
+		if served {
+			return
+		}
+		if finish != nil {
+			defer finish()
+		}
+	}
 
-		query := req.URL.Query()
-		cmd := query.Get(constants.ProxyKey)
-		if len(cmd) != 0 {
-			pathLabel = cmd
-			// 重写请求路径为 cmd 值
-			req.URL.Path = "/" + cmd
+	// 流式请求持续时间不确定，不设置转发超时也不重试，空闲连接由流式 transport 断开
+	if timeout := svc.Timeout(cmd); timeout > 0 && state.stream == "" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+	}
 
-			// 移除 cmd 参数
-			query.Del(constants.ProxyKey)
-			req.URL.RawQuery = query.Encode()
-		} else {
-			pathLabel = "missing_cmd"
-			reason = "missing_cmd"
-			h.log.ErrorContext(req.Context(), "request missing cmd parameter",
+	// 并发限制排队时优先级高的请求先获得名额
+	state.priority = h.priority(c, cmd, match.Route)
+	// 流式请求长期占用连接，不计入并发限制；名额覆盖全部重试
+	if state.stream == "" {
+		permit, ok := h.acquire(c, target, state)
+		if !ok {
+			return
+		}
+		defer func() {
+			permit.Done(state.outcome)
+		}()
+	}
+
+	policy := svc.RetryPolicy(cmd)
+	attempts := 1
+	if state.stream == "" {
+		attempts = policy.Attempts(method)
+	}
+	var body []byte
+	buffered := false
+	if attempts > 1 && call == nil {
+		body, buffered = bufferBody(c.Request, maxRetryBodyBytes)
+		if !buffered {
+			// 请求体过大，不缓存也不重试
+			attempts = 1
+		}
+	}
+	// 流式请求和 gRPC 转码请求不镜像
+	if state.stream == "" && call == nil {
+		mirror = h.startMirror(c, match.Route, state, body, buffered)
+	}
+
+	var tried []*upstream.Instance
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			proxyRetriesTotal.WithLabelValues(service, pathLabel).Inc()
+			select {
+			case <-time.After(policy.Backoff(attempt - 1)):
+			case <-c.Request.Context().Done():
+				if errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+					state.reason = "timeout"
+					c.JSON(http.StatusGatewayTimeout, gin.H{"error": "upstream timeout"})
+					return
+				}
+				state.reason = "client_canceled"
+				return
+			}
+			if body != nil {
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			}
+		}
+
+		lease, err := target.PickKey(hashKey, tried...)
+		if err != nil {
+			state.reason = "no_healthy_instance"
+			if errors.Is(err, upstream.ErrCircuitOpen) {
+				state.reason = "circuit_open"
+			}
+			h.log.ErrorContext(c, "pick instance failed",
 				logger.String("service_path", path),
+				logger.Error(err),
 			)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		state.retry = nil
+		if attempt < attempts {
+			state.retry = policy
 		}
+		if call != nil {
+			h.serveGRPC(c, lease, state, call)
+		} else {
+			h.serve(c, service, lease, state)
+		}
+
+		if !state.retrying {
+			return
+		}
+		state.retrying = false
+		tried = append(tried, lease.Instance)
+	}
+}
+
+// acquire 获取服务的并发名额，失败时向客户端返回错误
+func (h *ProxyHandler) acquire(c *gin.Context, svc *upstream.Service, state *proxyState) (*upstream.Permit, bool) {
+	permit, err := svc.Acquire(c.Request.Context(), state.priority)
+	if err == nil {
+		return permit, true
+	}
+	switch {
+	case errors.Is(err, upstream.ErrConcurrencyLimit):
+		state.reason = "concurrency_limit"
+	case errors.Is(err, upstream.ErrQueueTimeout):
+		state.reason = "queue_timeout"
+	case errors.Is(err, context.DeadlineExceeded):
+		state.reason = "timeout"
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "upstream timeout"})
+		return nil, false
+	default:
+		state.reason = "client_canceled"
+		return nil, false
+	}
+	h.log.WarnContext(c, "service overloaded, request rejected",
+		logger.String("service", svc.Name),
+		logger.String("reason", state.reason),
+		logger.String("priority", state.priority.String()),
+	)
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service overloaded"})
+	return nil, false
+}
+
+// serve 将请求转发到选中的实例，并将结果上报给熔断器
+func (h *ProxyHandler) serve(c *gin.Context, service string, lease *upstream.Lease, state *proxyState) {
+	state.target = lease.Instance.Addr()
+	state.outcome = upstream.OutcomeSuccess
+	defer func() {
+		lease.Done(state.outcome)
+	}()
+
+	h.log.InfoContext(c, "proxying request",
+		logger.String("method", c.Request.Method),
+		logger.String("target", state.target),
+	)
+
+	if state.stream != "" {
+		start := time.Now()
+		proxyActiveStreams.WithLabelValues(service, state.stream).Inc()
+		defer func() {
+			proxyActiveStreams.WithLabelValues(service, state.stream).Dec()
+			proxyStreamDurationSeconds.WithLabelValues(service, state.stream).Observe(time.Since(start).Seconds())
+		}()
+	}
+
+	// 执行代理
+	req := c.Request.WithContext(context.WithValue(c.Request.Context(), proxyStateKey{}, state))
+	h.reverseProxy(lease.Instance, state).ServeHTTP(c.Writer, req)
+}
+
+// bufferBody 缓存请求体以便重试或镜像时重放，请求体超过上限时返回 false，此时请求体保持可完整读取
+func bufferBody(r *http.Request, limit int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(body)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// reverseProxy 返回实例对应的反向代理，首次使用时创建并缓存，流式请求和 gRPC 请求使用单独的反向代理
+func (h *ProxyHandler) reverseProxy(inst *upstream.Instance, state *proxyState) *httputil.ReverseProxy {
+	proxies, transport := &h.proxies, h.transports.HTTP
+	switch {
+	case state.grpc:
+		proxies, transport = &h.grpcProxies, h.transports.GRPC
+	case state.stream != "":
+		proxies, transport = &h.streamProxies, h.transports.Stream
+	}
+	if proxy, ok := proxies.Load(inst); ok {
+		return proxy.(*httputil.ReverseProxy)
+	}
+	proxy := h.newReverseProxy(inst, transport)
+	if state.grpc || state.stream != "" {
+		proxy.FlushInterval = -1 // 每次写入后立即刷新
+	}
+	actual, _ := proxies.LoadOrStore(inst, proxy)
+	return actual.(*httputil.ReverseProxy)
+}
+
+func (h *ProxyHandler) newReverseProxy(inst *upstream.Instance, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := upstream.NewReverseProxy(inst.URL, transport)
+
+	// 自定义请求修改
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		state := getProxyState(req.Context())
+		originalDirector(req)
+
+		req.URL.Path = state.upstreamPath
+		req.URL.RawPath = ""
+		req.URL.RawQuery = state.rawQuery
 
 		req.Header.Set(constants.HeaderForwardedByKey, constants.GatewayServiceName)
 		req.Header.Set(constants.HeaderRequestIDKey, generateRequestID())
-		req.Header.Set(constants.HeaderUserIDKey, strconv.FormatUint(uc.UserId, 10))
+		req.Header.Set(constants.HeaderUserIDKey, strconv.FormatUint(state.userID, 10))
+
+		// 传递剩余处理时间，便于后端提前放弃已经超时的请求
+		req.Header.Del(constants.HeaderTimeoutKey)
+		if deadline, ok := req.Context().Deadline(); ok {
+			remaining := max(time.Until(deadline).Milliseconds(), 1)
+			req.Header.Set(constants.HeaderTimeoutKey, strconv.FormatInt(remaining, 10))
+		}
 	}
 
 	// 响应修改
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		state := getProxyState(resp.Request.Context())
 		resp.Header.Set(constants.HeaderProxyByKey, constants.GatewayServiceName)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			state.outcome = upstream.OutcomeFailure
+		}
+		if state.retry != nil && state.retry.RetryableStatus(resp.StatusCode) {
+			return errRetryableStatus
+		}
+		if state.cache != nil {
+			if err := h.fillCache(resp, state.cache); err != nil {
+				return err
+			}
+		}
+		if state.flight != nil {
+			state.flight.record(resp)
+		}
 		return nil
 	}
 
 	// 错误处理
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		reason = "backend_service_error"
-		h.log.ErrorContext(r.Context(), "proxy error",
-			logger.String("target", target),
+		state := getProxyState(r.Context())
+		if errors.Is(err, errRetryableStatus) {
+			state.retrying = true
+			return
+		}
+		h.handleProxyError(w, r.Context(), state, err)
+	}
+
+	return proxy
+}
+
+// handleProxyError 处理转发失败：可重试时只标记重试，否则向客户端返回错误
+func (h *ProxyHandler) handleProxyError(w http.ResponseWriter, ctx context.Context, state *proxyState, err error) {
+	state.outcome = upstream.OutcomeFailure
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		state.reason = "timeout"
+		h.log.ErrorContext(ctx, "proxy timeout",
+			logger.String("target", state.target),
 			logger.Error(err),
 		)
-		w.WriteHeader(http.StatusBadGateway)
-		w.Write([]byte(`{"error":"backend service error"}`))
+		if state.grpc {
+			grpcx.WriteStatus(w, grpcx.DeadlineExceeded, "upstream timeout")
+			return
+		}
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte(`{"error":"upstream timeout"}`))
+		return
 	}
-
-	h.log.InfoContext(c, "proxying request",
-		logger.String("method", c.Request.Method),
-		logger.String("target", target),
+	if errors.Is(err, context.Canceled) {
+		// 客户端主动断开不计入熔断统计
+		state.outcome = upstream.OutcomeIgnored
+	} else if state.retry != nil && state.retry.RetryableError(err) {
+		h.log.WarnContext(ctx, "proxy error, retrying",
+			logger.String("target", state.target),
+			logger.Error(err),
+		)
+		state.retrying = true
+		return
+	}
+	state.reason = "backend_service_error"
+	h.log.ErrorContext(ctx, "proxy error",
+		logger.String("target", state.target),
+		logger.Error(err),
 	)
-
-	// 执行代理
-	proxy.ServeHTTP(c.Writer, c.Request)
+	if state.grpc {
+		grpcx.WriteStatus(w, grpcx.Unavailable, "backend service error")
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+	w.Write([]byte(`{"error":"backend service error"}`))
 }
 
 // generateRequestID 生成请求ID
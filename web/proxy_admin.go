@@ -0,0 +1,598 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/domain"
+	"github.com/to404hanga/online_judge_gateway/web/discovery"
+	"github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+	"github.com/to404hanga/online_judge_gateway/web/servicestore"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+// 审计记录的操作类型
+const (
+	auditAddService     = "add_service"
+	auditRemoveService  = "remove_service"
+	auditAddInstance    = "add_instance"
+	auditRemoveInstance = "remove_instance"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 1000
+)
+
+var (
+	errServiceNotFound   = errors.New("service not found")
+	errInstanceNotFound  = errors.New("instance not found")
+	errInstanceExists    = errors.New("instance already exists")
+	errServiceDiscovered = errors.New("service instances are managed by discovery")
+
+	serviceNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+)
+
+// ProxyAdminHandler 服务管理接口。变更先写入 Store，再由各网关副本轮询配置版本号同步到路由表
+type ProxyAdminHandler struct {
+	proxy      *ProxyHandler
+	store      servicestore.Store
+	jwtHandler jwt.Handler
+	log        loggerv2.Logger
+
+	mu      sync.Mutex // 串行化配置同步
+	version int64
+	managed map[string]*servicestore.ServiceSpec // 已应用的管理接口新增服务
+}
+
+var _ Handler = (*ProxyAdminHandler)(nil)
+
+func NewProxyAdminHandler(log loggerv2.Logger, proxy *ProxyHandler, store servicestore.Store, jwtHandler jwt.Handler) *ProxyAdminHandler {
+	return &ProxyAdminHandler{
+		proxy:      proxy,
+		store:      store,
+		jwtHandler: jwtHandler,
+		log:        log,
+		managed:    make(map[string]*servicestore.ServiceSpec),
+	}
+}
+
+func (h *ProxyAdminHandler) Register(r *gin.Engine) {
+	admin := r.Group("/admin/proxy")
+	{
+		admin.GET("/services", h.ListServicesHandler)
+		admin.POST("/services", h.AddServicesHandler)
+		admin.DELETE("/services", h.RemoveServiceHandler)
+		admin.GET("/services/:service/instances", h.ListInstancesHandler)
+		admin.POST("/services/:service/instances", h.AddInstancesHandler)
+		admin.DELETE("/services/:service/instance", h.RemoveInstanceHandler)
+		admin.GET("/audits", h.ListAuditsHandler)
+		admin.DELETE("/cache", h.PurgeCacheHandler)
+	}
+}
+
+// Watch 立即同步一次服务配置，之后每隔 interval 检查配置版本号，版本变化时重新同步，ctx 结束后停止
+func (h *ProxyAdminHandler) Watch(ctx context.Context, interval time.Duration) {
+	if err := h.Sync(ctx); err != nil {
+		h.log.WarnContext(ctx, "sync service specs failed", logger.Error(err))
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			ver, err := h.store.Version(ctx)
+			if err != nil {
+				h.log.WarnContext(ctx, "get service spec version failed", logger.Error(err))
+				continue
+			}
+			h.mu.Lock()
+			changed := ver != h.version
+			h.mu.Unlock()
+			if !changed {
+				continue
+			}
+			if err = h.Sync(ctx); err != nil {
+				h.log.WarnContext(ctx, "sync service specs failed", logger.Error(err))
+			}
+		}
+	}()
+}
+
+// Sync 加载全部服务配置并应用到路由表
+func (h *ProxyAdminHandler) Sync(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ver, specs, err := h.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("Sync failed: %w", err)
+	}
+	for name, spec := range specs {
+		h.apply(ctx, name, spec)
+	}
+	// 配置被清除的管理接口新增服务随之移除
+	for name := range h.managed {
+		if _, ok := specs[name]; !ok {
+			h.removeService(ctx, name)
+		}
+	}
+	h.version = ver
+	return nil
+}
+
+func (h *ProxyAdminHandler) apply(ctx context.Context, name string, spec *servicestore.ServiceSpec) {
+	if spec.Deleted {
+		h.removeService(ctx, name)
+		return
+	}
+
+	svc, ok := h.proxy.services.Get(name)
+	if spec.Managed {
+		applied, isManaged := h.managed[name]
+		if ok && (!isManaged || applied.LoadBalancer != spec.LoadBalancer || applied.HealthCheck != spec.HealthCheck) {
+			// 负载均衡和健康检查配置无法原地修改，重新创建服务
+			h.removeService(ctx, name)
+			ok = false
+		}
+		if !ok {
+			svc, err := newManagedService(spec)
+			if err != nil {
+				h.log.ErrorContext(ctx, "invalid service spec",
+					logger.String("service", name),
+					logger.Error(err),
+				)
+				return
+			}
+			if err = h.proxy.services.Add(svc); err != nil {
+				h.log.ErrorContext(ctx, "add service failed",
+					logger.String("service", name),
+					logger.Error(err),
+				)
+				return
+			}
+			h.managed[name] = spec
+			h.log.InfoContext(ctx, "service added",
+				logger.String("service", name),
+				logger.Any("instances", len(spec.Instances)),
+			)
+			return
+		}
+		h.managed[name] = spec
+	} else if !ok {
+		h.log.WarnContext(ctx, "service of instance spec not found", logger.String("service", name))
+		return
+	}
+
+	if _, ok = h.proxy.discovered.Load(name); ok {
+		h.log.WarnContext(ctx, "ignore instance spec of discovered service", logger.String("service", name))
+		return
+	}
+	h.proxy.updateInstances(ctx, svc, toEndpoints(spec.Instances))
+}
+
+func (h *ProxyAdminHandler) removeService(ctx context.Context, name string) {
+	delete(h.managed, name)
+	removed, ok := h.proxy.services.Remove(name)
+	if !ok {
+		return
+	}
+	h.proxy.releaseProxies(removed)
+	h.log.InfoContext(ctx, "service removed", logger.String("service", name))
+}
+
+func newManagedService(spec *servicestore.ServiceSpec) (*upstream.Service, error) {
+	instances := make([]*upstream.Instance, 0, len(spec.Instances))
+	for _, instSpec := range spec.Instances {
+		inst, err := upstream.NewInstance(instSpec.URL, instSpec.Weight)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, inst)
+	}
+	balancer, err := upstream.NewBalancer(spec.LoadBalancer)
+	if err != nil {
+		return nil, err
+	}
+	return upstream.NewService(spec.ServiceName, instances, balancer,
+		upstream.WithHealthCheck(upstream.HealthCheckOptions{Path: spec.HealthCheck}),
+	), nil
+}
+
+func toEndpoints(instances []servicestore.InstanceSpec) []discovery.Endpoint {
+	endpoints := make([]discovery.Endpoint, 0, len(instances))
+	for _, inst := range instances {
+		endpoints = append(endpoints, discovery.Endpoint{URL: inst.URL, Weight: inst.Weight})
+	}
+	return endpoints
+}
+
+// instanceSpecs 返回服务当前的实例配置，用于首次修改配置文件中服务的实例列表
+func instanceSpecs(svc *upstream.Service) []servicestore.InstanceSpec {
+	instances := svc.Instances()
+	specs := make([]servicestore.InstanceSpec, 0, len(instances))
+	for _, inst := range instances {
+		specs = append(specs, servicestore.InstanceSpec{URL: inst.Addr(), Weight: inst.Weight})
+	}
+	return specs
+}
+
+func toServiceConfig(svc *upstream.Service) domain.ServiceConfig {
+	return domain.ServiceConfig{
+		ServiceName:  svc.Name,
+		Instances:    toServiceInstances(svc),
+		HealthCheck:  svc.HealthCheckPath(),
+		LoadBalancer: svc.LoadBalancer(),
+	}
+}
+
+func toServiceInstances(svc *upstream.Service) []domain.ServiceInstance {
+	instances := svc.Instances()
+	result := make([]domain.ServiceInstance, 0, len(instances))
+	for _, inst := range instances {
+		item := domain.ServiceInstance{
+			URL:     inst.Addr(),
+			Weight:  inst.Weight,
+			Healthy: inst.Healthy(),
+		}
+		if lastCheck := inst.LastCheck(); !lastCheck.IsZero() {
+			item.LastCheck = &lastCheck
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// normalizeInstance 校验实例地址并返回规范形式，与路由表中实例的地址一致
+func normalizeInstance(rawURL string, weight int) (servicestore.InstanceSpec, error) {
+	inst, err := upstream.NewInstance(rawURL, weight)
+	if err != nil {
+		return servicestore.InstanceSpec{}, err
+	}
+	if inst.URL.Scheme != "http" && inst.URL.Scheme != "https" {
+		return servicestore.InstanceSpec{}, fmt.Errorf("invalid instance %s: unsupported scheme %s", rawURL, inst.URL.Scheme)
+	}
+	return servicestore.InstanceSpec{URL: inst.Addr(), Weight: inst.Weight}, nil
+}
+
+func (h *ProxyAdminHandler) newAudit(c *gin.Context, action, instance string) servicestore.AuditEntry {
+	entry := servicestore.AuditEntry{
+		Time:      time.Now(),
+		RequestID: c.GetHeader(constants.HeaderRequestIDKey),
+		Action:    action,
+		Instance:  instance,
+	}
+	if uc, err := h.jwtHandler.GetUserClaims(c); err == nil {
+		entry.UserID = uc.UserId
+	}
+	return entry
+}
+
+// update 写入服务配置并立即同步到本副本的路由表，其它副本在下次轮询时同步
+func (h *ProxyAdminHandler) update(c *gin.Context, service string, audit servicestore.AuditEntry, fn servicestore.UpdateFunc) error {
+	if _, err := h.store.Update(c, service, audit, fn); err != nil {
+		return err
+	}
+	if err := h.Sync(c); err != nil {
+		h.log.WarnContext(c, "sync service specs failed", logger.Error(err))
+	}
+	return nil
+}
+
+// updateAll 原子地写入多个服务的配置并立即同步到本副本的路由表
+func (h *ProxyAdminHandler) updateAll(c *gin.Context, services []string, audit servicestore.AuditEntry, fn servicestore.UpdateAllFunc) error {
+	if _, err := h.store.UpdateAll(c, services, audit, fn); err != nil {
+		return err
+	}
+	if err := h.Sync(c); err != nil {
+		h.log.WarnContext(c, "sync service specs failed", logger.Error(err))
+	}
+	return nil
+}
+
+func (h *ProxyAdminHandler) writeUpdateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errServiceNotFound), errors.Is(err, errInstanceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, upstream.ErrServiceExists), errors.Is(err, errInstanceExists),
+		errors.Is(err, errServiceDiscovered), errors.Is(err, servicestore.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.log.ErrorContext(c, "update service spec failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// liveSpec 返回服务当前生效的配置，服务不存在或已删除时返回 errServiceNotFound
+func (h *ProxyAdminHandler) liveSpec(name string, cur *servicestore.ServiceSpec) (*servicestore.ServiceSpec, error) {
+	if cur != nil {
+		if cur.Deleted {
+			return nil, errServiceNotFound
+		}
+		next := *cur
+		next.Instances = append([]servicestore.InstanceSpec(nil), cur.Instances...)
+		return &next, nil
+	}
+	svc, ok := h.proxy.services.Get(name)
+	if !ok {
+		return nil, errServiceNotFound
+	}
+	return &servicestore.ServiceSpec{
+		ServiceName: name,
+		Instances:   instanceSpecs(svc),
+	}, nil
+}
+
+func (h *ProxyAdminHandler) ListServicesHandler(c *gin.Context) {
+	services := h.proxy.services.List()
+	resp := make(map[string]domain.ServiceConfig, len(services))
+	for _, svc := range services {
+		resp[svc.Name] = toServiceConfig(svc)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *ProxyAdminHandler) AddServicesHandler(c *gin.Context) {
+	var req []domain.ServiceConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log.ErrorContext(c, "addServicesHandler bind json failed", logger.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no service"})
+		return
+	}
+
+	// 全部校验通过后在一次原子操作中写入，任一服务已存在时不写入任何服务
+	names := make([]string, 0, len(req))
+	specs := make(map[string]*servicestore.ServiceSpec, len(req))
+	for _, svcCfg := range req {
+		spec, err := toServiceSpec(svcCfg)
+		if err == nil {
+			if _, ok := specs[spec.ServiceName]; ok {
+				err = fmt.Errorf("duplicate service %s", spec.ServiceName)
+			}
+		}
+		if err != nil {
+			h.log.ErrorContext(c, "addServicesHandler invalid service", logger.Error(err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		names = append(names, spec.ServiceName)
+		specs[spec.ServiceName] = spec
+	}
+
+	err := h.updateAll(c, names, h.newAudit(c, auditAddService, ""), func(name string, cur *servicestore.ServiceSpec) (*servicestore.ServiceSpec, error) {
+		if _, err := h.liveSpec(name, cur); err == nil {
+			return nil, fmt.Errorf("add service %s failed: %w", name, upstream.ErrServiceExists)
+		}
+		return specs[name], nil
+	})
+	if err != nil {
+		h.writeUpdateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "service added", "services": names})
+}
+
+func toServiceSpec(svcCfg domain.ServiceConfig) (*servicestore.ServiceSpec, error) {
+	if !serviceNameRegexp.MatchString(svcCfg.ServiceName) {
+		return nil, fmt.Errorf("invalid service name %q", svcCfg.ServiceName)
+	}
+	if !strings.HasPrefix(svcCfg.HealthCheck, "/") {
+		return nil, fmt.Errorf("invalid health check path %q", svcCfg.HealthCheck)
+	}
+	if _, err := upstream.NewBalancer(svcCfg.LoadBalancer); err != nil {
+		return nil, err
+	}
+	spec := &servicestore.ServiceSpec{
+		ServiceName:  svcCfg.ServiceName,
+		Instances:    make([]servicestore.InstanceSpec, 0, len(svcCfg.Instances)),
+		HealthCheck:  svcCfg.HealthCheck,
+		LoadBalancer: svcCfg.LoadBalancer,
+		Managed:      true,
+	}
+	for _, instCfg := range svcCfg.Instances {
+		inst, err := normalizeInstance(instCfg.URL, instCfg.Weight)
+		if err != nil {
+			return nil, err
+		}
+		spec.Instances = append(spec.Instances, inst)
+	}
+	return spec, nil
+}
+
+func (h *ProxyAdminHandler) RemoveServiceHandler(c *gin.Context) {
+	name := c.Query("service")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing service parameter"})
+		return
+	}
+
+	err := h.update(c, name, h.newAudit(c, auditRemoveService, ""), func(cur *servicestore.ServiceSpec) (*servicestore.ServiceSpec, error) {
+		if _, err := h.liveSpec(name, cur); err != nil {
+			return nil, err
+		}
+		return &servicestore.ServiceSpec{ServiceName: name, Deleted: true}, nil
+	})
+	if err != nil {
+		h.writeUpdateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "service removed"})
+}
+
+func (h *ProxyAdminHandler) ListInstancesHandler(c *gin.Context) {
+	svc, ok := h.proxy.services.Get(c.Param("service"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": errServiceNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, toServiceInstances(svc))
+}
+
+func (h *ProxyAdminHandler) AddInstancesHandler(c *gin.Context) {
+	name := c.Param("service")
+	var req []domain.ServiceInstance
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log.ErrorContext(c, "addInstancesHandler bind json failed", logger.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	added := make([]servicestore.InstanceSpec, 0, len(req))
+	urls := make([]string, 0, len(req))
+	for _, instCfg := range req {
+		inst, err := normalizeInstance(instCfg.URL, instCfg.Weight)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		added = append(added, inst)
+		urls = append(urls, inst.URL)
+	}
+	if _, ok := h.proxy.discovered.Load(name); ok {
+		h.writeUpdateError(c, errServiceDiscovered)
+		return
+	}
+
+	err := h.update(c, name, h.newAudit(c, auditAddInstance, strings.Join(urls, ",")), func(cur *servicestore.ServiceSpec) (*servicestore.ServiceSpec, error) {
+		next, err := h.liveSpec(name, cur)
+		if err != nil {
+			return nil, err
+		}
+		for _, inst := range added {
+			for _, exist := range next.Instances {
+				if exist.URL == inst.URL {
+					return nil, fmt.Errorf("%w: %s", errInstanceExists, inst.URL)
+				}
+			}
+			next.Instances = append(next.Instances, inst)
+		}
+		return next, nil
+	})
+	if err != nil {
+		h.writeUpdateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "instance added"})
+}
+
+func (h *ProxyAdminHandler) RemoveInstanceHandler(c *gin.Context) {
+	name := c.Param("service")
+	rawURL := c.Query("instance")
+	if rawURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing instance parameter"})
+		return
+	}
+	target, err := normalizeInstance(rawURL, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := h.proxy.discovered.Load(name); ok {
+		h.writeUpdateError(c, errServiceDiscovered)
+		return
+	}
+
+	err = h.update(c, name, h.newAudit(c, auditRemoveInstance, target.URL), func(cur *servicestore.ServiceSpec) (*servicestore.ServiceSpec, error) {
+		next, err := h.liveSpec(name, cur)
+		if err != nil {
+			return nil, err
+		}
+		for idx, inst := range next.Instances {
+			if inst.URL == target.URL {
+				next.Instances = append(next.Instances[:idx], next.Instances[idx+1:]...)
+				return next, nil
+			}
+		}
+		return nil, errInstanceNotFound
+	})
+	if err != nil {
+		h.writeUpdateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "instance removed"})
+}
+
+// PurgeCacheHandler 清除服务的响应缓存，cmd 为空时清除服务的全部 cmd
+func (h *ProxyAdminHandler) PurgeCacheHandler(c *gin.Context) {
+	if h.proxy.cache == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "response cache disabled"})
+		return
+	}
+	name := c.Query("service")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing service parameter"})
+		return
+	}
+	if _, ok := h.proxy.services.Get(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": errServiceNotFound.Error()})
+		return
+	}
+	cmd := c.Query("cmd")
+	if cmd != "" && !route.ValidCmd(cmd) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cmd parameter"})
+		return
+	}
+
+	if err := h.proxy.cache.Purge(c, name, cmd); err != nil {
+		h.log.ErrorContext(c, "purge response cache failed",
+			logger.String("service", name),
+			logger.String("cmd", cmd),
+			logger.Error(err),
+		)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var userID uint64
+	if uc, err := h.jwtHandler.GetUserClaims(c); err == nil {
+		userID = uc.UserId
+	}
+	h.log.InfoContext(c, "response cache purged",
+		logger.String("service", name),
+		logger.String("cmd", cmd),
+		logger.Any("user_id", userID),
+	)
+	c.JSON(http.StatusOK, gin.H{"message": "cache purged"})
+}
+
+func (h *ProxyAdminHandler) ListAuditsHandler(c *gin.Context) {
+	limit := int64(defaultAuditLimit)
+	if val := c.Query("limit"); val != "" {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit parameter"})
+			return
+		}
+		limit = n
+	}
+
+	entries, err := h.store.Audits(c, limit)
+	if err != nil {
+		h.log.ErrorContext(c, "listAuditsHandler get audits failed", logger.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
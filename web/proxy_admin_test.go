@@ -0,0 +1,231 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+	"github.com/to404hanga/online_judge_gateway/web/servicestore"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+// memStore 内存中的服务配置存储，多个网关副本共享同一个 memStore
+type memStore struct {
+	mu      sync.Mutex
+	version int64
+	specs   map[string]*servicestore.ServiceSpec
+	audits  []servicestore.AuditEntry
+}
+
+func newMemStore() *memStore {
+	return &memStore{specs: make(map[string]*servicestore.ServiceSpec)}
+}
+
+func (s *memStore) Version(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version, nil
+}
+
+func (s *memStore) Load(ctx context.Context) (int64, map[string]*servicestore.ServiceSpec, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	specs := make(map[string]*servicestore.ServiceSpec, len(s.specs))
+	for name, spec := range s.specs {
+		cp := *spec
+		specs[name] = &cp
+	}
+	return s.version, specs, nil
+}
+
+func (s *memStore) Update(ctx context.Context, service string, audit servicestore.AuditEntry, fn servicestore.UpdateFunc) (int64, error) {
+	return s.UpdateAll(ctx, []string{service}, audit, func(_ string, cur *servicestore.ServiceSpec) (*servicestore.ServiceSpec, error) {
+		return fn(cur)
+	})
+}
+
+func (s *memStore) UpdateAll(ctx context.Context, services []string, audit servicestore.AuditEntry, fn servicestore.UpdateAllFunc) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nexts := make([]*servicestore.ServiceSpec, 0, len(services))
+	for _, service := range services {
+		next, err := fn(service, s.specs[service])
+		if err != nil {
+			return 0, err
+		}
+		nexts = append(nexts, next)
+	}
+	for idx, service := range services {
+		audit.Service, audit.Before, audit.After = service, s.specs[service], nexts[idx]
+		s.audits = append([]servicestore.AuditEntry{audit}, s.audits...)
+		s.specs[service] = nexts[idx]
+	}
+	s.version++
+	return s.version, nil
+}
+
+func (s *memStore) Audits(ctx context.Context, n int64) ([]servicestore.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.audits[:min(int(n), len(s.audits))], nil
+}
+
+func newTestLogger(t *testing.T) loggerv2.Logger {
+	t.Helper()
+	l, err := loggerv2.NewZapContextLoggerWithConfig(loggerv2.LoggerConfig{Development: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// newTestProxyHandler 创建只有 controller 服务 (实例 http://10.0.0.1:8081) 和默认路由的 ProxyHandler
+func newTestProxyHandler(t *testing.T) *ProxyHandler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	inst, err := upstream.NewInstance("http://10.0.0.1:8081", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancer, _ := upstream.NewBalancer("")
+	svc := upstream.NewService("controller", []*upstream.Instance{inst}, balancer)
+	routes, err := route.NewTable(route.DefaultRoutes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewProxyHandler(newTestLogger(t), upstream.NewRegistry(nil, svc), routes,
+		ProxyTransports{HTTP: upstream.NewTransport(upstream.TransportOptions{})}, nil, nil)
+}
+
+// newAdminReplica 创建一个网关副本，配置文件中只有 controller 服务
+func newAdminReplica(t *testing.T, store servicestore.Store) (*gin.Engine, *ProxyHandler, *ProxyAdminHandler) {
+	t.Helper()
+	proxy := newTestProxyHandler(t)
+	admin := NewProxyAdminHandler(newTestLogger(t), proxy, store, jwt.NewRedisJWTHandler(nil, []byte("key"), time.Minute, time.Hour, nil))
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(constants.ContextUserClaimsKey, jwt.UserClaims{UserId: 1})
+	})
+	admin.Register(r)
+	return r, proxy, admin
+}
+
+func doAdmin(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func serviceConfigJSON(names ...string) string {
+	items := make([]string, 0, len(names))
+	for _, name := range names {
+		items = append(items, `{"service_name":"`+name+`","instances":[{"url":"http://10.0.1.1:8080","weight":1}],"health_check":"/health","load_balancer":"round_robin"}`)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestAdminAddServicesAtomic(t *testing.T) {
+	store := newMemStore()
+	r, proxy, _ := newAdminReplica(t, store)
+
+	// controller 来自配置文件，整批请求失败，judge 也不会被添加
+	w := doAdmin(r, http.MethodPost, "/admin/proxy/services", serviceConfigJSON("judge", "controller"))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d %s, want 409", w.Code, w.Body)
+	}
+	if _, ok := proxy.services.Get("judge"); ok || store.version != 0 {
+		t.Fatal("failed batch partially added services")
+	}
+
+	for _, body := range []string{"[]", serviceConfigJSON("judge", "judge"), `[{"service_name":"../x","health_check":"/health"}]`} {
+		if w = doAdmin(r, http.MethodPost, "/admin/proxy/services", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status = %d, want 400", body, w.Code)
+		}
+	}
+
+	w = doAdmin(r, http.MethodPost, "/admin/proxy/services", serviceConfigJSON("judge", "problem"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d %s, want 200", w.Code, w.Body)
+	}
+	var resp struct {
+		Services []string `json:"services"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Services) != 2 {
+		t.Fatalf("response = %s, want both services", w.Body)
+	}
+	for _, name := range resp.Services {
+		if _, ok := proxy.services.Get(name); !ok {
+			t.Fatalf("service %s not added to registry", name)
+		}
+	}
+	if store.version != 1 || len(store.audits) != 2 || store.audits[0].UserID != 1 {
+		t.Fatalf("version = %d audits = %+v, want one version and two audits", store.version, store.audits)
+	}
+}
+
+func TestAdminInstancesAndSync(t *testing.T) {
+	store := newMemStore()
+	r, proxy, _ := newAdminReplica(t, store)
+	_, replica, replicaAdmin := newAdminReplica(t, store)
+
+	w := doAdmin(r, http.MethodPost, "/admin/proxy/services/controller/instances", `[{"url":"http://10.0.0.2:8081","weight":2}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("add instance status = %d %s", w.Code, w.Body)
+	}
+	if w = doAdmin(r, http.MethodPost, "/admin/proxy/services/controller/instances", `[{"url":"http://10.0.0.2:8081"}]`); w.Code != http.StatusConflict {
+		t.Fatalf("duplicate instance status = %d, want 409", w.Code)
+	}
+	svc, _ := proxy.services.Get("controller")
+	if n := len(svc.Instances()); n != 2 {
+		t.Fatalf("instances = %d, want 2", n)
+	}
+
+	// 其它副本同步后实例一致
+	if err := replicaAdmin.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	svc, _ = replica.services.Get("controller")
+	if n := len(svc.Instances()); n != 2 {
+		t.Fatalf("replica instances = %d, want 2", n)
+	}
+
+	if w = doAdmin(r, http.MethodDelete, "/admin/proxy/services/controller/instance?instance=http://10.0.0.1:8081", ""); w.Code != http.StatusOK {
+		t.Fatalf("remove instance status = %d %s", w.Code, w.Body)
+	}
+	if w = doAdmin(r, http.MethodDelete, "/admin/proxy/services/controller/instance?instance=http://10.0.0.1:8081", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("remove missing instance status = %d, want 404", w.Code)
+	}
+
+	// 删除配置文件中的服务后不会因重新同步而恢复
+	if w = doAdmin(r, http.MethodDelete, "/admin/proxy/services?service=controller", ""); w.Code != http.StatusOK {
+		t.Fatalf("remove service status = %d %s", w.Code, w.Body)
+	}
+	if err := replicaAdmin.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := replica.services.Get("controller"); ok {
+		t.Fatal("removed service still registered on replica")
+	}
+	if w = doAdmin(r, http.MethodDelete, "/admin/proxy/services?service=controller", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("remove missing service status = %d, want 404", w.Code)
+	}
+
+	w = doAdmin(r, http.MethodGet, "/admin/proxy/audits?limit=2", "")
+	var audits []servicestore.AuditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &audits); err != nil || len(audits) != 2 || audits[0].Action != auditRemoveService {
+		t.Fatalf("audits = %s", w.Body)
+	}
+}
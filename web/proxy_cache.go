@@ -0,0 +1,254 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/middleware"
+	"github.com/to404hanga/online_judge_gateway/web/respcache"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+)
+
+var proxyCacheRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "online_judge_gateway",
+		Subsystem: "proxy",
+		Name:      "cache_requests_total",
+		Help:      "Response cache lookups by result (hit_memory, hit_redis, miss, bypass).",
+	},
+	[]string{"service", "cmd", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(proxyCacheRequestsTotal)
+}
+
+// cacheFill 未命中缓存的请求，响应可以缓存时在转发后写入缓存
+type cacheFill struct {
+	key         string
+	policy      *upstream.CachePolicy
+	ifNoneMatch string // 客户端的 If-None-Match，转发时移除以便后端返回完整响应
+}
+
+// lookupCache 查找 cmd 的响应缓存，命中时直接向客户端返回缓存的响应并返回 true；
+// 未命中的 GET 请求记录到 state.cache，由 fillCache 写入缓存
+func (h *ProxyHandler) lookupCache(c *gin.Context, svc *upstream.Service, cmd string, state *proxyState) bool {
+	policy := svc.CachePolicy(cmd)
+	method := c.Request.Method
+	if h.cache == nil || policy == nil || (method != http.MethodGet && method != http.MethodHead) {
+		return false
+	}
+	variant, ok := requestVariant(c, policy.Scope, policy.VaryHeaders, state)
+	if !ok {
+		proxyCacheRequestsTotal.WithLabelValues(svc.Name, cmd, "bypass").Inc()
+		return false
+	}
+	key := h.cache.Key(svc.Name, cmd, variant)
+	if entry, tier, ok := h.cache.Get(c, key); ok {
+		proxyCacheRequestsTotal.WithLabelValues(svc.Name, cmd, "hit_"+string(tier)).Inc()
+		state.reason = "cache_hit"
+		writeCachedResponse(c, entry)
+		return true
+	}
+	proxyCacheRequestsTotal.WithLabelValues(svc.Name, cmd, "miss").Inc()
+
+	c.Header(constants.HeaderCacheKey, "MISS")
+	if method == http.MethodGet {
+		state.cache = &cacheFill{
+			key:         key,
+			policy:      policy,
+			ifNoneMatch: c.GetHeader("If-None-Match"),
+		}
+		c.Request.Header.Del("If-None-Match")
+		c.Request.Header.Del("If-Modified-Since")
+	}
+	return false
+}
+
+// requestVariant 计算同一 cmd 下区分不同响应的键：身份维度、发布版本、上游路径、
+// 规范化的查询参数和 varyHeaders 中的请求头。无法确定身份维度时返回 false
+func requestVariant(c *gin.Context, scope upstream.CacheScope, varyHeaders []string, state *proxyState) (string, bool) {
+	query, err := url.ParseQuery(state.rawQuery)
+	if err != nil {
+		return "", false
+	}
+
+	hash := sha256.New()
+	switch scope {
+	case upstream.CacheScopeRole:
+		role, ok := middleware.UserRole(c)
+		if !ok {
+			return "", false
+		}
+		fmt.Fprintf(hash, "role:%d", role)
+	case upstream.CacheScopeUser:
+		if state.userID == 0 {
+			return "", false
+		}
+		fmt.Fprintf(hash, "user:%d", state.userID)
+	default:
+		hash.Write([]byte("public"))
+	}
+	// Encode 按参数名排序，参数顺序不同的相同查询命中同一条目
+	fmt.Fprintf(hash, "\n%s\n%s\n%s", state.variant, state.upstreamPath, query.Encode())
+	for _, name := range varyHeaders {
+		fmt.Fprintf(hash, "\n%s=%q", name, c.Request.Header.Values(name))
+	}
+	// 客户端不支持 gzip 时由 transport 解压，压缩和未压缩的响应分开缓存
+	fmt.Fprintf(hash, "\ngzip=%t", strings.Contains(c.GetHeader("Accept-Encoding"), "gzip"))
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// writeCachedResponse 返回缓存的响应，客户端的 If-None-Match 与 ETag 匹配时返回 304
+func writeCachedResponse(c *gin.Context, entry *respcache.Entry) {
+	header := c.Writer.Header()
+	for name, values := range entry.Header {
+		header[name] = slices.Clone(values)
+	}
+	header.Set(constants.HeaderCacheKey, "HIT")
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+
+	if etagMatch(c.GetHeader("If-None-Match"), entry.ETag) {
+		header.Del("Content-Length")
+		c.Writer.WriteHeader(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	c.Writer.WriteHeader(entry.Status)
+	c.Writer.WriteHeaderNow()
+	if c.Request.Method != http.MethodHead {
+		c.Writer.Write(entry.Body)
+	}
+}
+
+// fillCache 将可以缓存的响应写入缓存，后端未返回 ETag 时按响应体生成；
+// 客户端的 If-None-Match 与 ETag 匹配时改为返回 304
+func (h *ProxyHandler) fillCache(resp *http.Response, fill *cacheFill) error {
+	ttl, ok := cacheTTL(resp, fill.policy)
+	if !ok {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, fill.policy.MaxBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > fill.policy.MaxBodyBytes {
+		// 响应体过大，不缓存，已读取的部分与剩余部分一起返回
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		sum := sha256.Sum256(body)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+		resp.Header.Set("ETag", etag)
+	}
+	header := resp.Header.Clone()
+	header.Del("Date")
+	header.Del("Content-Length")
+	header.Del(constants.HeaderCacheKey)
+	now := time.Now()
+	h.cache.Set(resp.Request.Context(), fill.key, &respcache.Entry{
+		Status:  resp.StatusCode,
+		Header:  header,
+		Body:    body,
+		ETag:    etag,
+		Stored:  now,
+		Expires: now.Add(ttl),
+	})
+
+	if etagMatch(fill.ifNoneMatch, etag) {
+		resp.StatusCode = http.StatusNotModified
+		resp.Body = http.NoBody
+		resp.ContentLength = 0
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// cacheTTL 按后端响应头计算缓存时间：仅缓存 200 且不设置 Cookie 的响应，
+// 遵循 Cache-Control 的 no-store、no-cache、private、s-maxage 和 max-age
+func cacheTTL(resp *http.Response, policy *upstream.CachePolicy) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusOK || len(resp.Header.Values("Set-Cookie")) > 0 {
+		return 0, false
+	}
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" || name == "Accept-Encoding" {
+				continue
+			}
+			if !slices.Contains(policy.VaryHeaders, name) {
+				return 0, false
+			}
+		}
+	}
+
+	maxAge, sharedMaxAge := -1, -1
+	for _, value := range resp.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache":
+				return 0, false
+			case "private":
+				// private 的响应只能按用户缓存
+				if policy.Scope != upstream.CacheScopeUser {
+					return 0, false
+				}
+			case "max-age":
+				if seconds, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil {
+					maxAge = seconds
+				}
+			case "s-maxage":
+				if seconds, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil {
+					sharedMaxAge = seconds
+				}
+			}
+		}
+	}
+	if sharedMaxAge >= 0 {
+		maxAge = sharedMaxAge
+	}
+	ttl := policy.TTL
+	if maxAge >= 0 {
+		ttl = min(ttl, time.Duration(maxAge)*time.Second)
+	}
+	return ttl, ttl > 0
+}
+
+// etagMatch 按弱比较判断 If-None-Match 是否匹配 ETag
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
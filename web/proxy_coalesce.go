@@ -0,0 +1,150 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+)
+
+var proxyCoalescedRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "online_judge_gateway",
+		Subsystem: "proxy",
+		Name:      "coalesced_requests_total",
+		Help:      "Coalescing results of identical concurrent reads (leader, shared, fallback).",
+	},
+	[]string{"service", "cmd", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(proxyCoalescedRequestsTotal)
+}
+
+// flight 一次被合并的转发，由第一个请求 (leader) 转发，相同的并发请求等待其响应
+type flight struct {
+	done  chan struct{}
+	limit int64
+
+	// 以下字段由 leader 写入，done 关闭后只读
+	status    int
+	header    http.Header
+	body      []byte
+	truncated bool // 响应体超过上限
+	complete  bool // 完整读取了后端响应体
+}
+
+// shared 返回 leader 的响应能否共享给等待的请求：5xx、304 (取决于 leader 自己的条件请求头)、
+// 设置了 Set-Cookie (属于 leader 自己的会话) 以及未完整读取或超过上限的响应不共享，等待的请求各自转发
+func (f *flight) shared() bool {
+	return f.complete && !f.truncated && f.status < http.StatusInternalServerError && f.status != http.StatusNotModified &&
+		len(f.header.Values("Set-Cookie")) == 0
+}
+
+// record 在转发 leader 的响应时记录状态码、响应头和上限以内的响应体
+func (f *flight) record(resp *http.Response) {
+	f.status = resp.StatusCode
+	f.header = resp.Header.Clone()
+	resp.Body = &flightBody{ReadCloser: resp.Body, f: f}
+}
+
+type flightBody struct {
+	io.ReadCloser
+	f *flight
+}
+
+func (b *flightBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.f.truncated {
+		if int64(len(b.f.body)+n) > b.f.limit {
+			b.f.truncated = true
+			b.f.body = nil
+		} else {
+			b.f.body = append(b.f.body, p[:n]...)
+		}
+	}
+	if err == io.EOF {
+		b.f.complete = true
+	}
+	return n, err
+}
+
+// flightGroup 进行中的合并转发，键相同的请求共享一次转发
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// join 加入键对应的转发，不存在时创建并返回 leader 为 true
+func (g *flightGroup) join(key string, limit int64) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{}), limit: limit}
+	g.flights[key] = f
+	return f, true
+}
+
+// finish 结束转发并唤醒等待的请求，之后到达的请求重新转发
+func (g *flightGroup) finish(key string, f *flight) {
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+}
+
+// coalesce 合并相同的并发 GET 请求。leader 返回 finish，转发结束后必须调用；
+// 等待的请求共享到 leader 的响应时直接返回并且 served 为 true，否则继续各自转发
+func (h *ProxyHandler) coalesce(c *gin.Context, svc *upstream.Service, cmd string, state *proxyState) (finish func(), served bool) {
+	policy := svc.CoalescePolicy(cmd)
+	if policy == nil || c.Request.Method != http.MethodGet {
+		return nil, false
+	}
+	variant, ok := requestVariant(c, policy.Scope, policy.VaryHeaders, state)
+	if !ok {
+		return nil, false
+	}
+	key := svc.Name + ":" + cmd + ":" + variant
+
+	f, leader := h.flights.join(key, policy.MaxBodyBytes)
+	if leader {
+		proxyCoalescedRequestsTotal.WithLabelValues(svc.Name, cmd, "leader").Inc()
+		state.flight = f
+		return func() {
+			h.flights.finish(key, f)
+		}, false
+	}
+
+	select {
+	case <-f.done:
+	case <-c.Request.Context().Done():
+		state.reason = "client_canceled"
+		return nil, true
+	}
+	if !f.shared() {
+		proxyCoalescedRequestsTotal.WithLabelValues(svc.Name, cmd, "fallback").Inc()
+		return nil, false
+	}
+	proxyCoalescedRequestsTotal.WithLabelValues(svc.Name, cmd, "shared").Inc()
+	state.reason = "coalesced"
+
+	header := c.Writer.Header()
+	for name, values := range f.header {
+		header[name] = slices.Clone(values)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(f.body)))
+	c.Writer.WriteHeader(f.status)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Write(f.body)
+	return nil, true
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+)
+
+// newCoalesceGateway 启动只有 judge 服务的网关，GetList 开启请求合并，后端按 respond 返回响应
+func newCoalesceGateway(t *testing.T, respond func(w http.ResponseWriter)) (string, *atomic.Int32) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(200 * time.Millisecond)
+		respond(w)
+	}))
+	t.Cleanup(backend.Close)
+
+	inst, err := upstream.NewInstance(backend.URL, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balancer, _ := upstream.NewBalancer("")
+	svc := upstream.NewService("judge", []*upstream.Instance{inst}, balancer, upstream.WithCommands([]upstream.CommandPolicy{
+		{Name: "GetList", Coalesce: &upstream.CoalescePolicy{Scope: upstream.CacheScopePublic, MaxBodyBytes: 16}},
+	}))
+	routes, err := route.NewTable(route.DefaultRoutes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewProxyHandler(newTestLogger(t), upstream.NewRegistry(nil, svc), routes,
+		ProxyTransports{HTTP: upstream.NewTransport(upstream.TransportOptions{})}, nil, nil)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(constants.ContextUserClaimsKey, jwt.UserClaims{UserId: 1})
+	})
+	h.Register(r)
+	gateway := httptest.NewServer(r)
+	t.Cleanup(gateway.Close)
+	return gateway.URL, &hits
+}
+
+type coalesceResponse struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// concurrentGet 并发发送 n 个相同的请求，第一个请求先到达成为 leader
+func concurrentGet(t *testing.T, url string, n int) []coalesceResponse {
+	t.Helper()
+	var wg sync.WaitGroup
+	out := make([]coalesceResponse, n)
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(url)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			out[i] = coalesceResponse{status: resp.StatusCode, header: resp.Header, body: string(body)}
+		}()
+		if i == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCoalesceShared(t *testing.T) {
+	gateway, hits := newCoalesceGateway(t, func(w http.ResponseWriter) {
+		w.Header().Set("X-Backend", "1")
+		fmt.Fprint(w, "list")
+	})
+
+	for _, resp := range concurrentGet(t, gateway+"/api/judge?cmd=GetList&page=1", 10) {
+		if resp.status != http.StatusOK || resp.body != "list" || resp.header.Get("X-Backend") != "1" {
+			t.Fatalf("response = %d %q %v", resp.status, resp.body, resp.header)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("backend hits = %d, want 1", n)
+	}
+
+	// 查询参数不同的请求不合并
+	hits.Store(0)
+	concurrentGet(t, gateway+"/api/judge?cmd=GetList&page=2", 1)
+	concurrentGet(t, gateway+"/api/judge?cmd=GetList&page=3", 1)
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("backend hits = %d, want 2", n)
+	}
+}
+
+func TestCoalesceNotShared(t *testing.T) {
+	cases := map[string]func(w http.ResponseWriter){
+		"5xx": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+		"set-cookie": func(w http.ResponseWriter) {
+			w.Header().Set("Set-Cookie", "session=leader")
+			fmt.Fprint(w, "list")
+		},
+		"too large": func(w http.ResponseWriter) {
+			fmt.Fprint(w, "a response larger than the limit")
+		},
+	}
+	for name, respond := range cases {
+		gateway, hits := newCoalesceGateway(t, respond)
+		out := concurrentGet(t, gateway+"/api/judge?cmd=GetList", 4)
+		if n := hits.Load(); n != 4 {
+			t.Fatalf("%s: backend hits = %d, want 4", name, n)
+		}
+		if name == "set-cookie" {
+			for _, resp := range out {
+				if cookies := resp.header.Values("Set-Cookie"); len(cookies) != 1 {
+					t.Fatalf("%s: Set-Cookie = %v, want exactly the backend's own", name, cookies)
+				}
+			}
+		}
+	}
+}
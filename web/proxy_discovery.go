@@ -0,0 +1,58 @@
+package web
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/to404hanga/online_judge_gateway/web/discovery"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+	"github.com/to404hanga/pkg404/logger"
+)
+
+// Discover 通过服务发现持续更新服务的实例列表，ctx 结束后停止
+func (h *ProxyHandler) Discover(ctx context.Context, service string, provider discovery.Provider) error {
+	svc, ok := h.services.Get(service)
+	if !ok {
+		return fmt.Errorf("Discover failed: service %s not found", service)
+	}
+	h.discovered.Store(service, struct{}{})
+	provider.Watch(ctx, func(endpoints []discovery.Endpoint) {
+		h.updateInstances(ctx, svc, endpoints)
+	})
+	return nil
+}
+
+// updateInstances 替换服务的实例列表，并释放被移除实例的反向代理；
+// 正在使用被移除实例的请求持有各自的反向代理，会正常完成
+func (h *ProxyHandler) updateInstances(ctx context.Context, svc *upstream.Service, endpoints []discovery.Endpoint) {
+	instances := make([]*upstream.Instance, 0, len(endpoints))
+	for _, ep := range endpoints {
+		inst, err := upstream.NewInstance(ep.URL, ep.Weight)
+		if err != nil {
+			h.log.WarnContext(ctx, "invalid discovered instance",
+				logger.String("service", svc.Name),
+				logger.String("instance", ep.URL),
+				logger.Error(err),
+			)
+			continue
+		}
+		instances = append(instances, inst)
+	}
+
+	removed := svc.SetInstances(instances)
+	h.releaseProxies(removed)
+	h.log.InfoContext(ctx, "service instances updated",
+		logger.String("service", svc.Name),
+		logger.Any("instances", len(instances)),
+		logger.Any("removed", len(removed)),
+	)
+}
+
+// releaseProxies 释放已移除实例的反向代理
+func (h *ProxyHandler) releaseProxies(removed []*upstream.Instance) {
+	for _, inst := range removed {
+		h.proxies.Delete(inst)
+		h.streamProxies.Delete(inst)
+		h.grpcProxies.Delete(inst)
+	}
+}
@@ -0,0 +1,251 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/grpcx"
+	"github.com/to404hanga/online_judge_gateway/web/jwt"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+	"github.com/to404hanga/pkg404/logger"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const maxTranscodeBodyBytes = 4 << 20 // 与 gRPC 默认的最大消息大小一致
+
+// grpcCall 转码后的一元 gRPC 调用，重试时复用
+type grpcCall struct {
+	path    string
+	method  protoreflect.MethodDescriptor
+	payload []byte
+}
+
+// GRPCEnabled 返回是否有服务允许客户端直接使用 gRPC 访问，此时服务端需要开启 h2c
+func (h *ProxyHandler) GRPCEnabled() bool {
+	for _, svc := range h.services.GRPCServices() {
+		if len(svc.GRPC().TrustedNets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// grpcPassthrough gRPC 直连请求按 /<service>/<method> 转发，其余请求交给后续的路由表转发
+func (h *ProxyHandler) grpcPassthrough(c *gin.Context) {
+	if grpcx.IsGRPCRequest(c.Request) {
+		h.GRPCProxyHandler(c)
+		c.Abort()
+	}
+}
+
+// newGRPCCall 将 JSON 请求体和查询参数转码为 cmd 对应的一元 gRPC 调用
+func (h *ProxyHandler) newGRPCCall(c *gin.Context, codec *grpcx.Codec, cmd string, state *proxyState) (*grpcCall, bool) {
+	md, ok := codec.UnaryMethod(cmd)
+	if !ok {
+		state.reason = "unknown_cmd"
+		h.log.ErrorContext(c, "grpc method not found",
+			logger.String("service", codec.ServiceName()),
+			logger.String("cmd", cmd),
+		)
+		c.JSON(http.StatusNotFound, gin.H{"error": "cmd not found"})
+		return nil, false
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxTranscodeBodyBytes+1))
+		if err != nil {
+			state.reason = "read_body_error"
+			h.log.ErrorContext(c, "read request body failed", logger.Error(err))
+			c.JSON(http.StatusBadRequest, gin.H{"error": "read request body failed"})
+			return nil, false
+		}
+		if len(body) > maxTranscodeBodyBytes {
+			state.reason = "body_too_large"
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return nil, false
+		}
+	}
+	query, err := url.ParseQuery(state.rawQuery)
+	if err != nil {
+		state.reason = "invalid_request"
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid query parameters"})
+		return nil, false
+	}
+	payload, err := grpcx.EncodeRequest(md, body, query)
+	if err != nil {
+		state.reason = "invalid_request"
+		h.log.ErrorContext(c, "transcode grpc request failed",
+			logger.String("cmd", cmd),
+			logger.Error(err),
+		)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return &grpcCall{
+		path:    codec.MethodPath(md),
+		method:  md,
+		payload: payload,
+	}, true
+}
+
+// serveGRPC 以一元 gRPC 调用转发请求，并将响应转码为 JSON
+func (h *ProxyHandler) serveGRPC(c *gin.Context, lease *upstream.Lease, state *proxyState, call *grpcCall) {
+	state.target = lease.Instance.Addr()
+	state.outcome = upstream.OutcomeSuccess
+	defer func() {
+		lease.Done(state.outcome)
+	}()
+
+	h.log.InfoContext(c, "proxying grpc request",
+		logger.String("method", call.path),
+		logger.String("target", state.target),
+	)
+
+	ctx := c.Request.Context()
+	target := *lease.Instance.URL
+	target.Path, target.RawPath, target.RawQuery = call.path, "", ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(call.payload))
+	if err != nil {
+		h.handleProxyError(c.Writer, ctx, state, err)
+		return
+	}
+	req.Header.Set("Content-Type", grpcx.ContentType)
+	req.Header.Set("Te", "trailers")
+	// 身份信息以 gRPC metadata 传递
+	req.Header.Set(constants.HeaderForwardedByKey, constants.GatewayServiceName)
+	req.Header.Set(constants.HeaderRequestIDKey, generateRequestID())
+	req.Header.Set(constants.HeaderUserIDKey, strconv.FormatUint(state.userID, 10))
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(grpcx.HeaderTimeout, grpcx.FormatTimeout(time.Until(deadline)))
+	}
+
+	resp, err := h.transports.GRPC.RoundTrip(req)
+	if err != nil {
+		h.handleProxyError(c.Writer, ctx, state, err)
+		return
+	}
+	defer resp.Body.Close()
+	// 读完响应体后才能拿到 trailer 中的 grpc-status
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.handleProxyError(c.Writer, ctx, state, err)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		h.handleProxyError(c.Writer, ctx, state, fmt.Errorf("unexpected grpc http status %d", resp.StatusCode))
+		return
+	}
+	code, msg, ok := grpcx.ParseStatus(resp)
+	if !ok {
+		h.handleProxyError(c.Writer, ctx, state, errors.New("grpc-status not found in response"))
+		return
+	}
+
+	status := grpcx.HTTPStatus(code)
+	if status >= http.StatusInternalServerError {
+		state.outcome = upstream.OutcomeFailure
+	}
+	if state.retry != nil && state.retry.RetryableStatus(status) {
+		state.retrying = true
+		return
+	}
+	c.Header(constants.HeaderProxyByKey, constants.GatewayServiceName)
+	if code != grpcx.OK {
+		c.JSON(status, gin.H{"error": msg, "code": int(code)})
+		return
+	}
+
+	out, err := grpcx.DecodeResponse(call.method, body)
+	if err != nil {
+		state.outcome = upstream.OutcomeFailure
+		state.reason = "backend_service_error"
+		h.log.ErrorContext(c, "transcode grpc response failed",
+			logger.String("target", state.target),
+			logger.Error(err),
+		)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "backend service error"})
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", out)
+}
+
+// GRPCProxyHandler 将受信任客户端的 gRPC (h2c) 请求原样转发到对应的 gRPC 服务
+func (h *ProxyHandler) GRPCProxyHandler(c *gin.Context) {
+	service := "unknown"
+	pathLabel := "unknown"
+	method := c.Request.Method
+	start := time.Now()
+	state := &proxyState{
+		reason:   "ok",
+		grpc:     true,
+		variant:  upstream.StableVariant,
+		priority: upstream.PriorityNormal,
+	}
+	defer func() {
+		codeLabel := strconv.Itoa(c.Writer.Status())
+		proxyRequestsTotal.WithLabelValues(service, state.variant, state.priority.String(), pathLabel, method, codeLabel, state.reason).Inc()
+		proxyDurationSeconds.WithLabelValues(service, state.variant, pathLabel, method, codeLabel, state.reason).Observe(time.Since(start).Seconds())
+	}()
+
+	svcName, methodName, _ := grpcx.SplitMethodPath(c.Request.URL.Path)
+	svc, ok := h.services.GetGRPC(svcName)
+	if !ok {
+		state.reason = "service_not_found"
+		grpcx.WriteStatus(c.Writer, grpcx.Unimplemented, "service not found")
+		return
+	}
+	service = svc.Name
+	opts := svc.GRPC()
+
+	if !opts.Trusted(c.RemoteIP()) {
+		state.reason = "untrusted_client"
+		h.log.WarnContext(c, "grpc client not trusted",
+			logger.String("service", service),
+			logger.String("remote_ip", c.RemoteIP()),
+		)
+		grpcx.WriteStatus(c.Writer, grpcx.PermissionDenied, "grpc access not allowed")
+		return
+	}
+	if !opts.Codec.HasMethod(methodName) {
+		pathLabel = "unknown_cmd"
+		state.reason = "unknown_cmd"
+		grpcx.WriteStatus(c.Writer, grpcx.Unimplemented, "method not found")
+		return
+	}
+	pathLabel = methodName
+
+	// 直连客户端可以不携带用户身份，此时 X-User-ID 为 0
+	if ucAny, exists := c.Get(constants.ContextUserClaimsKey); exists {
+		if uc, ok := ucAny.(jwt.UserClaims); ok {
+			state.userID = uc.UserId
+		}
+	}
+	state.upstreamPath = c.Request.URL.Path
+	state.rawQuery = c.Request.URL.RawQuery
+
+	lease, err := h.selectVariant(c, svc, state).Pick()
+	if err != nil {
+		state.reason = "no_healthy_instance"
+		if errors.Is(err, upstream.ErrCircuitOpen) {
+			state.reason = "circuit_open"
+		}
+		h.log.ErrorContext(c, "pick instance failed",
+			logger.String("service", service),
+			logger.Error(err),
+		)
+		grpcx.WriteStatus(c.Writer, grpcx.Unavailable, err.Error())
+		return
+	}
+	h.serve(c, service, lease, state)
+}
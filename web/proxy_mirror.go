@@ -0,0 +1,193 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+	"github.com/to404hanga/pkg404/logger"
+)
+
+const maxInFlightMirrors = 256 // 同时进行的影子请求上限，影子上游变慢时丢弃新的镜像请求
+
+var (
+	proxyMirrorRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "proxy_mirror",
+			Name:      "requests_total",
+			Help:      "Mirrored shadow requests total.",
+		},
+		[]string{"route", "code", "result"},
+	)
+	proxyMirrorStatusMismatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "proxy_mirror",
+			Name:      "status_mismatches_total",
+			Help:      "Shadow responses whose status differs from the primary response.",
+		},
+		[]string{"route"},
+	)
+	proxyMirrorDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "proxy_mirror",
+			Name:      "duration_seconds",
+			Help:      "Shadow request duration in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"route"},
+	)
+	proxyMirrorLatencyDiffSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "proxy_mirror",
+			Name:      "latency_diff_seconds",
+			Help:      "Shadow request duration minus primary request duration in seconds.",
+			Buckets:   []float64{-5, -1, -0.5, -0.1, -0.05, -0.01, 0, 0.01, 0.05, 0.1, 0.5, 1, 5},
+		},
+		[]string{"route"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		proxyMirrorRequestsTotal,
+		proxyMirrorStatusMismatchesTotal,
+		proxyMirrorDurationSeconds,
+		proxyMirrorLatencyDiffSeconds,
+	)
+}
+
+// 不转发给影子上游的请求头，影子上游通过 X-User-ID 识别用户，不需要登录凭证；
+// 幂等键也不转发，避免影子上游与主上游共享幂等记录
+var mirrorDroppedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	constants.HeaderLoginTokenKey,
+	constants.HeaderRefreshTokenKey,
+	constants.HeaderCompetitionTokenKey,
+	constants.HeaderIdempotencyKey,
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// mirrorCall 一次影子请求，主请求结束后通过 done 上报结果用于计算延迟差
+type mirrorCall struct {
+	primary chan primaryResult
+}
+
+type primaryResult struct {
+	code    int
+	elapsed time.Duration
+}
+
+func (m *mirrorCall) done(code int, elapsed time.Duration) {
+	select {
+	case m.primary <- primaryResult{code: code, elapsed: elapsed}:
+	default:
+	}
+}
+
+// startMirror 按比例将请求复制到路由配置的影子上游，不等待结果；未命中比例或请求体超过上限时返回 nil。
+// body 为已缓存的请求体，nil 时在上限内读取并重置请求体
+func (h *ProxyHandler) startMirror(c *gin.Context, rt *route.Route, state *proxyState, body []byte, buffered bool) *mirrorCall {
+	mirror := rt.Mirror
+	if mirror == nil || rand.Float64()*100 >= mirror.Percent {
+		return nil
+	}
+	label := rt.Name
+	if label == "" {
+		label = rt.Pattern
+	}
+	if !buffered {
+		var complete bool
+		if body, complete = bufferBody(c.Request, mirror.MaxBodyBytes); !complete {
+			proxyMirrorRequestsTotal.WithLabelValues(label, "", "body_too_large").Inc()
+			return nil
+		}
+	} else if int64(len(body)) > mirror.MaxBodyBytes {
+		proxyMirrorRequestsTotal.WithLabelValues(label, "", "body_too_large").Inc()
+		return nil
+	}
+
+	select {
+	case h.mirrorSem <- struct{}{}:
+	default:
+		proxyMirrorRequestsTotal.WithLabelValues(label, "", "dropped").Inc()
+		return nil
+	}
+
+	target := *mirror.Target
+	target.Path = strings.TrimSuffix(target.Path, "/") + state.upstreamPath
+	target.RawPath = ""
+	target.RawQuery = state.rawQuery
+
+	// 影子请求与客户端连接无关，客户端断开后仍然完成
+	ctx, cancel := context.WithTimeout(context.Background(), mirror.Timeout)
+	req, err := http.NewRequestWithContext(ctx, c.Request.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		<-h.mirrorSem
+		h.log.WarnContext(c, "build mirror request failed", logger.Error(err))
+		proxyMirrorRequestsTotal.WithLabelValues(label, "", "error").Inc()
+		return nil
+	}
+	req.Header = c.Request.Header.Clone()
+	for _, key := range mirrorDroppedHeaders {
+		req.Header.Del(key)
+	}
+	req.Header.Set(constants.HeaderForwardedByKey, constants.GatewayServiceName)
+	req.Header.Set(constants.HeaderRequestIDKey, generateRequestID())
+	req.Header.Set(constants.HeaderUserIDKey, strconv.FormatUint(state.userID, 10))
+	req.Header.Set(constants.HeaderShadowKey, "true")
+
+	call := &mirrorCall{primary: make(chan primaryResult, 1)}
+	transport := h.transports.Mirror
+	if transport == nil {
+		transport = h.transports.HTTP
+	}
+	go func() {
+		defer func() {
+			cancel()
+			<-h.mirrorSem
+		}()
+
+		start := time.Now()
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			proxyMirrorRequestsTotal.WithLabelValues(label, "", "error").Inc()
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		elapsed := time.Since(start)
+		proxyMirrorRequestsTotal.WithLabelValues(label, strconv.Itoa(resp.StatusCode), "ok").Inc()
+		proxyMirrorDurationSeconds.WithLabelValues(label).Observe(elapsed.Seconds())
+
+		select {
+		case primary := <-call.primary:
+			proxyMirrorLatencyDiffSeconds.WithLabelValues(label).Observe((elapsed - primary.elapsed).Seconds())
+			if primary.code != resp.StatusCode {
+				proxyMirrorStatusMismatchesTotal.WithLabelValues(label).Inc()
+			}
+		case <-ctx.Done():
+		}
+	}()
+	return call
+}
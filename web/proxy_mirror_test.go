@@ -0,0 +1,185 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+)
+
+type shadowRequest struct {
+	url    string
+	header http.Header
+	body   string
+}
+
+// newShadowServer 返回记录影子请求的影子上游，release 关闭前影子上游不返回响应
+func newShadowServer(t *testing.T, release <-chan struct{}) (*url.URL, <-chan shadowRequest) {
+	t.Helper()
+	requests := make(chan shadowRequest, 1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- shadowRequest{url: r.URL.String(), header: r.Header, body: string(body)}
+		if release != nil {
+			<-release
+		}
+	}))
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL + "/shadow/")
+	return target, requests
+}
+
+func newMirrorContext(body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/judge?cmd=Submit&problem=1", strings.NewReader(body))
+	return c
+}
+
+func mirrorRoute(target *url.URL, percent float64, maxBodyBytes int64) *route.Route {
+	return &route.Route{
+		Name: "submit",
+		Mirror: &route.Mirror{
+			Target:       target,
+			Percent:      percent,
+			MaxBodyBytes: maxBodyBytes,
+			Timeout:      time.Second,
+		},
+	}
+}
+
+func waitShadow(t *testing.T, requests <-chan shadowRequest) shadowRequest {
+	t.Helper()
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for shadow request")
+		return shadowRequest{}
+	}
+}
+
+func TestMirrorRequest(t *testing.T) {
+	h := newTestProxyHandler(t)
+	target, requests := newShadowServer(t, nil)
+	state := &proxyState{upstreamPath: "/Submit", rawQuery: "problem=1", userID: 7}
+
+	credentials := []string{
+		"Authorization",
+		"Cookie",
+		constants.HeaderLoginTokenKey,
+		constants.HeaderRefreshTokenKey,
+		constants.HeaderCompetitionTokenKey,
+		constants.HeaderIdempotencyKey,
+	}
+	c := newMirrorContext("code")
+	for _, key := range credentials {
+		c.Request.Header.Set(key, "secret")
+	}
+	c.Request.Header.Set("X-Trace", "trace")
+	call := h.startMirror(c, mirrorRoute(target, 100, 1024), state, nil, false)
+	if call == nil {
+		t.Fatal("request not mirrored")
+	}
+	call.done(http.StatusOK, time.Millisecond)
+
+	// 正式请求的请求体不受影响
+	if body, _ := io.ReadAll(c.Request.Body); string(body) != "code" {
+		t.Fatalf("primary body = %q, want code", body)
+	}
+	req := waitShadow(t, requests)
+	if req.url != "/shadow/Submit?problem=1" || req.body != "code" {
+		t.Fatalf("shadow request = %s %q", req.url, req.body)
+	}
+	for _, key := range credentials {
+		if val := req.header.Get(key); val != "" {
+			t.Errorf("shadow request carries %s: %s", key, val)
+		}
+	}
+	if req.header.Get("X-Trace") != "trace" || req.header.Get(constants.HeaderUserIDKey) != "7" ||
+		req.header.Get(constants.HeaderShadowKey) != "true" {
+		t.Fatalf("unexpected shadow headers %v", req.header)
+	}
+}
+
+func TestMirrorPercent(t *testing.T) {
+	h := newTestProxyHandler(t)
+	target, _ := newShadowServer(t, nil)
+	h.mirrorSem = make(chan struct{}, 2000)
+	state := &proxyState{upstreamPath: "/Submit"}
+
+	if call := h.startMirror(newMirrorContext(""), mirrorRoute(target, 0, 1024), state, nil, true); call != nil {
+		t.Fatal("request mirrored with percent 0")
+	}
+	mirrored := 0
+	for range 1000 {
+		if h.startMirror(newMirrorContext(""), mirrorRoute(target, 30, 1024), state, nil, true) != nil {
+			mirrored++
+		}
+	}
+	if mirrored < 200 || mirrored > 400 {
+		t.Fatalf("mirrored %d of 1000 requests at 30%%", mirrored)
+	}
+}
+
+func TestMirrorBodyLimit(t *testing.T) {
+	h := newTestProxyHandler(t)
+	target, requests := newShadowServer(t, nil)
+	state := &proxyState{upstreamPath: "/Submit"}
+	rt := mirrorRoute(target, 100, 4)
+
+	c := newMirrorContext("too large")
+	if call := h.startMirror(c, rt, state, nil, false); call != nil {
+		t.Fatal("request with body over limit mirrored")
+	}
+	// 读取过的部分需要还给正式请求
+	if body, _ := io.ReadAll(c.Request.Body); string(body) != "too large" {
+		t.Fatalf("primary body = %q, want full body", body)
+	}
+	if call := h.startMirror(newMirrorContext(""), rt, state, []byte("too large"), true); call != nil {
+		t.Fatal("buffered body over limit mirrored")
+	}
+
+	if call := h.startMirror(newMirrorContext("fits"), rt, state, nil, false); call == nil {
+		t.Fatal("request within limit not mirrored")
+	}
+	if req := waitShadow(t, requests); req.body != "fits" {
+		t.Fatalf("shadow body = %q, want fits", req.body)
+	}
+}
+
+func TestMirrorDropWhenSaturated(t *testing.T) {
+	h := newTestProxyHandler(t)
+	release := make(chan struct{})
+	target, requests := newShadowServer(t, release)
+	h.mirrorSem = make(chan struct{}, 1)
+	rt := mirrorRoute(target, 100, 1024)
+	state := &proxyState{upstreamPath: "/Submit"}
+
+	if call := h.startMirror(newMirrorContext(""), rt, state, nil, true); call == nil {
+		t.Fatal("first request not mirrored")
+	}
+	waitShadow(t, requests)
+	// 影子上游未响应，达到并发上限后丢弃新的镜像请求
+	if call := h.startMirror(newMirrorContext(""), rt, state, nil, true); call != nil {
+		t.Fatal("request mirrored while saturated")
+	}
+
+	close(release)
+	deadline := time.Now().Add(3 * time.Second)
+	for len(h.mirrorSem) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("mirror slot not released")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if call := h.startMirror(newMirrorContext(""), rt, state, nil, true); call == nil {
+		t.Fatal("request not mirrored after slot released")
+	}
+}
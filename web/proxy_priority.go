@@ -0,0 +1,51 @@
+package web
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	ojmodel "github.com/to404hanga/online_judge_common/model"
+	"github.com/to404hanga/online_judge_gateway/web/middleware"
+	"github.com/to404hanga/online_judge_gateway/web/route"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+)
+
+// PriorityRule 请求优先级规则，各条件为空表示不限制，全部条件满足时命中
+type PriorityRule struct {
+	Roles    []int8   // 用户角色，仅匹配已登录用户
+	Cmds     []string // cmd
+	Routes   []string // 路由名称
+	Priority upstream.Priority
+}
+
+// DefaultPriorityRules 未配置优先级规则时使用：管理员的请求为 high，其它请求为 normal
+func DefaultPriorityRules() []PriorityRule {
+	return []PriorityRule{{
+		Roles:    []int8{int8(ojmodel.UserRoleAdmin)},
+		Priority: upstream.PriorityHigh,
+	}}
+}
+
+func (r *PriorityRule) match(role int8, hasRole bool, cmd string, rt *route.Route) bool {
+	if len(r.Roles) > 0 && (!hasRole || !slices.Contains(r.Roles, role)) {
+		return false
+	}
+	if len(r.Cmds) > 0 && !slices.Contains(r.Cmds, cmd) {
+		return false
+	}
+	if len(r.Routes) > 0 && !slices.Contains(r.Routes, rt.Name) {
+		return false
+	}
+	return true
+}
+
+// priority 按顺序匹配优先级规则，未命中时为 normal
+func (h *ProxyHandler) priority(c *gin.Context, cmd string, rt *route.Route) upstream.Priority {
+	role, hasRole := middleware.UserRole(c)
+	for i := range h.priorities {
+		if h.priorities[i].match(role, hasRole, cmd, rt) {
+			return h.priorities[i].Priority
+		}
+	}
+	return upstream.PriorityNormal
+}
@@ -0,0 +1,27 @@
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+	"github.com/to404hanga/online_judge_gateway/web/middleware"
+	"github.com/to404hanga/online_judge_gateway/web/upstream"
+)
+
+// selectVariant 为请求选择服务的发布版本，返回用于选取实例的服务，并在响应头中返回命中的版本
+func (h *ProxyHandler) selectVariant(c *gin.Context, svc *upstream.Service, state *proxyState) *upstream.Service {
+	if len(svc.Variants()) == 0 {
+		return svc
+	}
+	optIn := c.GetHeader(constants.HeaderVariantKey)
+	if optIn == "" {
+		optIn, _ = c.Cookie(constants.CookieVariantKey)
+	}
+	target, variant := svc.SelectVariant(upstream.VariantRequest{
+		UserID: state.userID,
+		Admin:  middleware.IsAdmin(c),
+		OptIn:  optIn,
+	})
+	state.variant = variant
+	c.Header(constants.HeaderVariantKey, variant)
+	return target
+}
@@ -0,0 +1,260 @@
+package respcache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+var (
+	entryKeyPrefix = "gateway:cache:entry:"      // string: 缓存条目
+	generationsKey = "gateway:cache:generations" // hash: <service> 或 <service>:<cmd> -> 版本号，清除缓存时递增
+)
+
+// Tier 缓存命中的层级
+type Tier string
+
+const (
+	TierMemory Tier = "memory"
+	TierRedis  Tier = "redis"
+)
+
+// Entry 缓存的响应
+type Entry struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	ETag    string      `json:"etag"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"`
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	for k, vs := range e.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+type Options struct {
+	MaxEntries   int           // 内存层最大条目数
+	MaxBytes     int64         // 内存层最大字节数
+	RedisTimeout time.Duration // Redis 层单次读写超时
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = 10000
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 64 << 20
+	}
+	if o.RedisTimeout <= 0 {
+		o.RedisTimeout = 50 * time.Millisecond
+	}
+	return o
+}
+
+// Cache 两级响应缓存：进程内 LRU 和各网关副本共享的 Redis。
+// 清除缓存时递增服务或 cmd 的版本号，版本号是缓存键的一部分，旧条目不再命中并自然过期
+type Cache struct {
+	client redis.Cmdable // 为 nil 时只使用内存层
+	opts   Options
+	log    loggerv2.Logger
+
+	mu    sync.Mutex
+	ll    *list.List // 最近使用的在前
+	items map[string]*list.Element
+	bytes int64
+	gens  map[string]int64
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+// New 创建响应缓存，client 为 nil 时只使用内存层且清除操作只对本副本生效
+func New(client redis.Cmdable, opts Options, log loggerv2.Logger) *Cache {
+	return &Cache{
+		client: client,
+		opts:   opts.withDefaults(),
+		log:    log,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+		gens:   make(map[string]int64),
+	}
+}
+
+// Key 生成缓存键，variant 为区分同一 cmd 不同响应的维度 (查询参数、身份维度等)，已按需规范化
+func (c *Cache) Key(service, cmd, variant string) string {
+	c.mu.Lock()
+	svcGen, cmdGen := c.gens[service], c.gens[service+":"+cmd]
+	c.mu.Unlock()
+	return fmt.Sprintf("%s:%d:%s:%d:%s", service, svcGen, cmd, cmdGen, variant)
+}
+
+// Get 依次查找内存层和 Redis 层，Redis 层命中时回填内存层；Redis 出错时视为未命中
+func (c *Cache) Get(ctx context.Context, key string) (*Entry, Tier, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*memoryItem)
+		if now.Before(item.entry.Expires) {
+			c.ll.MoveToFront(elem)
+			c.mu.Unlock()
+			return item.entry, TierMemory, true
+		}
+		c.removeElement(elem)
+	}
+	c.mu.Unlock()
+
+	if c.client == nil {
+		return nil, "", false
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.RedisTimeout)
+	defer cancel()
+	val, err := c.client.Get(ctx, entryKeyPrefix+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			c.log.WarnContext(ctx, "get cache entry failed", logger.Error(err))
+		}
+		return nil, "", false
+	}
+	var entry Entry
+	if err = json.Unmarshal(val, &entry); err != nil || !now.Before(entry.Expires) {
+		return nil, "", false
+	}
+	c.store(key, &entry)
+	return &entry, TierRedis, true
+}
+
+// Set 写入内存层，并在后台写入 Redis 层
+func (c *Cache) Set(ctx context.Context, key string, entry *Entry) {
+	c.store(key, entry)
+	if c.client == nil {
+		return
+	}
+	val, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.opts.RedisTimeout)
+		defer cancel()
+		if err := c.client.Set(ctx, entryKeyPrefix+key, val, time.Until(entry.Expires)).Err(); err != nil {
+			c.log.WarnContext(ctx, "set cache entry failed", logger.Error(err))
+		}
+	}()
+}
+
+func (c *Cache) store(key string, entry *Entry) {
+	size := entry.size()
+	if size > c.opts.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	c.items[key] = c.ll.PushFront(&memoryItem{key: key, entry: entry})
+	c.bytes += size
+	for c.ll.Len() > c.opts.MaxEntries || c.bytes > c.opts.MaxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *Cache) removeElement(elem *list.Element) {
+	item := c.ll.Remove(elem).(*memoryItem)
+	delete(c.items, item.key)
+	c.bytes -= item.entry.size()
+}
+
+// Purge 清除服务的缓存，cmd 为空时清除服务的全部 cmd；其它副本在下次同步版本号时生效
+func (c *Cache) Purge(ctx context.Context, service, cmd string) error {
+	field := service
+	if cmd != "" {
+		field = service + ":" + cmd
+	}
+	c.mu.Lock()
+	gen := c.gens[field] + 1
+	c.mu.Unlock()
+	if c.client != nil {
+		var err error
+		if gen, err = c.client.HIncrBy(ctx, generationsKey, field, 1).Result(); err != nil {
+			return fmt.Errorf("Purge failed: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[field] = max(c.gens[field], gen)
+	c.purgeMemory(service, cmd)
+	return nil
+}
+
+// purgeMemory 释放服务 (cmd 为空时) 或 cmd 在内存层的条目，调用方需持有锁
+func (c *Cache) purgeMemory(service, cmd string) {
+	for key, elem := range c.items {
+		// 缓存键: <service>:<版本号>:<cmd>:<版本号>:<variant>
+		parts := strings.SplitN(key, ":", 4)
+		if parts[0] == service && (cmd == "" || (len(parts) > 2 && parts[2] == cmd)) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// Watch 立即同步一次版本号，之后每隔 interval 同步，ctx 结束后停止；未使用 Redis 时不做任何事
+func (c *Cache) Watch(ctx context.Context, interval time.Duration) {
+	if c.client == nil {
+		return
+	}
+	c.syncGenerations(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			c.syncGenerations(ctx)
+		}
+	}()
+}
+
+func (c *Cache) syncGenerations(ctx context.Context) {
+	vals, err := c.client.HGetAll(ctx, generationsKey).Result()
+	if err != nil {
+		c.log.WarnContext(ctx, "get cache generations failed", logger.Error(err))
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for field, val := range vals {
+		gen, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || gen <= c.gens[field] {
+			continue
+		}
+		c.gens[field] = gen
+		service, cmd, _ := strings.Cut(field, ":")
+		c.purgeMemory(service, cmd)
+	}
+}
@@ -0,0 +1,54 @@
+package respcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheMemory(t *testing.T) {
+	c := New(nil, Options{MaxEntries: 2}, nil)
+	ctx := context.Background()
+	newEntry := func(ttl time.Duration) *Entry {
+		now := time.Now()
+		return &Entry{Status: 200, Body: []byte("ok"), Stored: now, Expires: now.Add(ttl)}
+	}
+
+	a, b := c.Key("svc", "GetA", "v"), c.Key("svc", "GetB", "v")
+	c.Set(ctx, a, newEntry(time.Minute))
+	c.Set(ctx, b, newEntry(time.Minute))
+	if _, tier, ok := c.Get(ctx, a); !ok || tier != TierMemory {
+		t.Fatalf("get a: ok = %v, tier = %q", ok, tier)
+	}
+	// 超过条目上限时淘汰最久未使用的 b
+	c.Set(ctx, c.Key("svc", "GetC", "v"), newEntry(time.Minute))
+	if _, _, ok := c.Get(ctx, b); ok {
+		t.Fatal("least recently used entry not evicted")
+	}
+
+	expired := c.Key("svc", "GetD", "v")
+	c.Set(ctx, expired, newEntry(-time.Second))
+	if _, _, ok := c.Get(ctx, expired); ok {
+		t.Fatal("expired entry returned")
+	}
+
+	// 清除后缓存键变化，旧条目不再命中
+	if err := c.Purge(ctx, "svc", "GetA"); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if key := c.Key("svc", "GetA", "v"); key == a {
+		t.Fatal("key unchanged after purge")
+	}
+	if _, _, ok := c.Get(ctx, a); ok {
+		t.Fatal("purged entry returned")
+	}
+	if c.ll.Len() != 1 {
+		t.Fatalf("entries after purging GetA = %d, want 1", c.ll.Len())
+	}
+	if err := c.Purge(ctx, "svc", ""); err != nil {
+		t.Fatalf("purge service: %v", err)
+	}
+	if c.ll.Len() != 0 || c.bytes != 0 {
+		t.Fatalf("memory not released: entries = %d, bytes = %d", c.ll.Len(), c.bytes)
+	}
+}
@@ -0,0 +1,347 @@
+package route
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	constants "github.com/to404hanga/online_judge_gateway/constant"
+)
+
+const (
+	KindCmd  = "cmd"  // /api/<service>?cmd=<Name> 风格，cmd 取自查询参数
+	KindPath = "path" // 按公开路径模式匹配，cmd 由路由配置指定
+)
+
+// ServiceParam cmd 路由中表示目标服务的路径参数名
+const ServiceParam = "service"
+
+// Route 路由规则
+type Route struct {
+	Name    string   // 路由名称，仅用于日志
+	Kind    string   // 路由类型: cmd 或 path
+	Methods []string // 允许的请求方法，为空表示所有方法
+	Host    string   // 匹配的 Host，支持 *.example.com，为空表示所有 Host
+	Pattern string   // 公开路径模式，支持 :param 和末尾的 *param
+	Service string   // 目标服务，cmd 路由为空时取路径参数 service
+	Cmd     string   // path 路由对应的 cmd，用于权限校验、cmd 级策略和监控
+	Rewrite string   // 上游路径模板，支持引用路径参数，为空时 path 路由使用 /<cmd>
+	HashKey string   // 一致性哈希键: user、query:<name> 或 header:<name>，为空表示不使用
+	Mirror  *Mirror  // 流量镜像，nil 表示不镜像
+
+	segments []string
+	hashKey  hashKey
+}
+
+// Mirror 流量镜像配置，按比例将请求复制到影子上游，响应被丢弃
+type Mirror struct {
+	Target       *url.URL      // 影子上游地址
+	Percent      float64       // 镜像的请求百分比 (0-100]
+	MaxBodyBytes int64         // 镜像请求体的上限，超过时不镜像
+	Timeout      time.Duration // 影子请求超时
+}
+
+// 一致性哈希键来源
+const (
+	HashKeyUser   = "user"   // jwt.UserClaims 中的用户 ID
+	HashKeyQuery  = "query"  // 路径参数或查询参数
+	HashKeyHeader = "header" // 请求头
+)
+
+type hashKey struct {
+	source string
+	name   string
+}
+
+func parseHashKey(raw string) (hashKey, error) {
+	source, name, _ := strings.Cut(raw, ":")
+	switch source {
+	case "":
+		return hashKey{}, nil
+	case HashKeyUser:
+		if name != "" {
+			return hashKey{}, fmt.Errorf("unexpected name in hash key %q", raw)
+		}
+	case HashKeyQuery, HashKeyHeader:
+		if name == "" {
+			return hashKey{}, fmt.Errorf("missing name in hash key %q", raw)
+		}
+	default:
+		return hashKey{}, fmt.Errorf("unknown hash key %q", raw)
+	}
+	return hashKey{source: source, name: name}, nil
+}
+
+// Params 路径参数
+type Params map[string]string
+
+// Match 路由匹配结果
+type Match struct {
+	Route   *Route
+	Params  Params
+	Service string // 目标服务
+	Cmd     string // 解析出的 cmd，cmd 路由缺少 cmd 参数时为空
+}
+
+func (r *Route) compile() error {
+	if r.Kind == "" {
+		r.Kind = KindPath
+	}
+	if r.Kind != KindCmd && r.Kind != KindPath {
+		return fmt.Errorf("route %q: unknown kind %q", r.Name, r.Kind)
+	}
+	if !strings.HasPrefix(r.Pattern, "/") {
+		return fmt.Errorf("route %q: path must start with /", r.Name)
+	}
+	r.segments = splitPath(r.Pattern)
+	for idx, seg := range r.segments {
+		if strings.HasPrefix(seg, "*") && idx != len(r.segments)-1 {
+			return fmt.Errorf("route %q: wildcard must be the last segment", r.Name)
+		}
+	}
+	if r.Kind == KindCmd && r.Service == "" && !slices.Contains(r.segments, ":"+ServiceParam) {
+		return fmt.Errorf("route %q: cmd route requires service or :%s param", r.Name, ServiceParam)
+	}
+	if r.Kind == KindPath && r.Service == "" {
+		return fmt.Errorf("route %q: path route requires service", r.Name)
+	}
+	if r.Kind == KindPath && r.Cmd == "" && r.Rewrite == "" {
+		return fmt.Errorf("route %q: path route requires cmd or rewrite", r.Name)
+	}
+	if r.Cmd != "" && !ValidCmd(r.Cmd) {
+		return fmt.Errorf("route %q: invalid cmd %q", r.Name, r.Cmd)
+	}
+	for idx, method := range r.Methods {
+		r.Methods[idx] = strings.ToUpper(method)
+	}
+	if m := r.Mirror; m != nil {
+		if m.Target == nil || m.Target.Host == "" {
+			return fmt.Errorf("route %q: mirror requires target", r.Name)
+		}
+		// 缺少协议的地址 (如 staging:8081) 会被解析为 staging 协议，影子请求全部失败
+		if m.Target.Scheme != "http" && m.Target.Scheme != "https" {
+			return fmt.Errorf("route %q: mirror target must be an http or https URL, got %q", r.Name, m.Target.String())
+		}
+		if m.Percent <= 0 || m.Percent > 100 {
+			return fmt.Errorf("route %q: invalid mirror percent %v", r.Name, m.Percent)
+		}
+	}
+	key, err := parseHashKey(r.HashKey)
+	if err != nil {
+		return fmt.Errorf("route %q: %w", r.Name, err)
+	}
+	r.hashKey = key
+	return nil
+}
+
+func (r *Route) match(method, host, path string) (Params, bool) {
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, method) {
+		return nil, false
+	}
+	if r.Host != "" && !matchHost(r.Host, host) {
+		return nil, false
+	}
+
+	segs := splitPath(path)
+	if slices.Contains(segs, ".") || slices.Contains(segs, "..") {
+		// 拒绝相对路径，避免通过路径参数访问上游的其它路径
+		return nil, false
+	}
+	params := Params{}
+	for idx, pattern := range r.segments {
+		if strings.HasPrefix(pattern, "*") {
+			params[pattern[1:]] = strings.Join(segs[idx:], "/")
+			return params, true
+		}
+		if idx >= len(segs) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(pattern, ":"):
+			if segs[idx] == "" {
+				return nil, false
+			}
+			params[pattern[1:]] = segs[idx]
+		case pattern != segs[idx]:
+			return nil, false
+		}
+	}
+	if len(segs) != len(r.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// HashKey 按路由配置提取一致性哈希键，路由未配置或请求中没有对应的值时返回空；
+// query 来源优先取同名路径参数
+func (m *Match) HashKey(query url.Values, header http.Header, userID uint64) string {
+	key := m.Route.hashKey
+	switch key.source {
+	case HashKeyUser:
+		if userID == 0 {
+			return ""
+		}
+		return strconv.FormatUint(userID, 10)
+	case HashKeyQuery:
+		if value, ok := m.Params[key.name]; ok {
+			return value
+		}
+		return query.Get(key.name)
+	case HashKeyHeader:
+		return header.Get(key.name)
+	default:
+		return ""
+	}
+}
+
+// UpstreamURL 根据匹配结果计算上游路径和查询参数
+func (m *Match) UpstreamURL(query url.Values) (string, string) {
+	query = cloneValues(query)
+	switch m.Route.Kind {
+	case KindCmd:
+		query.Del(constants.ProxyKey)
+		if m.Route.Rewrite != "" {
+			return m.expand(m.Route.Rewrite, nil), query.Encode()
+		}
+		return "/" + m.Cmd, query.Encode()
+	default:
+		used := make(map[string]bool, len(m.Params))
+		rewrite := m.Route.Rewrite
+		if rewrite == "" {
+			rewrite = "/" + m.Cmd
+		}
+		upstreamPath := m.expand(rewrite, used)
+		// 未在上游路径中引用的路径参数以查询参数的形式转发
+		for name, value := range m.Params {
+			if !used[name] && !query.Has(name) {
+				query.Set(name, value)
+			}
+		}
+		return upstreamPath, query.Encode()
+	}
+}
+
+func (m *Match) expand(template string, used map[string]bool) string {
+	segs := splitPath(template)
+	for idx, seg := range segs {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			name := seg[1:]
+			if used != nil {
+				used[name] = true
+			}
+			value := m.Params[name]
+			if seg[0] == ':' {
+				value = url.PathEscape(value)
+			}
+			segs[idx] = value
+		}
+	}
+	return "/" + strings.Join(segs, "/")
+}
+
+// Table 路由表，按配置顺序匹配，先匹配的路由优先
+type Table struct {
+	routes []*Route
+}
+
+// DefaultRoutes 未配置路由时使用的默认路由，兼容 /api/<service>?cmd=<Name>
+func DefaultRoutes() []Route {
+	return []Route{
+		{
+			Name:    "default",
+			Kind:    KindCmd,
+			Pattern: "/api/:" + ServiceParam,
+		},
+	}
+}
+
+func NewTable(routes []Route) (*Table, error) {
+	t := &Table{routes: make([]*Route, 0, len(routes))}
+	for _, r := range routes {
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("NewTable failed: %w", err)
+		}
+		t.routes = append(t.routes, &r)
+	}
+	return t, nil
+}
+
+// Routes 返回路由表中的全部路由
+func (t *Table) Routes() []*Route {
+	return t.routes
+}
+
+// Match 按请求方法、Host 和路径查找路由，query 用于解析 cmd 路由的 cmd
+func (t *Table) Match(method, host, path string, query url.Values) (*Match, bool) {
+	for _, r := range t.routes {
+		params, ok := r.match(method, host, path)
+		if !ok {
+			continue
+		}
+		m := &Match{
+			Route:   r,
+			Params:  params,
+			Service: r.Service,
+			Cmd:     r.Cmd,
+		}
+		if m.Service == "" {
+			m.Service = params[ServiceParam]
+		}
+		if r.Kind == KindCmd {
+			m.Cmd = query.Get(constants.ProxyKey)
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+const maxCmdLength = 128
+
+// ValidCmd 判断 cmd 是否为规范形式: 单个路径段，以字母开头，仅包含字母、数字、_ 和 -。
+// 不做任何规范化处理，非规范形式的 cmd 直接拒绝，避免 ../、debug/pprof、编码字符等访问后端的其它路径；
+// 需要转发到多级路径时使用 path 路由的 rewrite
+func ValidCmd(cmd string) bool {
+	if len(cmd) == 0 || len(cmd) > maxCmdLength || !isLetter(cmd[0]) {
+		return false
+	}
+	for i := 1; i < len(cmd); i++ {
+		ch := cmd[i]
+		if !isLetter(ch) && !(ch >= '0' && ch <= '9') && ch != '_' && ch != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix)
+	}
+	return host == pattern
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func cloneValues(values url.Values) url.Values {
+	cloned := make(url.Values, len(values))
+	for k, v := range values {
+		cloned[k] = slices.Clone(v)
+	}
+	return cloned
+}
@@ -0,0 +1,206 @@
+package route
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTableMatchPrecedence(t *testing.T) {
+	table, err := NewTable([]Route{
+		{Name: "problem-get", Methods: []string{"get"}, Pattern: "/problems/:id", Service: "problem", Cmd: "GetProblem"},
+		{Name: "problem-any", Pattern: "/problems/:id", Service: "problem", Cmd: "UpdateProblem"},
+		{Name: "static", Host: "*.example.com", Pattern: "/static/*file", Service: "static", Rewrite: "/files/*file"},
+		{Name: "default", Kind: KindCmd, Pattern: "/api/:service"},
+	})
+	if err != nil {
+		t.Fatalf("NewTable: %v", err)
+	}
+
+	cases := []struct {
+		name, method, host, path, query string
+		route, service, cmd             string
+	}{
+		// 先配置的路由优先，方法不匹配时继续匹配后面的路由
+		{"first wins", http.MethodGet, "", "/problems/1", "", "problem-get", "problem", "GetProblem"},
+		{"method fallthrough", http.MethodPut, "", "/problems/1", "", "problem-any", "problem", "UpdateProblem"},
+		{"host wildcard", http.MethodGet, "cdn.example.com:8080", "/static/a/b.js", "", "static", "static", ""},
+		{"cmd route", http.MethodPost, "", "/api/user", "cmd=CreateUser", "default", "user", "CreateUser"},
+		{"cmd route missing cmd", http.MethodGet, "", "/api/user", "", "default", "user", ""},
+		{"host mismatch", http.MethodGet, "example.org", "/static/a.js", "", "", "", ""},
+		{"extra segment", http.MethodGet, "", "/problems/1/extra", "", "", "", ""},
+		{"empty param", http.MethodGet, "", "/problems/", "", "", "", ""},
+		{"dot dot", http.MethodGet, "cdn.example.com", "/static/../admin", "", "", "", ""},
+		{"dot", http.MethodGet, "", "/api/./user", "cmd=X", "", "", ""},
+	}
+	for _, tc := range cases {
+		query, _ := url.ParseQuery(tc.query)
+		m, ok := table.Match(tc.method, tc.host, tc.path, query)
+		if tc.route == "" {
+			if ok {
+				t.Errorf("%s: matched route %q, want no match", tc.name, m.Route.Name)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: no match, want route %q", tc.name, tc.route)
+			continue
+		}
+		if m.Route.Name != tc.route || m.Service != tc.service || m.Cmd != tc.cmd {
+			t.Errorf("%s: got route=%q service=%q cmd=%q, want %q %q %q",
+				tc.name, m.Route.Name, m.Service, m.Cmd, tc.route, tc.service, tc.cmd)
+		}
+	}
+}
+
+func TestMatchUpstreamURL(t *testing.T) {
+	table, err := NewTable([]Route{
+		{Name: "submission", Pattern: "/competitions/:cid/submissions/:sid", Service: "judge", Rewrite: "/submission/:sid"},
+		{Name: "problem", Pattern: "/problems/:id", Service: "problem", Cmd: "GetProblem"},
+		{Name: "files", Pattern: "/files/*path", Service: "static", Rewrite: "/raw/*path"},
+		{Name: "default", Kind: KindCmd, Pattern: "/api/:service"},
+	})
+	if err != nil {
+		t.Fatalf("NewTable: %v", err)
+	}
+
+	cases := []struct {
+		path, query      string
+		upstreamPath     string
+		upstreamRawQuery string
+	}{
+		// 未在上游路径中引用的路径参数作为查询参数转发，不覆盖客户端的同名参数
+		{"/competitions/7/submissions/9", "page=2", "/submission/9", "cid=7&page=2"},
+		{"/competitions/7/submissions/9", "cid=8", "/submission/9", "cid=8"},
+		{"/problems/a%20b", "", "/GetProblem", "id=a+b"},
+		{"/files/a/b/c.txt", "", "/raw/a/b/c.txt", ""},
+		// cmd 路由不转发 cmd 参数本身
+		{"/api/user", "cmd=GetUser&id=1", "/GetUser", "id=1"},
+	}
+	for _, tc := range cases {
+		query, _ := url.ParseQuery(tc.query)
+		path, _ := url.PathUnescape(tc.path)
+		m, ok := table.Match(http.MethodGet, "", path, query)
+		if !ok {
+			t.Errorf("%s: no match", tc.path)
+			continue
+		}
+		upstreamPath, rawQuery := m.UpstreamURL(query)
+		if upstreamPath != tc.upstreamPath || rawQuery != tc.upstreamRawQuery {
+			t.Errorf("%s?%s: upstream = %s?%s, want %s?%s",
+				tc.path, tc.query, upstreamPath, rawQuery, tc.upstreamPath, tc.upstreamRawQuery)
+		}
+		if query.Encode() != mustParseQuery(tc.query).Encode() {
+			t.Errorf("%s: UpstreamURL modified the request query", tc.path)
+		}
+	}
+}
+
+func TestMatchHashKey(t *testing.T) {
+	table, err := NewTable([]Route{
+		{Name: "by-query", Pattern: "/problems/:id", Service: "problem", Cmd: "GetProblem", HashKey: "query:id"},
+		{Name: "by-header", Pattern: "/h", Service: "problem", Cmd: "H", HashKey: "header:X-Tenant"},
+		{Name: "by-user", Pattern: "/u", Service: "problem", Cmd: "U", HashKey: "user"},
+	})
+	if err != nil {
+		t.Fatalf("NewTable: %v", err)
+	}
+	header := http.Header{"X-Tenant": []string{"t1"}}
+	query := url.Values{"id": []string{"from-query"}}
+
+	cases := []struct {
+		path   string
+		userID uint64
+		want   string
+	}{
+		{"/problems/42", 0, "42"}, // 路径参数优先于查询参数
+		{"/h", 0, "t1"},
+		{"/u", 7, "7"},
+		{"/u", 0, ""},
+	}
+	for _, tc := range cases {
+		m, ok := table.Match(http.MethodGet, "", tc.path, query)
+		if !ok {
+			t.Fatalf("%s: no match", tc.path)
+		}
+		if got := m.HashKey(query, header, tc.userID); got != tc.want {
+			t.Errorf("%s: HashKey = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestNewTableInvalid(t *testing.T) {
+	cases := []Route{
+		{Name: "kind", Kind: "grpc", Pattern: "/x", Service: "s", Cmd: "X"},
+		{Name: "relative", Pattern: "x", Service: "s", Cmd: "X"},
+		{Name: "wildcard", Pattern: "/a/*rest/b", Service: "s", Rewrite: "/b"},
+		{Name: "cmd-no-service", Kind: KindCmd, Pattern: "/api"},
+		{Name: "path-no-service", Pattern: "/x", Cmd: "X"},
+		{Name: "path-no-cmd", Pattern: "/x", Service: "s"},
+		{Name: "bad-cmd", Pattern: "/x", Service: "s", Cmd: "../X"},
+		{Name: "hash-key", Pattern: "/x", Service: "s", Cmd: "X", HashKey: "cookie:a"},
+		{Name: "mirror", Pattern: "/x", Service: "s", Cmd: "X", Mirror: &Mirror{}},
+		{Name: "mirror-no-scheme", Pattern: "/x", Service: "s", Cmd: "X", Mirror: mustMirror("staging:8081", 10)},
+		{Name: "mirror-scheme", Pattern: "/x", Service: "s", Cmd: "X", Mirror: mustMirror("ftp://staging:8081", 10)},
+		{Name: "mirror-no-host", Pattern: "/x", Service: "s", Cmd: "X", Mirror: mustMirror("http:///x", 10)},
+		{Name: "mirror-zero-percent", Pattern: "/x", Service: "s", Cmd: "X", Mirror: mustMirror("http://staging:8081", 0)},
+		{Name: "mirror-percent", Pattern: "/x", Service: "s", Cmd: "X", Mirror: mustMirror("http://staging:8081", 101)},
+	}
+	for _, r := range cases {
+		if _, err := NewTable([]Route{r}); err == nil {
+			t.Errorf("route %q: NewTable succeeded, want error", r.Name)
+		}
+	}
+}
+
+func TestNewTableMirror(t *testing.T) {
+	for _, target := range []string{"http://staging:8081", "https://staging.example.com/base"} {
+		_, err := NewTable([]Route{{Name: "m", Pattern: "/x", Service: "s", Cmd: "X", Mirror: mustMirror(target, 100)}})
+		if err != nil {
+			t.Errorf("mirror %s: NewTable: %v", target, err)
+		}
+	}
+}
+
+func mustMirror(target string, percent float64) *Mirror {
+	u, err := url.Parse(target)
+	if err != nil {
+		panic(err)
+	}
+	return &Mirror{Target: u, Percent: percent}
+}
+
+func TestValidCmd(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		want bool
+	}{
+		{"GetProblem", true},
+		{"get_problem-v2", true},
+		{"", false},
+		{"../x", false},
+		{"%2e%2e", false},
+		{"%2e%2e/x", false},
+		{"debug/pprof", false},
+		{"debug/pprof/", false},
+		{"/GetProblem", false},
+		{"a//b", false},
+		{".", false},
+		{"2fa", false},
+		{"Get Problem", false},
+		{"GetProblem?x=1", false},
+		{strings.Repeat("a", maxCmdLength), true},
+		{strings.Repeat("a", maxCmdLength+1), false},
+	}
+	for _, tc := range cases {
+		if got := ValidCmd(tc.cmd); got != tc.want {
+			t.Errorf("ValidCmd(%q) = %t, want %t", tc.cmd, got, tc.want)
+		}
+	}
+}
+
+func mustParseQuery(raw string) url.Values {
+	query, _ := url.ParseQuery(raw)
+	return query
+}
@@ -0,0 +1,180 @@
+package servicestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+var (
+	servicesKey = "gateway:proxy:services"         // hash: 服务名 -> ServiceSpec
+	versionKey  = "gateway:proxy:services:version" // 配置版本号
+	auditKey    = "gateway:proxy:audit"            // list: 审计记录，新记录在前
+)
+
+const maxUpdateAttempts = 5 // 并发修改时的最大尝试次数
+
+// compareAndSetScript 仅当全部服务配置均未被其它请求修改时写入新配置，并递增版本号、写入审计记录
+// KEYS: servicesKey, versionKey, auditKey
+// ARGV: 服务数, 审计记录保留条数, 之后每个服务依次为 服务名, 原配置 (不存在为空), 新配置, 审计记录
+var compareAndSetScript = redis.NewScript(`
+local n = tonumber(ARGV[1])
+for i = 0, n - 1 do
+	local cur = redis.call('HGET', KEYS[1], ARGV[3 + i * 4])
+	if (cur or '') ~= ARGV[4 + i * 4] then
+		return -1
+	end
+end
+for i = 0, n - 1 do
+	redis.call('HSET', KEYS[1], ARGV[3 + i * 4], ARGV[5 + i * 4])
+	redis.call('LPUSH', KEYS[3], ARGV[6 + i * 4])
+end
+local ver = redis.call('INCR', KEYS[2])
+redis.call('LTRIM', KEYS[3], 0, tonumber(ARGV[2]) - 1)
+return ver
+`)
+
+type RedisStore struct {
+	client    redis.Cmdable
+	auditSize int64
+	log       loggerv2.Logger
+}
+
+// NewRedisStore 创建基于 Redis 的服务配置存储，auditSize 为保留的审计记录条数
+func NewRedisStore(client redis.Cmdable, auditSize int64, log loggerv2.Logger) Store {
+	if auditSize <= 0 {
+		auditSize = 1000
+	}
+	return &RedisStore{
+		client:    client,
+		auditSize: auditSize,
+		log:       log,
+	}
+}
+
+var _ Store = &RedisStore{}
+
+func (s *RedisStore) Version(ctx context.Context) (int64, error) {
+	val, err := s.client.Get(ctx, versionKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("Version failed: %w", err)
+	}
+	ver, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Version failed: %w", err)
+	}
+	return ver, nil
+}
+
+func (s *RedisStore) Load(ctx context.Context) (int64, map[string]*ServiceSpec, error) {
+	// 先读版本号，加载期间发生的变更会在下次检查版本号时重新加载
+	ver, err := s.Version(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("Load failed: %w", err)
+	}
+	vals, err := s.client.HGetAll(ctx, servicesKey).Result()
+	if err != nil {
+		return 0, nil, fmt.Errorf("Load failed: %w", err)
+	}
+	specs := make(map[string]*ServiceSpec, len(vals))
+	for name, val := range vals {
+		var spec ServiceSpec
+		if err = json.Unmarshal([]byte(val), &spec); err != nil {
+			// 跳过无法解析的配置，避免单个服务的脏数据导致全部服务无法同步
+			s.log.WarnContext(ctx, "skip invalid service spec",
+				logger.String("service", name),
+				logger.Error(err),
+			)
+			continue
+		}
+		specs[name] = &spec
+	}
+	return ver, specs, nil
+}
+
+func (s *RedisStore) Update(ctx context.Context, service string, audit AuditEntry, fn UpdateFunc) (int64, error) {
+	return s.UpdateAll(ctx, []string{service}, audit, func(_ string, cur *ServiceSpec) (*ServiceSpec, error) {
+		return fn(cur)
+	})
+}
+
+func (s *RedisStore) UpdateAll(ctx context.Context, services []string, audit AuditEntry, fn UpdateAllFunc) (int64, error) {
+	if len(services) == 0 {
+		return 0, errors.New("UpdateAll failed: no service")
+	}
+	for range maxUpdateAttempts {
+		raws, err := s.client.HMGet(ctx, servicesKey, services...).Result()
+		if err != nil {
+			return 0, fmt.Errorf("UpdateAll failed: %w", err)
+		}
+
+		args := make([]any, 0, 2+len(services)*4)
+		args = append(args, len(services), s.auditSize)
+		for idx, service := range services {
+			raw, _ := raws[idx].(string)
+			var cur *ServiceSpec
+			if raw != "" {
+				cur = &ServiceSpec{}
+				if err = json.Unmarshal([]byte(raw), cur); err != nil {
+					return 0, fmt.Errorf("UpdateAll failed: invalid spec of service %s: %w", service, err)
+				}
+			}
+
+			next, err := fn(service, cur)
+			if err != nil {
+				return 0, err
+			}
+			nextRaw, err := json.Marshal(next)
+			if err != nil {
+				return 0, fmt.Errorf("UpdateAll failed: %w", err)
+			}
+			audit.Service = service
+			audit.Before = cur
+			audit.After = next
+			auditRaw, err := json.Marshal(audit)
+			if err != nil {
+				return 0, fmt.Errorf("UpdateAll failed: %w", err)
+			}
+			args = append(args, service, raw, nextRaw, auditRaw)
+		}
+
+		ver, err := compareAndSetScript.Run(ctx, s.client,
+			[]string{servicesKey, versionKey, auditKey}, args...,
+		).Int64()
+		if err != nil {
+			return 0, fmt.Errorf("UpdateAll failed: %w", err)
+		}
+		if ver >= 0 {
+			return ver, nil
+		}
+	}
+	return 0, fmt.Errorf("UpdateAll failed: %w", ErrConflict)
+}
+
+func (s *RedisStore) Audits(ctx context.Context, n int64) ([]AuditEntry, error) {
+	if n <= 0 {
+		return nil, errors.New("Audits failed: n must be positive")
+	}
+	vals, err := s.client.LRange(ctx, auditKey, 0, n-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Audits failed: %w", err)
+	}
+	entries := make([]AuditEntry, 0, len(vals))
+	for _, val := range vals {
+		var entry AuditEntry
+		if err = json.Unmarshal([]byte(val), &entry); err != nil {
+			return nil, fmt.Errorf("Audits failed: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
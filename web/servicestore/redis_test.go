@@ -0,0 +1,122 @@
+package servicestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+// newTestStore 返回连接到 miniredis 的 RedisStore，脚本由 miniredis 执行
+func newTestStore(t *testing.T, auditSize int64) (Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStore(client, auditSize, newTestLogger(t)), mr
+}
+
+func newTestLogger(t *testing.T) loggerv2.Logger {
+	t.Helper()
+	l, err := loggerv2.NewZapContextLoggerWithConfig(loggerv2.LoggerConfig{Development: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestRedisStoreUpdateAll(t *testing.T) {
+	store, mr := newTestStore(t, 3)
+	ctx := context.Background()
+
+	ver, err := store.UpdateAll(ctx, []string{"judge", "problem"}, AuditEntry{Action: "add_service"}, func(service string, cur *ServiceSpec) (*ServiceSpec, error) {
+		if cur != nil {
+			t.Fatalf("service %s: unexpected current spec %+v", service, cur)
+		}
+		return &ServiceSpec{ServiceName: service, Managed: true}, nil
+	})
+	if err != nil || ver != 1 {
+		t.Fatalf("UpdateAll = %d, %v, want version 1", ver, err)
+	}
+
+	ver, specs, err := store.Load(ctx)
+	if err != nil || ver != 1 || len(specs) != 2 || !specs["judge"].Managed || specs["problem"].ServiceName != "problem" {
+		t.Fatalf("Load = %d, %v, %v", ver, specs, err)
+	}
+
+	// 每个服务一条审计记录，新记录在前
+	audits, err := store.Audits(ctx, 10)
+	if err != nil || len(audits) != 2 || audits[0].Service != "problem" || audits[1].Service != "judge" || audits[1].Before != nil {
+		t.Fatalf("Audits = %+v, %v", audits, err)
+	}
+
+	// 任一服务返回错误时不写入任何服务
+	errExists := errors.New("exists")
+	_, err = store.UpdateAll(ctx, []string{"contest", "judge"}, AuditEntry{}, func(service string, cur *ServiceSpec) (*ServiceSpec, error) {
+		if cur != nil {
+			return nil, errExists
+		}
+		return &ServiceSpec{ServiceName: service}, nil
+	})
+	if !errors.Is(err, errExists) {
+		t.Fatalf("UpdateAll err = %v, want %v", err, errExists)
+	}
+	if ver, _ := mr.Get(versionKey); ver != "1" || mr.HGet(servicesKey, "contest") != "" {
+		t.Fatal("UpdateAll partially applied a failed batch")
+	}
+}
+
+func TestRedisStoreUpdateConflict(t *testing.T) {
+	store, mr := newTestStore(t, 0)
+	ctx := context.Background()
+
+	// 读取配置后、写入前配置被其它副本修改，重新读取后基于新配置更新
+	calls := 0
+	_, err := store.Update(ctx, "judge", AuditEntry{}, func(cur *ServiceSpec) (*ServiceSpec, error) {
+		calls++
+		if calls == 1 {
+			mr.HSet(servicesKey, "judge", `{"service_name":"judge","instances":[{"url":"http://10.0.0.1:8081","weight":1}]}`)
+		}
+		next := &ServiceSpec{ServiceName: "judge"}
+		if cur != nil {
+			next.Instances = cur.Instances
+		}
+		next.Instances = append(next.Instances, InstanceSpec{URL: "http://10.0.0.2:8081", Weight: 1})
+		return next, nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Update = %v after %d calls, want success after 2", err, calls)
+	}
+	var spec ServiceSpec
+	if err = json.Unmarshal([]byte(mr.HGet(servicesKey, "judge")), &spec); err != nil || len(spec.Instances) != 2 {
+		t.Fatalf("spec = %+v, %v, want both instances", spec, err)
+	}
+
+	// 持续冲突时放弃
+	_, err = store.Update(ctx, "judge", AuditEntry{}, func(cur *ServiceSpec) (*ServiceSpec, error) {
+		mr.HSet(servicesKey, "judge", mr.HGet(servicesKey, "judge")+" ")
+		return cur, nil
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("Update err = %v, want ErrConflict", err)
+	}
+}
+
+func TestRedisStoreLoadSkipsInvalidSpec(t *testing.T) {
+	store, mr := newTestStore(t, 0)
+	mr.Set(versionKey, "3")
+	mr.HSet(servicesKey, "judge", `{"service_name":"judge"}`)
+	mr.HSet(servicesKey, "broken", `{"service_name":`)
+
+	ver, specs, err := store.Load(context.Background())
+	if err != nil || ver != 3 {
+		t.Fatalf("Load = %d, %v", ver, err)
+	}
+	if _, ok := specs["broken"]; ok || specs["judge"] == nil {
+		t.Fatalf("specs = %v, want only judge", specs)
+	}
+}
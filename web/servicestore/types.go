@@ -0,0 +1,58 @@
+package servicestore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrConflict = errors.New("service spec modified concurrently")
+
+// ServiceSpec 通过管理接口维护的服务配置，覆盖配置文件中同名服务的实例列表
+type ServiceSpec struct {
+	ServiceName  string         `json:"service_name"`
+	Instances    []InstanceSpec `json:"instances"`
+	HealthCheck  string         `json:"health_check,omitempty"`  // 仅对管理接口新增的服务生效
+	LoadBalancer string         `json:"load_balancer,omitempty"` // 仅对管理接口新增的服务生效
+	Managed      bool           `json:"managed,omitempty"`       // 服务由管理接口新增，而不是来自配置文件
+	Deleted      bool           `json:"deleted,omitempty"`       // 删除标记，配置文件中的服务删除后也不会恢复
+}
+
+// InstanceSpec 服务实例配置
+type InstanceSpec struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// AuditEntry 一次服务配置变更的审计记录
+type AuditEntry struct {
+	Time      time.Time    `json:"time"`
+	UserID    uint64       `json:"user_id"`
+	RequestID string       `json:"request_id,omitempty"`
+	Action    string       `json:"action"`
+	Service   string       `json:"service"`
+	Instance  string       `json:"instance,omitempty"`
+	Before    *ServiceSpec `json:"before"`
+	After     *ServiceSpec `json:"after"`
+}
+
+// UpdateFunc 根据当前配置生成新配置，cur 为 nil 表示尚无配置
+type UpdateFunc func(cur *ServiceSpec) (*ServiceSpec, error)
+
+// UpdateAllFunc 根据服务当前配置生成新配置，cur 为 nil 表示尚无配置
+type UpdateAllFunc func(service string, cur *ServiceSpec) (*ServiceSpec, error)
+
+type Store interface {
+	// Version 返回配置版本号，每次变更后递增，用于各网关副本判断是否需要重新加载
+	Version(ctx context.Context) (int64, error)
+	// Load 返回全部服务配置及其对应的版本号，无法解析的配置被跳过
+	Load(ctx context.Context) (int64, map[string]*ServiceSpec, error)
+	// Update 读取服务配置并原子地替换为 fn 的结果，同时写入审计记录，返回新版本号；
+	// 并发修改同一服务时重新读取并调用 fn
+	Update(ctx context.Context, service string, audit AuditEntry, fn UpdateFunc) (int64, error)
+	// UpdateAll 与 Update 相同，但原子地更新多个服务并为每个服务写入审计记录；
+	// fn 对任一服务返回错误时不写入任何服务
+	UpdateAll(ctx context.Context, services []string, audit AuditEntry, fn UpdateAllFunc) (int64, error)
+	// Audits 返回最近的 n 条审计记录，新记录在前
+	Audits(ctx context.Context, n int64) ([]AuditEntry, error)
+}
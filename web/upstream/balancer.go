@@ -0,0 +1,178 @@
+package upstream
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// 负载均衡策略
+const (
+	StrategyRoundRobin         = "round_robin"          // 轮询
+	StrategyRandom             = "random"               // 随机
+	StrategyWeightedRandom     = "weighted_random"      // 加权随机
+	StrategyWeightedRoundRobin = "weighted_round_robin" // 加权轮询
+	StrategyLeastConn          = "least_conn"           // 最少连接
+	StrategyConsistentHash     = "consistent_hash"      // 有界负载一致性哈希，哈希键由路由配置
+)
+
+// Balancer 负载均衡器，从候选实例中选出一个实例
+type Balancer interface {
+	Pick(instances []*Instance) *Instance
+}
+
+// NewBalancer 根据策略名称创建负载均衡器，策略为空时默认使用轮询
+func NewBalancer(strategy string) (Balancer, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return &RoundRobinBalancer{}, nil
+	case StrategyRandom:
+		return &RandomBalancer{}, nil
+	case StrategyWeightedRandom:
+		return &WeightedRandomBalancer{}, nil
+	case StrategyWeightedRoundRobin:
+		return NewWeightedRoundRobinBalancer(), nil
+	case StrategyLeastConn:
+		return &LeastConnBalancer{}, nil
+	case StrategyConsistentHash:
+		return NewConsistentHashBalancer(defaultHashLoadFactor), nil
+	default:
+		return nil, fmt.Errorf("NewBalancer failed: unknown strategy %q", strategy)
+	}
+}
+
+// StrategyOf 返回负载均衡器对应的策略名称
+func StrategyOf(b Balancer) string {
+	switch b.(type) {
+	case *RoundRobinBalancer:
+		return StrategyRoundRobin
+	case *RandomBalancer:
+		return StrategyRandom
+	case *WeightedRandomBalancer:
+		return StrategyWeightedRandom
+	case *WeightedRoundRobinBalancer:
+		return StrategyWeightedRoundRobin
+	case *LeastConnBalancer:
+		return StrategyLeastConn
+	case *ConsistentHashBalancer:
+		return StrategyConsistentHash
+	default:
+		return ""
+	}
+}
+
+// RoundRobinBalancer 轮询
+type RoundRobinBalancer struct {
+	next atomic.Uint64
+}
+
+func (b *RoundRobinBalancer) Pick(instances []*Instance) *Instance {
+	if len(instances) == 0 {
+		return nil
+	}
+	n := b.next.Add(1) - 1
+	return instances[n%uint64(len(instances))]
+}
+
+// RandomBalancer 随机
+type RandomBalancer struct{}
+
+func (b *RandomBalancer) Pick(instances []*Instance) *Instance {
+	if len(instances) == 0 {
+		return nil
+	}
+	return instances[rand.IntN(len(instances))]
+}
+
+// WeightedRandomBalancer 加权随机
+type WeightedRandomBalancer struct{}
+
+func (b *WeightedRandomBalancer) Pick(instances []*Instance) *Instance {
+	if len(instances) == 0 {
+		return nil
+	}
+	total := 0
+	for _, inst := range instances {
+		total += inst.Weight
+	}
+	n := rand.IntN(total)
+	for _, inst := range instances {
+		n -= inst.Weight
+		if n < 0 {
+			return inst
+		}
+	}
+	return instances[len(instances)-1]
+}
+
+// WeightedRoundRobinBalancer 平滑加权轮询 (与 nginx 的实现一致)
+type WeightedRoundRobinBalancer struct {
+	mu             sync.Mutex
+	currentWeights map[*Instance]int
+}
+
+func NewWeightedRoundRobinBalancer() *WeightedRoundRobinBalancer {
+	return &WeightedRoundRobinBalancer{
+		currentWeights: make(map[*Instance]int),
+	}
+}
+
+func (b *WeightedRoundRobinBalancer) Pick(instances []*Instance) *Instance {
+	if len(instances) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 清理已不在候选列表中的实例，避免其历史权重影响后续选择
+	if len(b.currentWeights) > len(instances) {
+		alive := make(map[*Instance]struct{}, len(instances))
+		for _, inst := range instances {
+			alive[inst] = struct{}{}
+		}
+		for inst := range b.currentWeights {
+			if _, ok := alive[inst]; !ok {
+				delete(b.currentWeights, inst)
+			}
+		}
+	}
+
+	var best *Instance
+	total := 0
+	for _, inst := range instances {
+		b.currentWeights[inst] += inst.Weight
+		total += inst.Weight
+		if best == nil || b.currentWeights[inst] > b.currentWeights[best] {
+			best = inst
+		}
+	}
+	b.currentWeights[best] -= total
+	return best
+}
+
+// LeastConnBalancer 最少连接，连接数相同时随机选择以避免总是命中第一个实例
+type LeastConnBalancer struct{}
+
+func (b *LeastConnBalancer) Pick(instances []*Instance) *Instance {
+	if len(instances) == 0 {
+		return nil
+	}
+	var best *Instance
+	var bestConns int64
+	ties := 0
+	for _, inst := range instances {
+		conns := inst.ActiveConns()
+		switch {
+		case best == nil || conns < bestConns:
+			best, bestConns, ties = inst, conns, 1
+		case conns == bestConns:
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = inst
+			}
+		}
+	}
+	return best
+}
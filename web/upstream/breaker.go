@@ -0,0 +1,242 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 关闭，请求正常通过
+	BreakerOpen                         // 打开，请求快速失败
+	BreakerHalfOpen                     // 半开，允许少量探测请求通过
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// Outcome 请求结果
+type Outcome int
+
+const (
+	OutcomeSuccess Outcome = iota // 成功
+	OutcomeFailure                // 失败，计入熔断统计
+	OutcomeIgnored                // 忽略，如客户端主动取消，不计入熔断统计
+)
+
+const windowBuckets = 10 // 滑动窗口分桶数
+
+// BreakerOptions 熔断器配置，ConsecutiveFailures 与 FailureRatio 至少配置一项
+type BreakerOptions struct {
+	ConsecutiveFailures int           // 连续失败多少次后熔断，0 表示不按连续失败熔断
+	FailureRatio        float64       // 滑动窗口内失败率达到多少后熔断，0 表示不按失败率熔断
+	MinRequests         int           // 滑动窗口内至少多少个请求才按失败率判断
+	Window              time.Duration // 滑动窗口长度
+	Cooldown            time.Duration // 熔断多久后进入半开状态
+	HalfOpenRequests    int           // 半开状态下允许通过的探测请求数，全部成功后关闭熔断
+}
+
+func (o BreakerOptions) withDefaults() BreakerOptions {
+	if o.MinRequests <= 0 {
+		o.MinRequests = 20
+	}
+	if o.Window <= 0 {
+		o.Window = 10 * time.Second
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = 30 * time.Second
+	}
+	if o.HalfOpenRequests <= 0 {
+		o.HalfOpenRequests = 1
+	}
+	return o
+}
+
+type windowBucket struct {
+	start    time.Time
+	total    int
+	failures int
+}
+
+// Breaker 熔断器，支持按连续失败次数和滑动窗口失败率熔断，nil 表示不熔断
+type Breaker struct {
+	mu       sync.Mutex
+	opts     BreakerOptions
+	onChange func(state BreakerState)
+
+	state      BreakerState
+	generation uint64 // 每次状态切换时递增，用于丢弃旧状态下请求的统计结果
+	openedAt   time.Time
+
+	consecutiveFailures int
+	buckets             [windowBuckets]windowBucket
+
+	halfOpenInFlight  int
+	halfOpenSuccesses int
+}
+
+// NewBreaker 创建熔断器，onChange 在状态切换时调用 (持有锁，不应阻塞)
+func NewBreaker(opts BreakerOptions, onChange func(state BreakerState)) *Breaker {
+	b := &Breaker{
+		opts:     opts.withDefaults(),
+		onChange: onChange,
+	}
+	if onChange != nil {
+		onChange(BreakerClosed)
+	}
+	return b
+}
+
+// State 返回熔断器当前状态
+func (b *Breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(time.Now())
+	return b.state
+}
+
+// Ready 判断当前是否可能放行请求，不占用半开探测名额
+func (b *Breaker) Ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(time.Now())
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.halfOpenInFlight < b.opts.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow 判断是否放行请求，放行时返回当前代数，请求结束后需使用该代数调用 Record
+func (b *Breaker) Allow() (uint64, bool) {
+	if b == nil {
+		return 0, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(time.Now())
+	switch b.state {
+	case BreakerOpen:
+		return 0, false
+	case BreakerHalfOpen:
+		if b.halfOpenInFlight >= b.opts.HalfOpenRequests {
+			return 0, false
+		}
+		b.halfOpenInFlight++
+	}
+	return b.generation, true
+}
+
+// Record 记录请求结果
+func (b *Breaker) Record(generation uint64, outcome Outcome) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	now := time.Now()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		b.halfOpenInFlight--
+		switch outcome {
+		case OutcomeFailure:
+			b.setState(BreakerOpen, now)
+		case OutcomeSuccess:
+			b.halfOpenSuccesses++
+			if b.halfOpenSuccesses >= b.opts.HalfOpenRequests {
+				b.setState(BreakerClosed, now)
+			}
+		}
+	case BreakerClosed:
+		if outcome == OutcomeIgnored {
+			return
+		}
+		bucket := b.currentBucket(now)
+		bucket.total++
+		if outcome == OutcomeFailure {
+			bucket.failures++
+			b.consecutiveFailures++
+		} else {
+			b.consecutiveFailures = 0
+		}
+		if b.shouldTrip(now) {
+			b.setState(BreakerOpen, now)
+		}
+	}
+}
+
+func (b *Breaker) shouldTrip(now time.Time) bool {
+	if b.opts.ConsecutiveFailures > 0 && b.consecutiveFailures >= b.opts.ConsecutiveFailures {
+		return true
+	}
+	if b.opts.FailureRatio <= 0 {
+		return false
+	}
+	total, failures := 0, 0
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.opts.Window {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total >= b.opts.MinRequests && float64(failures)/float64(total) >= b.opts.FailureRatio
+}
+
+func (b *Breaker) currentBucket(now time.Time) *windowBucket {
+	width := b.opts.Window / windowBuckets
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%windowBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = windowBucket{start: start}
+	}
+	return bucket
+}
+
+// refresh 熔断冷却结束后切换到半开状态
+func (b *Breaker) refresh(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.opts.Cooldown {
+		b.setState(BreakerHalfOpen, now)
+	}
+}
+
+func (b *Breaker) setState(state BreakerState, now time.Time) {
+	b.state = state
+	b.generation++
+	b.consecutiveFailures = 0
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccesses = 0
+	if state == BreakerOpen {
+		b.openedAt = now
+	}
+	if state == BreakerClosed {
+		b.buckets = [windowBuckets]windowBucket{}
+	}
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
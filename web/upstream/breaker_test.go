@@ -0,0 +1,143 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	var states []BreakerState
+	b := NewBreaker(BreakerOptions{
+		ConsecutiveFailures: 3,
+		Cooldown:            20 * time.Millisecond,
+		HalfOpenRequests:    2,
+	}, func(state BreakerState) {
+		states = append(states, state)
+	})
+
+	record := func(outcome Outcome) {
+		t.Helper()
+		gen, ok := b.Allow()
+		if !ok {
+			t.Fatalf("request rejected in state %s", b.State())
+		}
+		b.Record(gen, outcome)
+	}
+
+	// 成功会重置连续失败计数，忽略的结果不计入
+	record(OutcomeFailure)
+	record(OutcomeFailure)
+	record(OutcomeSuccess)
+	record(OutcomeFailure)
+	record(OutcomeIgnored)
+	record(OutcomeFailure)
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("state = %s, want closed", s)
+	}
+	record(OutcomeFailure)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("state = %s, want open", s)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("open breaker allowed request")
+	}
+
+	// 冷却结束后半开，只放行 HalfOpenRequests 个探测请求
+	time.Sleep(30 * time.Millisecond)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half_open", s)
+	}
+	g1, ok1 := b.Allow()
+	g2, ok2 := b.Allow()
+	if !ok1 || !ok2 {
+		t.Fatal("half-open breaker rejected probe")
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("half-open breaker allowed more than HalfOpenRequests probes")
+	}
+	b.Record(g1, OutcomeSuccess)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("state = %s after one probe, want half_open", s)
+	}
+	b.Record(g2, OutcomeSuccess)
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("state = %s after all probes succeeded, want closed", s)
+	}
+
+	want := []BreakerState{BreakerClosed, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(states) != len(want) {
+		t.Fatalf("state changes = %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("state changes = %v, want %v", states, want)
+		}
+	}
+}
+
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	b := NewBreaker(BreakerOptions{ConsecutiveFailures: 1, Cooldown: 10 * time.Millisecond}, nil)
+	gen, _ := b.Allow()
+	b.Record(gen, OutcomeFailure)
+	time.Sleep(20 * time.Millisecond)
+
+	gen, ok := b.Allow()
+	if !ok {
+		t.Fatal("half-open breaker rejected probe")
+	}
+	b.Record(gen, OutcomeFailure)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("state = %s after failed probe, want open", s)
+	}
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	b := NewBreaker(BreakerOptions{FailureRatio: 0.5, MinRequests: 4, Window: time.Second}, nil)
+	for _, outcome := range []Outcome{OutcomeFailure, OutcomeFailure, OutcomeFailure} {
+		gen, _ := b.Allow()
+		b.Record(gen, outcome)
+	}
+	// 请求数未达到 MinRequests 时不按失败率熔断
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("state = %s below MinRequests, want closed", s)
+	}
+	gen, _ := b.Allow()
+	b.Record(gen, OutcomeSuccess)
+	if s := b.State(); s != BreakerOpen {
+		t.Fatalf("state = %s at failure ratio 0.75, want open", s)
+	}
+}
+
+func TestBreakerGenerationGuard(t *testing.T) {
+	b := NewBreaker(BreakerOptions{ConsecutiveFailures: 2, Cooldown: 10 * time.Millisecond}, nil)
+
+	// 熔断前放行的慢请求在熔断器切换状态后才返回
+	stale, _ := b.Allow()
+	for i := 0; i < 2; i++ {
+		gen, _ := b.Allow()
+		b.Record(gen, OutcomeFailure)
+	}
+	time.Sleep(20 * time.Millisecond)
+	probe, ok := b.Allow()
+	if !ok {
+		t.Fatal("half-open breaker rejected probe")
+	}
+
+	// 旧代数的结果不影响半开状态，也不占用探测名额
+	b.Record(stale, OutcomeFailure)
+	if s := b.State(); s != BreakerHalfOpen {
+		t.Fatalf("state = %s after stale failure, want half_open", s)
+	}
+	b.Record(probe, OutcomeSuccess)
+	if s := b.State(); s != BreakerClosed {
+		t.Fatalf("state = %s after probe succeeded, want closed", s)
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *Breaker
+	if _, ok := b.Allow(); !ok || !b.Ready() || b.State() != BreakerClosed {
+		t.Fatal("nil breaker must always allow")
+	}
+	b.Record(0, OutcomeFailure)
+}
@@ -0,0 +1,51 @@
+package upstream
+
+import (
+	"fmt"
+	"time"
+)
+
+// CommandPolicy cmd 级别的转发策略，未配置的项沿用服务级配置
+type CommandPolicy struct {
+	Name     string
+	Retry    *RetryPolicy
+	Timeout  time.Duration   // 整个转发 (包括重试) 的超时时间，0 表示使用服务级配置
+	Cache    *CachePolicy    // 响应缓存策略，nil 表示不缓存
+	Coalesce *CoalescePolicy // 合并相同并发读请求的策略，nil 表示不合并
+}
+
+// CacheScope 缓存或合并请求时共享响应的身份维度
+type CacheScope string
+
+const (
+	CacheScopePublic CacheScope = "public" // 所有用户共享
+	CacheScopeRole   CacheScope = "role"   // 同一角色的用户共享
+	CacheScopeUser   CacheScope = "user"   // 每个用户单独缓存
+)
+
+// ParseCacheScope 解析缓存维度，空字符串表示 public
+func ParseCacheScope(name string) (CacheScope, error) {
+	switch scope := CacheScope(name); scope {
+	case "":
+		return CacheScopePublic, nil
+	case CacheScopePublic, CacheScopeRole, CacheScopeUser:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown cache scope %q", name)
+	}
+}
+
+// CachePolicy cmd 的响应缓存策略，仅缓存 GET 请求的 200 响应
+type CachePolicy struct {
+	Scope        CacheScope
+	TTL          time.Duration // 最长缓存时间，后端 Cache-Control 的 max-age 更短时以后端为准
+	MaxBodyBytes int64         // 响应体超过该值时不缓存
+	VaryHeaders  []string      // 参与缓存键的请求头 (规范形式)，后端 Vary 其它请求头时不缓存
+}
+
+// CoalescePolicy cmd 的请求合并策略，相同的并发 GET 请求共享一次转发的响应
+type CoalescePolicy struct {
+	Scope        CacheScope
+	MaxBodyBytes int64    // 响应体超过该值时不共享，等待的请求各自转发
+	VaryHeaders  []string // 参与合并键的请求头 (规范形式)
+}
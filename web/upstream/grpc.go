@@ -0,0 +1,40 @@
+package upstream
+
+import (
+	"net/netip"
+
+	"github.com/to404hanga/online_judge_gateway/web/grpcx"
+)
+
+// GRPCOptions gRPC 服务配置
+type GRPCOptions struct {
+	Codec       *grpcx.Codec   // 用于 JSON 转码的服务描述
+	TrustedNets []netip.Prefix // 允许直接使用 gRPC (h2c) 访问的客户端网段，为空表示不允许
+}
+
+// Trusted 判断客户端地址是否允许直接使用 gRPC 访问
+func (o *GRPCOptions) Trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range o.TrustedNets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithGRPC 将服务标记为 gRPC 服务，JSON 请求会按 cmd 转码为一元 gRPC 调用
+func WithGRPC(opts GRPCOptions) ServiceOption {
+	return func(s *Service) {
+		s.grpc = &opts
+	}
+}
+
+// GRPC 返回服务的 gRPC 配置，非 gRPC 服务返回 nil
+func (s *Service) GRPC() *GRPCOptions {
+	return s.grpc
+}
@@ -0,0 +1,148 @@
+package upstream
+
+import (
+	"hash/fnv"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultHashReplicas   = 160  // 每个权重单位对应的虚拟节点数
+	defaultHashLoadFactor = 1.25 // 实例负载上限相对平均负载的倍数
+)
+
+// KeyedBalancer 按请求的哈希键选择实例的负载均衡器
+type KeyedBalancer interface {
+	Balancer
+	// PickKey 从 candidates 中按 key 选择实例，instances 为服务的全部实例，candidates 为其中可用的实例
+	PickKey(instances, candidates []*Instance, key string) *Instance
+}
+
+// ConsistentHashBalancer 有界负载一致性哈希：相同哈希键的请求优先命中同一实例，
+// 实例进行中的请求数超过上限时顺延到哈希环上的下一个实例，避免热点键压垮单个实例；
+// 请求没有哈希键时退化为最少连接
+type ConsistentHashBalancer struct {
+	loadFactor float64
+	fallback   LeastConnBalancer
+
+	mu   sync.Mutex
+	ring *hashRing // 服务全部实例对应的哈希环，实例列表变化时重建
+}
+
+// NewConsistentHashBalancer 创建一致性哈希负载均衡器，loadFactor 为实例负载上限相对平均负载的倍数，
+// 不大于 1 时使用默认值 1.25
+func NewConsistentHashBalancer(loadFactor float64) *ConsistentHashBalancer {
+	if loadFactor <= 1 {
+		loadFactor = defaultHashLoadFactor
+	}
+	return &ConsistentHashBalancer{loadFactor: loadFactor}
+}
+
+var _ KeyedBalancer = (*ConsistentHashBalancer)(nil)
+
+func (b *ConsistentHashBalancer) Pick(instances []*Instance) *Instance {
+	return b.fallback.Pick(instances)
+}
+
+// PickKey 哈希环只在服务的实例列表变化时重建，沿环查找时跳过不健康、熔断或重试排除的实例，
+// 候选实例在重试和半开探测时频繁变化也不需要重建
+func (b *ConsistentHashBalancer) PickKey(instances, candidates []*Instance, key string) *Instance {
+	if len(candidates) == 0 {
+		return nil
+	}
+	if key == "" || len(candidates) == 1 {
+		return b.fallback.Pick(candidates)
+	}
+
+	ring := b.getRing(instances)
+
+	// 按权重分配负载上限: ceil(loadFactor * (总负载 + 1) * 权重 / 总权重)，只统计候选实例
+	allowed := make(map[*Instance]struct{}, len(candidates))
+	var (
+		totalLoad   int64
+		totalWeight int
+	)
+	for _, inst := range candidates {
+		allowed[inst] = struct{}{}
+		totalLoad += inst.ActiveConns()
+		totalWeight += inst.Weight
+	}
+	capacity := b.loadFactor * float64(totalLoad+1) / float64(totalWeight)
+
+	hash := hashKey(key)
+	start := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i].hash >= hash
+	})
+	var first *Instance
+	for i := range len(ring.points) {
+		inst := ring.points[(start+i)%len(ring.points)].inst
+		if _, ok := allowed[inst]; !ok {
+			continue
+		}
+		if float64(inst.ActiveConns()+1) <= math.Ceil(capacity*float64(inst.Weight)) {
+			return inst
+		}
+		if first == nil {
+			first = inst
+		}
+	}
+	if first == nil {
+		// 候选实例不在哈希环上 (实例列表刚被替换)，退化为最少连接
+		return b.fallback.Pick(candidates)
+	}
+	// 负载上限保证至少有一个实例可用，这里仅作兜底
+	return first
+}
+
+func (b *ConsistentHashBalancer) getRing(instances []*Instance) *hashRing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ring == nil || !slices.Equal(b.ring.instances, instances) {
+		b.ring = newHashRing(instances)
+	}
+	return b.ring
+}
+
+// hashRing 哈希环，虚拟节点位置只取决于实例地址，实例增减时只有相邻区间的键会迁移
+type hashRing struct {
+	instances []*Instance
+	points    []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	inst *Instance
+}
+
+func newHashRing(instances []*Instance) *hashRing {
+	r := &hashRing{instances: slices.Clone(instances)}
+	for _, inst := range instances {
+		addr := inst.Addr()
+		for i := range inst.Weight * defaultHashReplicas {
+			r.points = append(r.points, ringPoint{
+				hash: hashKey(addr + "#" + strconv.Itoa(i)),
+				inst: inst,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i].hash < r.points[j].hash
+	})
+	return r
+}
+
+// hashKey 使用 FNV-1a 并做一次混淆，使相近的键在环上分布均匀
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,95 @@
+package upstream
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newHashInstances(t *testing.T, n int) []*Instance {
+	instances := make([]*Instance, 0, n)
+	for i := range n {
+		inst, err := NewInstance(fmt.Sprintf("http://10.0.0.%d:8081", i+1), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, inst)
+	}
+	return instances
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	instances := newHashInstances(t, 4)
+	b := NewConsistentHashBalancer(1.25)
+
+	// 相同的键始终命中同一实例
+	first := b.PickKey(instances, instances, "competition-1")
+	for range 10 {
+		if inst := b.PickKey(instances, instances, "competition-1"); inst != first {
+			t.Fatalf("key moved from %s to %s", first.Addr(), inst.Addr())
+		}
+	}
+
+	// 移除其它实例不影响该键
+	remaining := make([]*Instance, 0, len(instances))
+	removed := false
+	for _, inst := range instances {
+		if inst != first && !removed {
+			removed = true
+			continue
+		}
+		remaining = append(remaining, inst)
+	}
+	if inst := b.PickKey(remaining, remaining, "competition-1"); inst != first {
+		t.Fatalf("key moved after removing unrelated instances: %s", inst.Addr())
+	}
+
+	// 热点键超过负载上限后顺延到其它实例
+	for range 10 {
+		first.Acquire()
+	}
+	defer func() {
+		for range 10 {
+			first.Release()
+		}
+	}()
+	if inst := b.PickKey(instances, instances, "competition-1"); inst == first {
+		t.Fatalf("overloaded instance %s still picked", first.Addr())
+	}
+}
+
+func TestConsistentHashBalancerCandidates(t *testing.T) {
+	instances := newHashInstances(t, 4)
+	b := NewConsistentHashBalancer(1.25)
+	first := b.PickKey(instances, instances, "competition-1")
+	ring := b.ring
+
+	// 排除命中的实例后顺延到环上的下一个候选实例，且不重建哈希环
+	others := without(instances, first)
+	next := b.PickKey(instances, others, "competition-1")
+	if next == nil || next == first {
+		t.Fatalf("excluded instance picked: %v", next)
+	}
+	if inst := b.PickKey(instances, without(others, next), "competition-1"); inst == next || inst == first {
+		t.Fatalf("excluded instance %s picked", inst.Addr())
+	}
+	if b.ring != ring {
+		t.Fatal("hash ring rebuilt when only the candidates changed")
+	}
+
+	// 实例恢复后键回到原来的实例
+	if inst := b.PickKey(instances, instances, "competition-1"); inst != first {
+		t.Fatalf("key moved from %s to %s after the instance recovered", first.Addr(), inst.Addr())
+	}
+
+	// 实例列表变化时重建哈希环
+	b.PickKey(others, others, "competition-1")
+	if b.ring == ring {
+		t.Fatal("hash ring not rebuilt after the instance list changed")
+	}
+
+	// 候选实例不在哈希环上时退化为最少连接
+	stranger := newHashInstances(t, 6)[4:]
+	if inst := b.PickKey(others, stranger, "competition-1"); inst != stranger[0] && inst != stranger[1] {
+		t.Fatalf("picked %v, want one of the candidates", inst)
+	}
+}
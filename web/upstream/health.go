@@ -0,0 +1,247 @@
+package upstream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/to404hanga/pkg404/logger"
+	loggerv2 "github.com/to404hanga/pkg404/logger/v2"
+)
+
+const (
+	defaultHealthCheckPath     = "/health"
+	defaultHealthCheckInterval = 30 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+)
+
+// HealthCheckOptions 主动健康检查配置
+type HealthCheckOptions struct {
+	Path               string        // 健康检查路径
+	Interval           time.Duration // 检查间隔
+	Timeout            time.Duration // 检查超时
+	HealthyThreshold   int           // 连续成功多少次后恢复为健康
+	UnhealthyThreshold int           // 连续失败多少次后标记为不健康
+}
+
+func (o *HealthCheckOptions) withDefaults() HealthCheckOptions {
+	opts := *o
+	if opts.Path == "" {
+		opts.Path = defaultHealthCheckPath
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultHealthCheckInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHealthCheckTimeout
+	}
+	if opts.HealthyThreshold <= 0 {
+		opts.HealthyThreshold = 1
+	}
+	if opts.UnhealthyThreshold <= 0 {
+		opts.UnhealthyThreshold = 1
+	}
+	return opts
+}
+
+// HealthChecker 定期探测各服务实例的健康检查接口，不健康的实例会被移出负载均衡，恢复后自动加入
+type HealthChecker struct {
+	services []*Service
+	client   *http.Client
+	log      loggerv2.Logger
+
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running map[*Service]context.CancelFunc // 正在检查的服务
+	wg      sync.WaitGroup
+}
+
+func NewHealthChecker(log loggerv2.Logger, services []*Service) *HealthChecker {
+	return &HealthChecker{
+		services: services,
+		client:   &http.Client{},
+		log:      log,
+		running:  make(map[*Service]context.CancelFunc),
+	}
+}
+
+// Start 为每个开启了健康检查的服务启动一个后台检查协程
+func (c *HealthChecker) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	for _, svc := range c.services {
+		c.startLocked(svc)
+	}
+}
+
+// Add 开始检查运行时新增的服务，检查器未启动时在 Start 时一并启动
+func (c *HealthChecker) Add(svc *Service) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx == nil {
+		c.services = append(c.services, svc)
+		return
+	}
+	c.startLocked(svc)
+}
+
+// Remove 停止检查运行时移除的服务
+func (c *HealthChecker) Remove(svc *Service) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.services = slices.DeleteFunc(c.services, func(s *Service) bool { return s == svc })
+	if cancel, ok := c.running[svc]; ok {
+		cancel()
+		delete(c.running, svc)
+	}
+}
+
+func (c *HealthChecker) startLocked(svc *Service) {
+	if svc.healthCheck == nil {
+		return
+	}
+	if _, ok := c.running[svc]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.running[svc] = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.run(ctx, svc, svc.healthCheck.withDefaults())
+	}()
+}
+
+// Stop 停止所有后台检查协程
+func (c *HealthChecker) Stop() {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+func (c *HealthChecker) run(ctx context.Context, svc *Service, opts HealthCheckOptions) {
+	counters := make(map[*Instance]*checkCounter)
+	c.checkService(ctx, svc, opts, counters)
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkService(ctx, svc, opts, counters)
+		}
+	}
+}
+
+// checkCounter 记录实例连续成功/失败次数，仅在所属服务的检查协程中访问
+type checkCounter struct {
+	successes int
+	failures  int
+}
+
+func (c *HealthChecker) checkService(ctx context.Context, svc *Service, opts HealthCheckOptions, counters map[*Instance]*checkCounter) {
+	instances := svc.Instances()
+	results := make([]bool, len(instances))
+
+	var wg sync.WaitGroup
+	for idx, inst := range instances {
+		wg.Add(1)
+		go func(idx int, inst *Instance) {
+			defer wg.Done()
+			results[idx] = c.probe(ctx, svc, inst, opts)
+		}(idx, inst)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for idx, inst := range instances {
+		cnt, ok := counters[inst]
+		if !ok {
+			cnt = &checkCounter{}
+			counters[inst] = cnt
+		}
+		if results[idx] {
+			cnt.successes++
+			cnt.failures = 0
+		} else {
+			cnt.failures++
+			cnt.successes = 0
+		}
+
+		healthy := inst.Healthy()
+		switch {
+		case healthy && cnt.failures >= opts.UnhealthyThreshold:
+			healthy = false
+			c.log.WarnContext(ctx, "upstream instance marked unhealthy",
+				logger.String("service", svc.Name),
+				logger.String("instance", inst.Addr()),
+			)
+		case !healthy && cnt.successes >= opts.HealthyThreshold:
+			healthy = true
+			c.log.InfoContext(ctx, "upstream instance recovered",
+				logger.String("service", svc.Name),
+				logger.String("instance", inst.Addr()),
+			)
+		}
+		inst.setHealthy(healthy, now)
+
+		gauge := 0.0
+		if healthy {
+			gauge = 1
+		}
+		upstreamInstanceHealthy.WithLabelValues(svc.Name, inst.Addr()).Set(gauge)
+	}
+
+	// 清理服务发现已移除实例的计数
+	if len(counters) > len(instances) {
+		for inst := range counters {
+			if !slices.Contains(instances, inst) {
+				delete(counters, inst)
+			}
+		}
+	}
+}
+
+func (c *HealthChecker) probe(ctx context.Context, svc *Service, inst *Instance, opts HealthCheckOptions) bool {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	result := "success"
+	defer func() {
+		upstreamHealthChecksTotal.WithLabelValues(svc.Name, inst.Addr(), result).Inc()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, inst.URL.JoinPath(opts.Path).String(), nil)
+	if err != nil {
+		result = "error"
+		return false
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		result = "error"
+		c.log.WarnContext(ctx, "upstream health check failed",
+			logger.String("service", svc.Name),
+			logger.String("instance", inst.Addr()),
+			logger.Error(err),
+		)
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result = "bad_status"
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Instance 服务实例
+type Instance struct {
+	URL    *url.URL // 实例地址
+	Weight int      // 权重，用于加权负载均衡
+
+	activeConns atomic.Int64 // 当前正在处理的请求数
+	healthy     atomic.Bool  // 健康状态，由健康检查维护
+	lastCheck   atomic.Int64 // 最后检查时间 (UnixNano)
+	breaker     *Breaker     // 实例级被动异常检测，nil 表示不启用
+}
+
+// NewInstance 创建服务实例，rawURL 支持 http://host:port 与 host:port 两种格式
+func NewInstance(rawURL string, weight int) (*Instance, error) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("NewInstance failed: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("NewInstance failed: missing host in %q", rawURL)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	inst := &Instance{
+		URL:    u,
+		Weight: weight,
+	}
+	inst.healthy.Store(true) // 首次健康检查完成前默认健康
+	return inst, nil
+}
+
+// Addr 返回实例地址字符串
+func (i *Instance) Addr() string {
+	return i.URL.String()
+}
+
+// BreakerState 返回实例被动异常检测的熔断状态
+func (i *Instance) BreakerState() BreakerState {
+	return i.breaker.State()
+}
+
+// Acquire 标记实例开始处理一个请求
+func (i *Instance) Acquire() {
+	i.activeConns.Add(1)
+}
+
+// Release 标记实例处理完一个请求
+func (i *Instance) Release() {
+	i.activeConns.Add(-1)
+}
+
+// ActiveConns 返回实例当前正在处理的请求数
+func (i *Instance) ActiveConns() int64 {
+	return i.activeConns.Load()
+}
+
+// Healthy 返回实例是否健康
+func (i *Instance) Healthy() bool {
+	return i.healthy.Load()
+}
+
+// LastCheck 返回实例最后一次健康检查的时间，从未检查过时返回零值
+func (i *Instance) LastCheck() time.Time {
+	ns := i.lastCheck.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (i *Instance) setHealthy(healthy bool, checkedAt time.Time) {
+	i.healthy.Store(healthy)
+	i.lastCheck.Store(checkedAt.UnixNano())
+}
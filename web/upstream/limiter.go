@@ -0,0 +1,284 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConcurrencyLimit = errors.New("concurrency limit exceeded")
+	ErrQueueTimeout     = errors.New("queue wait timeout")
+)
+
+// Priority 请求优先级，数值越小优先级越高
+type Priority int8
+
+const (
+	PriorityCritical Priority = iota // 关键，如管理员修复比赛的操作
+	PriorityHigh
+	PriorityNormal // 默认
+	PriorityLow    // 可以延后的请求，如导出数据
+
+	numPriorities = int(PriorityLow) + 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityLow:
+		return "low"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePriority 解析优先级名称，空字符串表示 normal
+func ParsePriority(name string) (Priority, error) {
+	if name == "" {
+		return PriorityNormal, nil
+	}
+	for p := PriorityCritical; int(p) < numPriorities; p++ {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", name)
+}
+
+const (
+	latencyShortAlpha  = 0.1  // 短期延迟均值的平滑系数
+	latencyLongAlpha   = 0.01 // 长期延迟均值的平滑系数，作为自适应模式的延迟基准
+	latencyWarmupCount = 50   // 长期均值至少统计多少个请求后才按延迟变化调整上限
+)
+
+// LimiterOptions 服务级并发限制配置
+type LimiterOptions struct {
+	MaxInFlight      int           // 最大进行中请求数，自适应模式下为上限的最大值
+	MaxQueue         int           // 排队等待的请求数上限，0 表示不排队
+	QueueTimeout     time.Duration // 排队等待超时
+	Adaptive         bool          // 根据延迟和失败自动调整上限 (AIMD)
+	MinInFlight      int           // 自适应模式下上限的最小值
+	LatencyThreshold time.Duration // 自适应模式: 请求延迟超过该值时减小上限，0 表示按延迟均值的变化判断
+	LatencyTolerance float64       // 自适应模式: 短期延迟均值超过长期均值的多少倍时减小上限
+	Backoff          float64       // 自适应模式: 减小上限时乘以的系数
+}
+
+func (o LimiterOptions) withDefaults() LimiterOptions {
+	if o.QueueTimeout <= 0 {
+		o.QueueTimeout = time.Second
+	}
+	if o.MinInFlight <= 0 {
+		o.MinInFlight = 1
+	}
+	if o.MinInFlight > o.MaxInFlight {
+		o.MinInFlight = o.MaxInFlight
+	}
+	if o.LatencyTolerance <= 1 {
+		o.LatencyTolerance = 2
+	}
+	if o.Backoff <= 0 || o.Backoff >= 1 {
+		o.Backoff = 0.9
+	}
+	return o
+}
+
+// Limiter 服务级并发限制 (隔板)，超过上限的请求在有界队列中按优先级和先后顺序等待，nil 表示不限制
+type Limiter struct {
+	mu       sync.Mutex
+	opts     LimiterOptions
+	onChange func(limit, inFlight int, queued [numPriorities]int)
+
+	limit    float64 // 当前上限，自适应模式下按加性增、乘性减调整
+	inFlight int
+	queue    []*limiterWaiter // 按优先级排序，同一优先级按先后顺序
+
+	shortLatency float64 // 单位: 秒
+	longLatency  float64 // 单位: 秒
+	samples      int
+	lastDecrease time.Time
+}
+
+type limiterWaiter struct {
+	priority Priority
+	ready    chan struct{}
+	granted  bool
+	err      error // 未获得名额时的原因，如被更高优先级的请求挤出队列
+}
+
+// NewLimiter 创建并发限制，onChange 在上限、进行中或各优先级排队请求数变化时调用 (持有锁，不应阻塞)
+func NewLimiter(opts LimiterOptions, onChange func(limit, inFlight int, queued [numPriorities]int)) *Limiter {
+	opts = opts.withDefaults()
+	l := &Limiter{
+		opts:     opts,
+		onChange: onChange,
+		limit:    float64(opts.MaxInFlight),
+	}
+	l.report()
+	return l
+}
+
+// Permit 一次并发名额，请求结束后必须调用 Done 归还
+type Permit struct {
+	l     *Limiter
+	start time.Time
+}
+
+// Done 归还名额，自适应模式下根据请求延迟和结果调整上限
+func (p *Permit) Done(outcome Outcome) {
+	if p == nil {
+		return
+	}
+	p.l.release(time.Since(p.start), outcome)
+}
+
+// Acquire 获取并发名额，达到上限时排队等待，优先级高的请求先获得名额；
+// 队列已满且没有优先级更低的请求可以挤出，或排队时被挤出时返回 ErrConcurrencyLimit，
+// 等待超时返回 ErrQueueTimeout，ctx 结束时返回 ctx.Err()
+func (l *Limiter) Acquire(ctx context.Context, priority Priority) (*Permit, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	if len(l.queue) == 0 && l.inFlight < l.current() {
+		l.inFlight++
+		l.report()
+		l.mu.Unlock()
+		return l.newPermit(), nil
+	}
+	if len(l.queue) >= l.opts.MaxQueue {
+		// 挤出排在最后的优先级更低的请求
+		last := len(l.queue) - 1
+		if last < 0 || l.queue[last].priority <= priority {
+			l.mu.Unlock()
+			return nil, ErrConcurrencyLimit
+		}
+		evicted := l.queue[last]
+		l.queue = l.queue[:last]
+		evicted.err = ErrConcurrencyLimit
+		close(evicted.ready)
+	}
+	w := &limiterWaiter{priority: priority, ready: make(chan struct{})}
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].priority > priority {
+		i--
+	}
+	l.queue = slices.Insert(l.queue, i, w)
+	l.report()
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.opts.QueueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		if !w.granted {
+			return nil, w.err
+		}
+		return l.newPermit(), nil
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.granted {
+		// 等待结束的同时获得了名额
+		return l.newPermit(), nil
+	}
+	if w.err != nil {
+		// 等待结束的同时被挤出队列
+		return nil, w.err
+	}
+	l.queue = slices.DeleteFunc(l.queue, func(q *limiterWaiter) bool {
+		return q == w
+	})
+	l.report()
+	return nil, err
+}
+
+func (l *Limiter) newPermit() *Permit {
+	return &Permit{l: l, start: time.Now()}
+}
+
+// current 返回当前生效的整数上限
+func (l *Limiter) current() int {
+	return int(l.limit)
+}
+
+func (l *Limiter) release(latency time.Duration, outcome Outcome) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.opts.Adaptive && outcome != OutcomeIgnored {
+		l.adjust(latency, outcome, time.Now())
+	}
+	l.inFlight--
+	l.dispatch()
+	l.report()
+}
+
+// dispatch 按优先级和排队顺序将空出的名额交给等待的请求；
+// 自适应模式下上限增加时空出的名额可能多于归还的一个，需要唤醒最多 limit-inFlight 个请求
+func (l *Limiter) dispatch() {
+	for len(l.queue) > 0 && l.inFlight < l.current() {
+		w := l.queue[0]
+		l.queue = l.queue[1:]
+		w.granted = true
+		close(w.ready)
+		l.inFlight++
+	}
+}
+
+// adjust 请求失败或延迟升高时按 Backoff 减小上限，否则在上限被充分使用时每个周期增加 1
+func (l *Limiter) adjust(latency time.Duration, outcome Outcome, now time.Time) {
+	sample := latency.Seconds()
+	if l.samples == 0 {
+		l.shortLatency, l.longLatency = sample, sample
+	} else {
+		l.shortLatency += latencyShortAlpha * (sample - l.shortLatency)
+		l.longLatency += latencyLongAlpha * (sample - l.longLatency)
+	}
+	l.samples++
+
+	congested := outcome == OutcomeFailure
+	if l.opts.LatencyThreshold > 0 {
+		congested = congested || latency > l.opts.LatencyThreshold
+	} else if l.samples >= latencyWarmupCount {
+		congested = congested || l.shortLatency > l.longLatency*l.opts.LatencyTolerance
+	}
+
+	if congested {
+		// 每个延迟周期最多减小一次，避免同一批慢请求连续减小上限
+		if now.Sub(l.lastDecrease) >= latency {
+			l.limit = max(float64(l.opts.MinInFlight), l.limit*l.opts.Backoff)
+			l.lastDecrease = now
+		}
+		return
+	}
+	if l.inFlight*2 >= l.current() {
+		l.limit = min(float64(l.opts.MaxInFlight), l.limit+1/l.limit)
+	}
+}
+
+func (l *Limiter) report() {
+	if l.onChange == nil {
+		return
+	}
+	var queued [numPriorities]int
+	for _, w := range l.queue {
+		queued[w.priority]++
+	}
+	l.onChange(l.current(), l.inFlight, queued)
+}
@@ -0,0 +1,185 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterQueue(t *testing.T) {
+	l := NewLimiter(LimiterOptions{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond}, nil)
+
+	first, err := l.Acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+
+	// 排队的请求在名额归还后获得名额
+	acquired := make(chan error, 1)
+	go func() {
+		p, err := l.Acquire(context.Background(), PriorityNormal)
+		if err == nil {
+			p.Done(OutcomeSuccess)
+		}
+		acquired <- err
+	}()
+	waitQueued(t, l, 1)
+
+	if _, err = l.Acquire(context.Background(), PriorityNormal); !errors.Is(err, ErrConcurrencyLimit) {
+		t.Fatalf("acquire with full queue: err = %v, want ErrConcurrencyLimit", err)
+	}
+	first.Done(OutcomeSuccess)
+	if err = <-acquired; err != nil {
+		t.Fatalf("queued acquire: %v", err)
+	}
+
+	// 等待超时后退出队列
+	held, _ := l.Acquire(context.Background(), PriorityNormal)
+	if _, err = l.Acquire(context.Background(), PriorityNormal); !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("queued acquire: err = %v, want ErrQueueTimeout", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = l.Acquire(ctx, PriorityNormal); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled acquire: err = %v, want context.Canceled", err)
+	}
+	held.Done(OutcomeSuccess)
+	waitQueued(t, l, 0)
+	if l.inFlight != 0 {
+		t.Fatalf("inFlight = %d after all permits released", l.inFlight)
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := NewLimiter(LimiterOptions{MaxInFlight: 1, MaxQueue: 2, QueueTimeout: time.Second}, nil)
+	held, _ := l.Acquire(context.Background(), PriorityNormal)
+
+	order := make(chan Priority, 3)
+	results := make(chan error, 3)
+	acquire := func(p Priority) {
+		permit, err := l.Acquire(context.Background(), p)
+		if err == nil {
+			order <- p
+			permit.Done(OutcomeSuccess)
+		}
+		results <- err
+	}
+	go acquire(PriorityLow)
+	waitQueued(t, l, 1)
+	go acquire(PriorityNormal)
+	waitQueued(t, l, 2)
+	// 队列已满，挤出优先级最低的请求
+	go acquire(PriorityCritical)
+	if err := <-results; !errors.Is(err, ErrConcurrencyLimit) {
+		t.Fatalf("evicted acquire: err = %v, want ErrConcurrencyLimit", err)
+	}
+	waitQueued(t, l, 2)
+	if _, err := l.Acquire(context.Background(), PriorityNormal); !errors.Is(err, ErrConcurrencyLimit) {
+		t.Fatalf("acquire with full queue of higher priority: err = %v, want ErrConcurrencyLimit", err)
+	}
+
+	held.Done(OutcomeSuccess)
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Fatalf("queued acquire: %v", err)
+		}
+	}
+	if first, second := <-order, <-order; first != PriorityCritical || second != PriorityNormal {
+		t.Fatalf("admission order = %v, %v, want critical, normal", first, second)
+	}
+}
+
+func TestLimiterAdaptive(t *testing.T) {
+	l := NewLimiter(LimiterOptions{
+		MaxInFlight:      10,
+		Adaptive:         true,
+		MinInFlight:      2,
+		LatencyThreshold: 100 * time.Millisecond,
+		Backoff:          0.5,
+	}, nil)
+	now := time.Now()
+
+	l.adjust(time.Millisecond, OutcomeFailure, now)
+	if l.current() != 5 {
+		t.Fatalf("limit after failure = %d, want 5", l.current())
+	}
+	// 同一延迟周期内不重复减小
+	l.adjust(200*time.Millisecond, OutcomeSuccess, now.Add(time.Millisecond))
+	if l.current() != 5 {
+		t.Fatalf("limit after second decrease in same period = %d, want 5", l.current())
+	}
+	l.adjust(200*time.Millisecond, OutcomeSuccess, now.Add(time.Second))
+	l.adjust(200*time.Millisecond, OutcomeSuccess, now.Add(2*time.Second))
+	if l.current() != 2 {
+		t.Fatalf("limit = %d, want MinInFlight 2", l.current())
+	}
+
+	// 上限被充分使用时逐步恢复
+	l.inFlight = 2
+	for i := 0; i < 20; i++ {
+		l.adjust(time.Millisecond, OutcomeSuccess, now.Add(3*time.Second))
+	}
+	if l.current() <= 2 {
+		t.Fatalf("limit did not increase: %d", l.current())
+	}
+	l.inFlight = 0
+	limit := l.limit
+	l.adjust(time.Millisecond, OutcomeSuccess, now.Add(3*time.Second))
+	if l.limit != limit {
+		t.Fatal("limit increased while underused")
+	}
+}
+
+func TestLimiterWakeOnLimitIncrease(t *testing.T) {
+	l := NewLimiter(LimiterOptions{
+		MaxInFlight:      10,
+		MaxQueue:         4,
+		QueueTimeout:     time.Second,
+		Adaptive:         true,
+		LatencyThreshold: time.Hour,
+	}, nil)
+	// 模拟之前被减小的上限，下一次成功的请求使整数上限从 2 增加到 3
+	l.limit = 2.9
+
+	first, _ := l.Acquire(context.Background(), PriorityNormal)
+	second, _ := l.Acquire(context.Background(), PriorityNormal)
+	acquired := make(chan *Permit, 2)
+	for range 2 {
+		go func() {
+			p, err := l.Acquire(context.Background(), PriorityNormal)
+			if err != nil {
+				t.Errorf("queued acquire: %v", err)
+			}
+			acquired <- p
+		}()
+	}
+	waitQueued(t, l, 2)
+
+	// 归还一个名额的同时上限增加 1，两个排队的请求都应获得名额
+	first.Done(OutcomeSuccess)
+	for range 2 {
+		select {
+		case p := <-acquired:
+			defer p.Done(OutcomeIgnored)
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("queued request not woken after limit increased to %d", l.current())
+		}
+	}
+	second.Done(OutcomeIgnored)
+}
+
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		queued := len(l.queue)
+		l.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("queue length did not reach %d", n)
+}
@@ -0,0 +1,78 @@
+package upstream
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	upstreamInstanceHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "upstream",
+			Name:      "instance_healthy",
+			Help:      "Whether the upstream instance is healthy (1) or not (0).",
+		},
+		[]string{"service", "instance"},
+	)
+	upstreamHealthChecksTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "upstream",
+			Name:      "health_checks_total",
+			Help:      "Upstream health checks total.",
+		},
+		[]string{"service", "instance", "result"},
+	)
+	upstreamCircuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "upstream",
+			Name:      "circuit_state",
+			Help:      "Circuit breaker state (0=closed, 1=open, 2=half_open), instance is empty for service level breakers.",
+		},
+		[]string{"service", "instance"},
+	)
+	upstreamConcurrencyLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "upstream",
+			Name:      "concurrency_limit",
+			Help:      "Current concurrency limit of the upstream service.",
+		},
+		[]string{"service"},
+	)
+	upstreamInFlightRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "upstream",
+			Name:      "in_flight_requests",
+			Help:      "In-flight requests counted by the upstream service concurrency limit.",
+		},
+		[]string{"service"},
+	)
+	upstreamQueuedRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "online_judge_gateway",
+			Subsystem: "upstream",
+			Name:      "queued_requests",
+			Help:      "Requests waiting for the upstream service concurrency limit by priority.",
+		},
+		[]string{"service", "priority"},
+	)
+)
+
+// deleteLimiterMetrics 删除服务的并发限制监控指标
+func deleteLimiterMetrics(service string) {
+	upstreamConcurrencyLimit.DeleteLabelValues(service)
+	upstreamInFlightRequests.DeleteLabelValues(service)
+	upstreamQueuedRequests.DeletePartialMatch(prometheus.Labels{"service": service})
+}
+
+func init() {
+	prometheus.MustRegister(
+		upstreamInstanceHealthy,
+		upstreamHealthChecksTotal,
+		upstreamCircuitState,
+		upstreamConcurrencyLimit,
+		upstreamInFlightRequests,
+		upstreamQueuedRequests,
+	)
+}
@@ -0,0 +1,147 @@
+package upstream
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrServiceExists = errors.New("service already exists")
+
+// Registry 服务注册表，支持运行时增删服务。读取无锁，增删时整体替换快照
+type Registry struct {
+	mu       sync.Mutex // 串行化服务的增删
+	snapshot atomic.Pointer[registrySnapshot]
+	checker  *HealthChecker
+}
+
+type registrySnapshot struct {
+	services     map[string]*Service
+	grpcServices map[string]*Service // proto 服务全名 -> 服务
+}
+
+// NewRegistry 创建服务注册表，运行时新增和移除的服务会同步加入和退出 checker 的健康检查
+func NewRegistry(checker *HealthChecker, services ...*Service) *Registry {
+	r := &Registry{checker: checker}
+	snap := &registrySnapshot{
+		services:     make(map[string]*Service, len(services)),
+		grpcServices: make(map[string]*Service),
+	}
+	for _, svc := range services {
+		snap.add(svc)
+	}
+	r.snapshot.Store(snap)
+	return r
+}
+
+func (s *registrySnapshot) add(svc *Service) {
+	s.services[svc.Name] = svc
+	if opts := svc.GRPC(); opts != nil {
+		s.grpcServices[opts.Codec.ServiceName()] = svc
+	}
+}
+
+func (s *registrySnapshot) clone() *registrySnapshot {
+	next := &registrySnapshot{
+		services:     make(map[string]*Service, len(s.services)),
+		grpcServices: make(map[string]*Service, len(s.grpcServices)),
+	}
+	for name, svc := range s.services {
+		next.services[name] = svc
+	}
+	for name, svc := range s.grpcServices {
+		next.grpcServices[name] = svc
+	}
+	return next
+}
+
+// Get 按服务名查找服务
+func (r *Registry) Get(name string) (*Service, bool) {
+	svc, ok := r.snapshot.Load().services[name]
+	return svc, ok
+}
+
+// GetGRPC 按 proto 服务全名查找 gRPC 服务
+func (r *Registry) GetGRPC(name string) (*Service, bool) {
+	svc, ok := r.snapshot.Load().grpcServices[name]
+	return svc, ok
+}
+
+// List 按服务名排序返回全部服务
+func (r *Registry) List() []*Service {
+	snap := r.snapshot.Load()
+	services := make([]*Service, 0, len(snap.services))
+	for _, svc := range snap.services {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services
+}
+
+// GRPCServices 返回全部 gRPC 服务
+func (r *Registry) GRPCServices() []*Service {
+	snap := r.snapshot.Load()
+	services := make([]*Service, 0, len(snap.grpcServices))
+	for _, svc := range snap.grpcServices {
+		services = append(services, svc)
+	}
+	return services
+}
+
+// Add 新增服务，同名服务已存在时返回 ErrServiceExists
+func (r *Registry) Add(svc *Service) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap := r.snapshot.Load()
+	if _, ok := snap.services[svc.Name]; ok {
+		return ErrServiceExists
+	}
+	next := snap.clone()
+	next.add(svc)
+	r.snapshot.Store(next)
+	if r.checker != nil {
+		r.checker.Add(svc)
+		for _, v := range svc.Variants() {
+			r.checker.Add(v.Service)
+		}
+	}
+	return nil
+}
+
+// Remove 移除服务并返回服务及其发布版本被移除前的实例；已选中该服务实例的请求不受影响
+func (r *Registry) Remove(name string) ([]*Instance, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap := r.snapshot.Load()
+	svc, ok := snap.services[name]
+	if !ok {
+		return nil, false
+	}
+	next := snap.clone()
+	delete(next.services, name)
+	if opts := svc.GRPC(); opts != nil {
+		delete(next.grpcServices, opts.Codec.ServiceName())
+	}
+	r.snapshot.Store(next)
+	if r.checker != nil {
+		r.checker.Remove(svc)
+	}
+	// 清空实例以释放监控指标标签
+	upstreamCircuitState.DeleteLabelValues(svc.Name, "")
+	deleteLimiterMetrics(svc.Name)
+	removed := svc.SetInstances(nil)
+	for _, v := range svc.Variants() {
+		if r.checker != nil {
+			r.checker.Remove(v.Service)
+		}
+		upstreamCircuitState.DeleteLabelValues(v.Service.Name, "")
+		deleteLimiterMetrics(v.Service.Name)
+		removed = append(removed, v.Service.SetInstances(nil)...)
+	}
+	return removed, true
+}
@@ -0,0 +1,83 @@
+package upstream
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy 重试策略
+type RetryPolicy struct {
+	MaxAttempts    int           // 最大尝试次数 (包含首次请求)
+	BackoffBase    time.Duration // 退避基准时间，第 n 次重试的退避上限为 BackoffBase * 2^(n-1)
+	BackoffMax     time.Duration // 退避时间上限
+	OnConnectError bool          // 连接失败或连接被重置时是否重试
+	OnStatus       []int         // 后端返回哪些状态码时重试
+	Safe           bool          // 是否允许非幂等方法重试，仅应对确认可重复执行的 cmd 开启
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 2
+	}
+	if p.BackoffBase <= 0 {
+		p.BackoffBase = 50 * time.Millisecond
+	}
+	if p.BackoffMax <= 0 {
+		p.BackoffMax = time.Second
+	}
+	return p
+}
+
+// Attempts 返回给定请求方法允许的最大尝试次数，非幂等方法未标记 Safe 时不重试
+func (p *RetryPolicy) Attempts(method string) int {
+	if p == nil || (!isIdempotentMethod(method) && !p.Safe) {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Backoff 返回第 retry 次重试前的等待时间 (full jitter)
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	ceiling := p.BackoffBase << (retry - 1)
+	if ceiling <= 0 || ceiling > p.BackoffMax {
+		ceiling = p.BackoffMax
+	}
+	return rand.N(ceiling + 1)
+}
+
+// RetryableStatus 判断后端返回的状态码是否需要重试
+func (p *RetryPolicy) RetryableStatus(code int) bool {
+	return slices.Contains(p.OnStatus, code)
+}
+
+// RetryableError 判断转发错误是否需要重试
+func (p *RetryPolicy) RetryableError(err error) bool {
+	return p.OnConnectError && isConnectError(err)
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// isConnectError 判断是否为建立连接失败或连接被对端重置
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
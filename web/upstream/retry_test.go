@@ -0,0 +1,89 @@
+package upstream
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryAttempts(t *testing.T) {
+	var nilPolicy *RetryPolicy
+	if n := nilPolicy.Attempts(http.MethodGet); n != 1 {
+		t.Fatalf("nil policy attempts = %d, want 1", n)
+	}
+
+	p := RetryPolicy{MaxAttempts: 3}.withDefaults()
+	cases := []struct {
+		method string
+		safe   bool
+		want   int
+	}{
+		{http.MethodGet, false, 3},
+		{http.MethodHead, false, 3},
+		{http.MethodOptions, false, 3},
+		{http.MethodPost, false, 1},
+		{http.MethodPut, false, 1},
+		{http.MethodDelete, false, 1},
+		{http.MethodPost, true, 3},
+	}
+	for _, tc := range cases {
+		p.Safe = tc.safe
+		if n := p.Attempts(tc.method); n != tc.want {
+			t.Errorf("Attempts(%s) safe=%t = %d, want %d", tc.method, tc.safe, n, tc.want)
+		}
+	}
+
+	def := RetryPolicy{}.withDefaults()
+	if n := def.Attempts(http.MethodGet); n != 2 {
+		t.Fatalf("default attempts = %d, want 2", n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{BackoffBase: 10 * time.Millisecond, BackoffMax: 50 * time.Millisecond}.withDefaults()
+	ceilings := map[int]time.Duration{
+		1:  10 * time.Millisecond,
+		2:  20 * time.Millisecond,
+		3:  40 * time.Millisecond,
+		4:  50 * time.Millisecond,
+		64: 50 * time.Millisecond, // 移位溢出时取上限
+	}
+	for retry, ceiling := range ceilings {
+		for i := 0; i < 100; i++ {
+			if d := p.Backoff(retry); d < 0 || d > ceiling {
+				t.Fatalf("Backoff(%d) = %v, want within [0, %v]", retry, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryableStatusAndError(t *testing.T) {
+	p := RetryPolicy{OnStatus: []int{502, 503}}.withDefaults()
+	if !p.RetryableStatus(503) || p.RetryableStatus(500) {
+		t.Fatal("RetryableStatus must only match OnStatus")
+	}
+
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	if p.RetryableError(dialErr) {
+		t.Fatal("connect error retried with OnConnectError disabled")
+	}
+	p.OnConnectError = true
+	retryable := []error{
+		dialErr,
+		fmt.Errorf("round trip: %w", syscall.ECONNRESET),
+		io.ErrUnexpectedEOF,
+	}
+	for _, err := range retryable {
+		if !p.RetryableError(err) {
+			t.Errorf("RetryableError(%v) = false, want true", err)
+		}
+	}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ETIMEDOUT}
+	if p.RetryableError(readErr) {
+		t.Fatal("read timeout must not be retried")
+	}
+}
@@ -0,0 +1,341 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNoHealthyInstance = errors.New("no healthy instance found")
+	ErrCircuitOpen       = errors.New("circuit breaker open")
+)
+
+// Service 后端服务，由多个实例和负载均衡器组成
+type Service struct {
+	Name        string
+	instances   atomic.Pointer[[]*Instance] // 服务发现更新时整体替换
+	mu          sync.Mutex                  // 串行化实例列表的更新
+	balancer    Balancer
+	healthCheck *HealthCheckOptions
+	breaker     *Breaker        // 服务级熔断器
+	limiter     *Limiter        // 服务级并发限制
+	outlier     *BreakerOptions // 实例级被动异常检测配置
+	retry       *RetryPolicy
+	timeout     time.Duration
+	commands    map[string]*CommandPolicy
+	grpc        *GRPCOptions
+	variants    []*Variant
+}
+
+type ServiceOption func(s *Service)
+
+// WithHealthCheck 为服务开启主动健康检查
+func WithHealthCheck(opts HealthCheckOptions) ServiceOption {
+	return func(s *Service) {
+		s.healthCheck = &opts
+	}
+}
+
+// WithCircuitBreaker 为服务开启服务级熔断，熔断期间该服务的请求直接失败
+func WithCircuitBreaker(opts BreakerOptions) ServiceOption {
+	return func(s *Service) {
+		s.breaker = NewBreaker(opts, func(state BreakerState) {
+			upstreamCircuitState.WithLabelValues(s.Name, "").Set(float64(state))
+		})
+	}
+}
+
+// WithConcurrencyLimit 限制服务的进行中请求数，超过上限的请求排队等待或直接拒绝
+func WithConcurrencyLimit(opts LimiterOptions) ServiceOption {
+	return func(s *Service) {
+		s.limiter = NewLimiter(opts, func(limit, inFlight int, queued [numPriorities]int) {
+			upstreamConcurrencyLimit.WithLabelValues(s.Name).Set(float64(limit))
+			upstreamInFlightRequests.WithLabelValues(s.Name).Set(float64(inFlight))
+			for p, n := range queued {
+				upstreamQueuedRequests.WithLabelValues(s.Name, Priority(p).String()).Set(float64(n))
+			}
+		})
+	}
+}
+
+// WithOutlierDetection 为服务的每个实例开启被动异常检测，异常实例在冷却期内不参与负载均衡
+func WithOutlierDetection(opts BreakerOptions) ServiceOption {
+	return func(s *Service) {
+		s.outlier = &opts
+	}
+}
+
+// WithRetryPolicy 设置服务级重试策略
+func WithRetryPolicy(policy RetryPolicy) ServiceOption {
+	return func(s *Service) {
+		policy = policy.withDefaults()
+		s.retry = &policy
+	}
+}
+
+// WithTimeout 设置服务级转发超时
+func WithTimeout(timeout time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.timeout = timeout
+	}
+}
+
+// WithCommands 登记服务允许的 cmd 及其转发策略，登记后未登记的 cmd 将被拒绝
+func WithCommands(commands []CommandPolicy) ServiceOption {
+	return func(s *Service) {
+		s.commands = make(map[string]*CommandPolicy, len(commands))
+		for _, cmd := range commands {
+			if cmd.Retry != nil {
+				retry := cmd.Retry.withDefaults()
+				cmd.Retry = &retry
+			}
+			s.commands[cmd.Name] = &cmd
+		}
+	}
+}
+
+func NewService(name string, instances []*Instance, balancer Balancer, opts ...ServiceOption) *Service {
+	s := &Service{
+		Name:     name,
+		balancer: balancer,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.SetInstances(instances)
+	return s
+}
+
+// Instances 返回服务的全部实例
+func (s *Service) Instances() []*Instance {
+	if instances := s.instances.Load(); instances != nil {
+		return *instances
+	}
+	return nil
+}
+
+// SetInstances 替换服务的实例列表并返回被移除的实例。
+// 地址和权重都未变化的实例沿用原有对象，保留健康检查和熔断状态；已选中被移除实例的请求不受影响
+func (s *Service) SetInstances(instances []*Instance) []*Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.Instances()
+	existing := make(map[string]*Instance, len(current))
+	for _, inst := range current {
+		existing[instanceKey(inst)] = inst
+	}
+
+	next := make([]*Instance, 0, len(instances))
+	seen := make(map[string]struct{}, len(instances))
+	for _, inst := range instances {
+		key := instanceKey(inst)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if cur, ok := existing[key]; ok {
+			next = append(next, cur)
+			continue
+		}
+		s.initInstance(inst)
+		next = append(next, inst)
+	}
+	s.instances.Store(&next)
+
+	var removed []*Instance
+	for _, inst := range current {
+		if _, ok := seen[instanceKey(inst)]; !ok {
+			removed = append(removed, inst)
+			upstreamInstanceHealthy.DeleteLabelValues(s.Name, inst.Addr())
+			upstreamCircuitState.DeleteLabelValues(s.Name, inst.Addr())
+		}
+	}
+	return removed
+}
+
+// initInstance 为新加入的实例创建实例级熔断器
+func (s *Service) initInstance(inst *Instance) {
+	if s.outlier == nil {
+		return
+	}
+	name, addr := s.Name, inst.Addr()
+	inst.breaker = NewBreaker(*s.outlier, func(state BreakerState) {
+		upstreamCircuitState.WithLabelValues(name, addr).Set(float64(state))
+	})
+}
+
+func instanceKey(inst *Instance) string {
+	return inst.Addr() + "#" + strconv.Itoa(inst.Weight)
+}
+
+// LoadBalancer 返回服务的负载均衡策略名称
+func (s *Service) LoadBalancer() string {
+	return StrategyOf(s.balancer)
+}
+
+// HealthCheckPath 返回服务的健康检查路径，未开启健康检查时返回空
+func (s *Service) HealthCheckPath() string {
+	if s.healthCheck == nil {
+		return ""
+	}
+	return s.healthCheck.withDefaults().Path
+}
+
+// RetryPolicy 返回 cmd 生效的重试策略，cmd 未单独配置时使用服务级策略，nil 表示不重试
+func (s *Service) RetryPolicy(cmd string) *RetryPolicy {
+	if policy, ok := s.commands[cmd]; ok && policy.Retry != nil {
+		return policy.Retry
+	}
+	return s.retry
+}
+
+// AllowCommand 判断 cmd 是否已在服务中登记，服务未登记任何 cmd 时不做限制
+func (s *Service) AllowCommand(cmd string) bool {
+	if len(s.commands) == 0 {
+		return true
+	}
+	_, ok := s.commands[cmd]
+	return ok
+}
+
+// HasCommands 返回服务是否登记了 cmd 白名单
+func (s *Service) HasCommands() bool {
+	return len(s.commands) > 0
+}
+
+// Timeout 返回 cmd 生效的转发超时，cmd 未单独配置时使用服务级配置，0 表示不限制
+func (s *Service) Timeout(cmd string) time.Duration {
+	if policy, ok := s.commands[cmd]; ok && policy.Timeout > 0 {
+		return policy.Timeout
+	}
+	return s.timeout
+}
+
+// CachePolicy 返回 cmd 的响应缓存策略，nil 表示不缓存
+func (s *Service) CachePolicy(cmd string) *CachePolicy {
+	if policy, ok := s.commands[cmd]; ok {
+		return policy.Cache
+	}
+	return nil
+}
+
+// CoalescePolicy 返回 cmd 的请求合并策略，nil 表示不合并
+func (s *Service) CoalescePolicy(cmd string) *CoalescePolicy {
+	if policy, ok := s.commands[cmd]; ok {
+		return policy.Coalesce
+	}
+	return nil
+}
+
+// Acquire 按优先级获取服务的并发名额，服务未开启并发限制时返回 nil 名额
+func (s *Service) Acquire(ctx context.Context, priority Priority) (*Permit, error) {
+	return s.limiter.Acquire(ctx, priority)
+}
+
+// Lease 一次实例选取结果，请求结束后必须调用 Done 上报结果
+type Lease struct {
+	Instance *Instance
+
+	svc     *Service
+	svcGen  uint64
+	instGen uint64
+}
+
+// Done 释放实例并将请求结果计入服务级和实例级熔断器
+func (l *Lease) Done(outcome Outcome) {
+	l.Instance.Release()
+	l.Instance.breaker.Record(l.instGen, outcome)
+	l.svc.breaker.Record(l.svcGen, outcome)
+}
+
+// Pick 通过负载均衡器从健康且未被熔断的实例中选出一个实例，
+// exclude 中的实例 (如重试前已失败的实例) 仅在没有其它可用实例时才会被选中
+func (s *Service) Pick(exclude ...*Instance) (*Lease, error) {
+	return s.PickKey("", exclude...)
+}
+
+// Hashed 返回服务是否使用按哈希键选择实例的负载均衡策略
+func (s *Service) Hashed() bool {
+	_, ok := s.balancer.(KeyedBalancer)
+	return ok
+}
+
+// PickKey 与 Pick 相同，负载均衡器支持哈希键时按 key 选择实例
+func (s *Service) PickKey(key string, exclude ...*Instance) (*Lease, error) {
+	svcGen, ok := s.breaker.Allow()
+	if !ok {
+		return nil, ErrCircuitOpen
+	}
+
+	instances := s.Instances()
+	healthy := healthyInstances(instances)
+	if len(healthy) == 0 {
+		s.breaker.Record(svcGen, OutcomeIgnored)
+		return nil, ErrNoHealthyInstance
+	}
+
+	candidates := make([]*Instance, 0, len(healthy))
+	for _, inst := range healthy {
+		if inst.breaker.Ready() {
+			candidates = append(candidates, inst)
+		}
+	}
+	if preferred := withoutAll(candidates, exclude); len(preferred) > 0 {
+		candidates = preferred
+	}
+	for len(candidates) > 0 {
+		var inst *Instance
+		if keyed, ok := s.balancer.(KeyedBalancer); ok {
+			inst = keyed.PickKey(instances, candidates, key)
+		} else {
+			inst = s.balancer.Pick(candidates)
+		}
+		if instGen, ok := inst.breaker.Allow(); ok {
+			inst.Acquire()
+			return &Lease{
+				Instance: inst,
+				svc:      s,
+				svcGen:   svcGen,
+				instGen:  instGen,
+			}, nil
+		}
+		// 并发请求抢占了半开探测名额，换一个实例
+		candidates = without(candidates, inst)
+	}
+
+	s.breaker.Record(svcGen, OutcomeIgnored)
+	return nil, ErrCircuitOpen
+}
+
+func healthyInstances(instances []*Instance) []*Instance {
+	healthy := make([]*Instance, 0, len(instances))
+	for _, inst := range instances {
+		if inst.Healthy() {
+			healthy = append(healthy, inst)
+		}
+	}
+	return healthy
+}
+
+func without(instances []*Instance, target *Instance) []*Instance {
+	return withoutAll(instances, []*Instance{target})
+}
+
+func withoutAll(instances []*Instance, targets []*Instance) []*Instance {
+	if len(targets) == 0 {
+		return instances
+	}
+	result := make([]*Instance, 0, len(instances))
+	for _, inst := range instances {
+		if !slices.Contains(targets, inst) {
+			result = append(result, inst)
+		}
+	}
+	return result
+}
@@ -0,0 +1,148 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// TransportOptions 转发使用的共享 http.Transport 配置，零值字段使用默认值
+type TransportOptions struct {
+	MaxIdleConns          int           // 所有实例的最大空闲连接数
+	MaxIdleConnsPerHost   int           // 单个实例的最大空闲连接数
+	MaxConnsPerHost       int           // 单个实例的最大连接数，0 表示不限制
+	IdleConnTimeout       time.Duration // 空闲连接超时
+	DialTimeout           time.Duration // 建立连接超时
+	KeepAlive             time.Duration // TCP keep-alive 间隔
+	TLSHandshakeTimeout   time.Duration // TLS 握手超时
+	ResponseHeaderTimeout time.Duration // 等待响应头超时，0 表示不限制
+}
+
+func (o TransportOptions) withDefaults() TransportOptions {
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = 1024
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = 256
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = 90 * time.Second
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.KeepAlive <= 0 {
+		o.KeepAlive = 30 * time.Second
+	}
+	if o.TLSHandshakeTimeout <= 0 {
+		o.TLSHandshakeTimeout = 10 * time.Second
+	}
+	return o
+}
+
+// NewTransport 创建所有实例共享的 http.Transport
+func NewTransport(opts TransportOptions) *http.Transport {
+	opts = opts.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          opts.MaxIdleConns,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       opts.MaxConnsPerHost,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// NewStreamTransport 创建 WebSocket/SSE 使用的 http.Transport，连接在 idleTimeout 内没有读写时断开
+func NewStreamTransport(opts TransportOptions, idleTimeout time.Duration) *http.Transport {
+	if idleTimeout <= 0 {
+		idleTimeout = 5 * time.Minute
+	}
+	transport := NewTransport(opts)
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &idleTimeoutConn{Conn: conn, timeout: idleTimeout}, nil
+	}
+	return transport
+}
+
+// idleTimeoutConn 每次读写时顺延连接的超时时间，长时间没有数据往来的连接会因超时断开
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// NewH2CTransport 创建 gRPC 服务使用的 HTTP/2 明文 (h2c) transport
+func NewH2CTransport(opts TransportOptions) *http2.Transport {
+	opts = opts.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ReadIdleTimeout: opts.KeepAlive, // 连接空闲时发送 PING 检测连接是否可用
+		PingTimeout:     opts.DialTimeout,
+	}
+}
+
+// NewReverseProxy 创建指向 target 的反向代理，使用共享的 transport 和复制缓冲池
+func NewReverseProxy(target *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+	proxy.BufferPool = copyBufferPool
+	return proxy
+}
+
+var copyBufferPool = &bufferPool{
+	pool: sync.Pool{
+		New: func() any {
+			buf := make([]byte, 32*1024)
+			return &buf
+		},
+	},
+}
+
+// bufferPool 复用响应复制缓冲区，避免每个请求分配 32KB
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func (p *bufferPool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *bufferPool) Put(buf []byte) {
+	p.pool.Put(&buf)
+}
@@ -0,0 +1,57 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+)
+
+func newBenchmarkBackend(b *testing.B) *url.URL {
+	body := make([]byte, 4*1024)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	b.Cleanup(backend.Close)
+	target, err := url.Parse(backend.URL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return target
+}
+
+func serveBenchmarkRequest(b *testing.B, proxy *httputil.ReverseProxy) {
+	req := httptest.NewRequest(http.MethodGet, "/GetProblem?id=1", nil)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		b.Fatalf("unexpected status %d", rec.Code)
+	}
+}
+
+// BenchmarkReverseProxyPerRequest 每个请求新建反向代理 (旧实现)
+func BenchmarkReverseProxyPerRequest(b *testing.B) {
+	target := newBenchmarkBackend(b)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			serveBenchmarkRequest(b, httputil.NewSingleHostReverseProxy(target))
+		}
+	})
+}
+
+// BenchmarkReverseProxyCached 复用反向代理和共享 transport
+func BenchmarkReverseProxyCached(b *testing.B) {
+	target := newBenchmarkBackend(b)
+	proxy := NewReverseProxy(target, NewTransport(TransportOptions{}))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			serveBenchmarkRequest(b, proxy)
+		}
+	})
+}
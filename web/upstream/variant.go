@@ -0,0 +1,87 @@
+package upstream
+
+import (
+	"math/rand/v2"
+	"strconv"
+)
+
+// StableVariant 未命中任何发布版本时使用的版本名称
+const StableVariant = "stable"
+
+// Variant 服务的发布版本 (如灰度版本)，拥有独立的实例、熔断和健康检查，
+// 路由、cmd 白名单、重试和超时等策略沿用所属服务
+type Variant struct {
+	Name    string
+	Service *Service
+	Percent float64             // 按用户分配到该版本的流量百分比 (0-100)
+	Admin   bool                // 管理员的请求全部进入该版本
+	OptIn   bool                // 允许请求通过请求头或 Cookie 主动选择该版本
+	Users   map[uint64]struct{} // 用户 ID 白名单
+}
+
+// VariantRequest 选择发布版本所需的请求信息
+type VariantRequest struct {
+	UserID uint64 // 未登录为 0
+	Admin  bool   // 是否为管理员
+	OptIn  string // 请求主动选择的版本名称，为空表示未选择
+}
+
+// WithVariants 为服务添加发布版本，按顺序匹配
+func WithVariants(variants []*Variant) ServiceOption {
+	return func(s *Service) {
+		s.variants = variants
+	}
+}
+
+// Variants 返回服务的发布版本
+func (s *Service) Variants() []*Variant {
+	return s.variants
+}
+
+// SelectVariant 为请求选择发布版本，依次按主动选择、用户白名单、管理员和流量百分比匹配，
+// 未命中时返回服务本身和 StableVariant
+func (s *Service) SelectVariant(req VariantRequest) (*Service, string) {
+	if len(s.variants) == 0 {
+		return s, StableVariant
+	}
+	if req.OptIn != "" {
+		if req.OptIn == StableVariant {
+			return s, StableVariant
+		}
+		for _, v := range s.variants {
+			if v.OptIn && v.Name == req.OptIn {
+				return v.Service, v.Name
+			}
+		}
+	}
+	if req.UserID != 0 {
+		for _, v := range s.variants {
+			if _, ok := v.Users[req.UserID]; ok {
+				return v.Service, v.Name
+			}
+		}
+	}
+	if req.Admin {
+		for _, v := range s.variants {
+			if v.Admin {
+				return v.Service, v.Name
+			}
+		}
+	}
+
+	// 已登录用户按用户 ID 分桶，同一用户始终落在同一版本；未登录请求随机分配
+	var bucket float64
+	if req.UserID != 0 {
+		bucket = float64(hashKey(s.Name+"#"+strconv.FormatUint(req.UserID, 10))%10000) / 100
+	} else {
+		bucket = rand.Float64() * 100
+	}
+	var acc float64
+	for _, v := range s.variants {
+		acc += v.Percent
+		if bucket < acc {
+			return v.Service, v.Name
+		}
+	}
+	return s, StableVariant
+}
@@ -16,12 +16,12 @@ func BuildDependency() *web.GinServer {
 		ioc.InitRedis,
 		ioc.InitJWTHandler,
 		ioc.InitProxyHandler,
+		ioc.InitProxyAdminHandler,
 		ioc.InitLRUCache,
+		ioc.InitAuthHandler,
 
 		service.NewAuthService,
 
-		web.NewAuthHandler,
-
 		ioc.InitGinServer,
 	)
 	return &web.GinServer{}
@@ -25,8 +25,9 @@ func BuildDependency() *web.GinServer {
 	db := ioc.InitDB()
 	cache := ioc.InitLRUCache()
 	authService := service.NewAuthService(db, cmdable, logger, cache)
-	authHandler := web.NewAuthHandler(authService, handler, logger)
-	proxyHandler := ioc.InitProxyHandler(logger)
-	ginServer := ioc.InitGinServer(logger, handler, db, cache, authHandler, proxyHandler)
+	authHandler := ioc.InitAuthHandler(logger, authService, handler)
+	proxyHandler := ioc.InitProxyHandler(logger, cmdable)
+	proxyAdminHandler := ioc.InitProxyAdminHandler(logger, cmdable, handler, proxyHandler)
+	ginServer := ioc.InitGinServer(logger, handler, db, cmdable, cache, authHandler, proxyHandler, proxyAdminHandler)
 	return ginServer
 }